@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+)
+
+// certificateFiles are the files of a certificate and its private key
+type certificateFiles struct {
+	certFile string
+	keyFile  string
+}
+
+// certificateStore holds the certificates served to HTTPS clients. They are
+// reloaded when their files change, so that they can be rotated without a
+// restart.
+type certificateStore struct {
+	files        []certificateFiles
+	certificates unsafe.Pointer // *[]tls.Certificate
+}
+
+// newCertificateStore loads the certificates and watches their files until
+// done is closed. The first certificate is served to clients that don't
+// request a name that any of the certificates are valid for.
+func newCertificateStore(files []certificateFiles, done <-chan bool) (*certificateStore, error) {
+	return newCertificateStoreImpl(files, done, func() {})
+}
+
+func newCertificateStoreImpl(files []certificateFiles, done <-chan bool, onUpdate func()) (*certificateStore, error) {
+	c := &certificateStore{files: files}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	watched := make(map[string]struct{})
+	for _, f := range files {
+		for _, fileName := range []string{f.certFile, f.keyFile} {
+			if _, ok := watched[fileName]; ok {
+				continue
+			}
+			watched[fileName] = struct{}{}
+			WatchForUpdates(fileName, done, func() {
+				// The certificate and key files are usually replaced one after
+				// the other, so the first reload can fail as they don't match.
+				if err := c.load(); err != nil {
+					logger.Printf("error reloading TLS certificates, keeping the previous certificates: %v", err)
+				}
+				onUpdate()
+			})
+		}
+	}
+	return c, nil
+}
+
+func (c *certificateStore) load() error {
+	certificates := make([]tls.Certificate, 0, len(c.files))
+	for _, f := range c.files {
+		cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return fmt.Errorf("loading certificate (%s, %s) failed: %v", f.certFile, f.keyFile, err)
+		}
+		// Parse the certificate once, rather than on every handshake when
+		// checking which names it is valid for
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parsing certificate %s failed: %v", f.certFile, err)
+		}
+		certificates = append(certificates, cert)
+	}
+	atomic.StorePointer(&c.certificates, unsafe.Pointer(&certificates))
+	return nil
+}
+
+// GetCertificate returns the first certificate that is valid for the server
+// name requested by the client and supported by it, or the default certificate
+// if there is none. It is used as the tls.Config GetCertificate.
+func (c *certificateStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificates := *(*[]tls.Certificate)(atomic.LoadPointer(&c.certificates))
+	for i := range certificates {
+		if hello.SupportsCertificate(&certificates[i]) == nil {
+			return &certificates[i], nil
+		}
+	}
+	return &certificates[0], nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self signed certificate for the names given,
+// and its key, to certFile and keyFile. The files are replaced by renaming
+// temporary files, as they are when certificates are rotated.
+func writeTestCertificate(t *testing.T, certFile, keyFile string, names ...string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	for fileName, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		tmp := fileName + ".tmp"
+		require.NoError(t, ioutil.WriteFile(tmp, pem.EncodeToMemory(block), 0600))
+		require.NoError(t, os.Rename(tmp, fileName))
+	}
+}
+
+// servedCertificate returns the common name of the certificate served by the
+// certificate store to a client requesting serverName
+func servedCertificate(t *testing.T, c *certificateStore, serverName string) string {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go tls.Server(serverConn, &tls.Config{GetCertificate: c.GetCertificate}).Handshake()
+
+	client := tls.Client(clientConn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	require.NoError(t, client.Handshake())
+	return client.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertificateStoreSNI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := []certificateFiles{
+		{certFile: filepath.Join(dir, "default.crt"), keyFile: filepath.Join(dir, "default.key")},
+		{certFile: filepath.Join(dir, "a.crt"), keyFile: filepath.Join(dir, "a.key")},
+		{certFile: filepath.Join(dir, "b.crt"), keyFile: filepath.Join(dir, "b.key")},
+	}
+	writeTestCertificate(t, files[0].certFile, files[0].keyFile, "default.example.com")
+	writeTestCertificate(t, files[1].certFile, files[1].keyFile, "a.example.com")
+	writeTestCertificate(t, files[2].certFile, files[2].keyFile, "b.example.com", "*.b.example.com")
+
+	done := make(chan bool)
+	defer close(done)
+	c, err := newCertificateStore(files, done)
+	require.NoError(t, err)
+
+	assert.Equal(t, "default.example.com", servedCertificate(t, c, "default.example.com"))
+	assert.Equal(t, "a.example.com", servedCertificate(t, c, "a.example.com"))
+	assert.Equal(t, "b.example.com", servedCertificate(t, c, "www.b.example.com"))
+	assert.Equal(t, "default.example.com", servedCertificate(t, c, "unknown.example.com"))
+	assert.Equal(t, "default.example.com", servedCertificate(t, c, ""))
+}
+
+func TestCertificateStoreReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificates")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	files := []certificateFiles{
+		{certFile: filepath.Join(dir, "tls.crt"), keyFile: filepath.Join(dir, "tls.key")},
+	}
+	writeTestCertificate(t, files[0].certFile, files[0].keyFile, "old.example.com")
+
+	updated := make(chan bool, 10)
+	done := make(chan bool)
+	defer close(done)
+	c, err := newCertificateStoreImpl(files, done, func() { updated <- true })
+	require.NoError(t, err)
+	assert.Equal(t, "old.example.com", servedCertificate(t, c, ""))
+
+	writeTestCertificate(t, files[0].certFile, files[0].keyFile, "new.example.com")
+	deadline := time.After(5 * time.Second)
+	for servedCertificate(t, c, "") != "new.example.com" {
+		select {
+		case <-updated:
+		case <-deadline:
+			t.Fatal("the certificate was not reloaded")
+		}
+	}
+
+	// An invalid certificate keeps the previous certificate
+	require.NoError(t, ioutil.WriteFile(files[0].certFile, []byte("invalid"), 0600))
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the certificate file change was not detected")
+	}
+	assert.Equal(t, "new.example.com", servedCertificate(t, c, ""))
+}
+
+func TestCertificateStoreInvalidFiles(t *testing.T) {
+	_, err := newCertificateStore([]certificateFiles{{certFile: "/nonexistent.crt", keyFile: "/nonexistent.key"}}, nil)
+	assert.Error(t, err)
+}
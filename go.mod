@@ -13,11 +13,14 @@ require (
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/justinas/alice v1.2.0
 	github.com/kr/pretty v0.2.0 // indirect
+	github.com/lib/pq v1.7.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/mbland/hmacauth v0.0.0-20170912233209-44256dfd4bfa
 	github.com/mitchellh/mapstructure v1.1.2
 	github.com/onsi/ginkgo v1.12.0
 	github.com/onsi/gomega v1.9.0
 	github.com/pquerna/cachecontrol v0.0.0-20180517163645-1555304b9b35 // indirect
+	github.com/prometheus/client_golang v1.2.1
 	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.6.3
 	github.com/stretchr/testify v1.5.1
@@ -29,4 +32,5 @@ require (
 	google.golang.org/api v0.20.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/square/go-jose.v2 v2.4.1
+	gopkg.in/yaml.v2 v2.2.4
 )
@@ -1,72 +1,116 @@
 package main
 
 import (
-	"crypto/sha1"
-	"encoding/base64"
-	"encoding/csv"
+	"bufio"
+	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync/atomic"
+	"unsafe"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // Lookup passwords in a htpasswd file
-// Passwords must be generated with -B for bcrypt or -s for SHA1.
+// Passwords may be bcrypt (htpasswd -B), SHA1 (-s), APR1-MD5 (-m),
+// SHA-256 crypt, SHA-512 crypt or argon2id hashes.
 
 // HtpasswdFile represents the structure of an htpasswd file
 type HtpasswdFile struct {
-	Users map[string]string
+	users unsafe.Pointer // *map[string]htpasswdHash
 }
 
-// NewHtpasswdFromFile constructs an HtpasswdFile from the file at the path given
-func NewHtpasswdFromFile(path string) (*HtpasswdFile, error) {
-	r, err := os.Open(path)
-	if err != nil {
+// NewHtpasswdFromFile constructs an HtpasswdFile from the file at the path
+// given. The file is reloaded whenever it changes until done is closed. If the
+// changed file has invalid entries they are logged and the previous users are
+// kept.
+func NewHtpasswdFromFile(path string, done <-chan bool) (*HtpasswdFile, error) {
+	return newHtpasswdFromFile(path, done, func() {})
+}
+
+func newHtpasswdFromFile(path string, done <-chan bool, onUpdate func()) (*HtpasswdFile, error) {
+	h := &HtpasswdFile{}
+	if err := h.loadFile(path); err != nil {
 		return nil, err
 	}
-	defer r.Close()
-	return NewHtpasswd(r)
+	WatchForUpdates(path, done, func() {
+		if err := h.loadFile(path); err != nil {
+			logger.Printf("error reloading htpasswd file %s, keeping the previous users: %v", path, err)
+		}
+		onUpdate()
+	})
+	return h, nil
 }
 
 // NewHtpasswd  consctructs an HtpasswdFile from an io.Reader (opened file)
 func NewHtpasswd(file io.Reader) (*HtpasswdFile, error) {
-	csvReader := csv.NewReader(file)
-	csvReader.Comma = ':'
-	csvReader.Comment = '#'
-	csvReader.TrimLeadingSpace = true
-
-	records, err := csvReader.ReadAll()
+	users, err := parseHtpasswd(file)
 	if err != nil {
 		return nil, err
 	}
-	h := &HtpasswdFile{Users: make(map[string]string)}
-	for _, record := range records {
-		h.Users[record[0]] = record[1]
-	}
+	h := &HtpasswdFile{}
+	atomic.StorePointer(&h.users, unsafe.Pointer(&users))
 	return h, nil
 }
 
-// Validate checks a users password against the HtpasswdFile entries
-func (h *HtpasswdFile) Validate(user string, password string) bool {
-	realPassword, exists := h.Users[user]
-	if !exists {
-		return false
+func (h *HtpasswdFile) loadFile(path string) error {
+	r, err := os.Open(path)
+	if err != nil {
+		return err
 	}
+	defer r.Close()
 
-	shaPrefix := realPassword[:5]
-	if shaPrefix == "{SHA}" {
-		shaValue := realPassword[5:]
-		d := sha1.New()
-		d.Write([]byte(password))
-		return shaValue == base64.StdEncoding.EncodeToString(d.Sum(nil))
+	users, err := parseHtpasswd(r)
+	if err != nil {
+		return err
+	}
+	atomic.StorePointer(&h.users, unsafe.Pointer(&users))
+	return nil
+}
+
+// parseHtpasswd parses the users and their password hashes. All invalid
+// entries are reported, with their line number.
+func parseHtpasswd(file io.Reader) (map[string]htpasswdHash, error) {
+	users := make(map[string]htpasswdHash)
+	var invalid []string
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			invalid = append(invalid, fmt.Sprintf("line %d: must be of the form user:hash", line))
+			continue
+		}
+		user := parts[0]
+		hash, err := parseHtpasswdHash(strings.TrimSpace(parts[1]))
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("line %d (%s): %v", line, user, err))
+			continue
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	bcryptPrefix := realPassword[:4]
-	if bcryptPrefix == "$2a$" || bcryptPrefix == "$2b$" || bcryptPrefix == "$2x$" || bcryptPrefix == "$2y$" {
-		return bcrypt.CompareHashAndPassword([]byte(realPassword), []byte(password)) == nil
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("invalid htpasswd entries:\n  %s", strings.Join(invalid, "\n  "))
 	}
+	return users, nil
+}
 
-	logger.Printf("Invalid htpasswd entry for %s. Must be a SHA or bcrypt entry.", user)
-	return false
+// Validate checks a users password against the HtpasswdFile entries
+func (h *HtpasswdFile) Validate(user string, password string) bool {
+	users := *(*map[string]htpasswdHash)(atomic.LoadPointer(&h.users))
+	hash, exists := users[user]
+	if !exists {
+		return false
+	}
+	return hash.Verify(password)
 }
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswdHash verifies passwords against a password hash from a htpasswd file
+type htpasswdHash interface {
+	Verify(password string) bool
+}
+
+// parseHtpasswdHash parses a password hash in one of the formats supported by
+// htpasswd files
+func parseHtpasswdHash(h string) (htpasswdHash, error) {
+	switch {
+	case strings.HasPrefix(h, "{SHA}"):
+		return parseSHA1Hash(h)
+	case strings.HasPrefix(h, "$2a$"), strings.HasPrefix(h, "$2b$"),
+		strings.HasPrefix(h, "$2x$"), strings.HasPrefix(h, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(h)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash: %v", err)
+		}
+		return bcryptHash(h), nil
+	case strings.HasPrefix(h, apr1Magic):
+		return parseAPR1Hash(h)
+	case strings.HasPrefix(h, sha256CryptMagic):
+		return parseSHACryptHash(h, sha256CryptMagic, sha256.New, sha256CryptOrder)
+	case strings.HasPrefix(h, sha512CryptMagic):
+		return parseSHACryptHash(h, sha512CryptMagic, sha512.New, sha512CryptOrder)
+	case strings.HasPrefix(h, "$argon2id$"):
+		return parseArgon2idHash(h)
+	default:
+		return nil, errors.New("unsupported password hash, must be a bcrypt, SHA, APR1-MD5, SHA-256 crypt, SHA-512 crypt or argon2id hash")
+	}
+}
+
+// sha1Hash is a "{SHA}" hash: the base64 encoded SHA-1 of the password
+type sha1Hash []byte
+
+func parseSHA1Hash(h string) (htpasswdHash, error) {
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(h, "{SHA}"))
+	if err != nil || len(sum) != sha1.Size {
+		return nil, errors.New("invalid SHA hash")
+	}
+	return sha1Hash(sum), nil
+}
+
+func (h sha1Hash) Verify(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare(h, sum[:]) == 1
+}
+
+type bcryptHash []byte
+
+func (h bcryptHash) Verify(password string) bool {
+	return bcrypt.CompareHashAndPassword(h, []byte(password)) == nil
+}
+
+// cryptAlphabet is the base64 alphabet of crypt(3) hashes
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// cryptEncode encodes sum in the crypt(3) base64 encoding. Each group of
+// three bytes of sum, taken in the order given, is encoded to four
+// characters, least significant bits first. A final group of one or two
+// bytes is encoded to two or three characters.
+func cryptEncode(sum []byte, order []int) string {
+	var b strings.Builder
+	for i := 0; i < len(order); i += 3 {
+		var w uint
+		n := 1
+		for j := i; j < i+3 && j < len(order); j++ {
+			w = w<<8 | uint(sum[order[j]])
+			n++
+		}
+		for ; n > 0; n-- {
+			b.WriteByte(cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	return b.String()
+}
+
+const apr1Magic = "$apr1$"
+
+// apr1Order is the order in which the bytes of an APR1-MD5 digest are encoded
+var apr1Order = []int{0, 6, 12, 1, 7, 13, 2, 8, 14, 3, 9, 15, 4, 10, 5, 11}
+
+// apr1Hash is an Apache "$apr1$" hash, a variant of the MD5 crypt(3) hash
+type apr1Hash struct {
+	salt string
+	sum  string
+}
+
+func parseAPR1Hash(h string) (htpasswdHash, error) {
+	parts := strings.Split(strings.TrimPrefix(h, apr1Magic), "$")
+	if len(parts) != 2 || len(parts[0]) > 8 || len(parts[1]) != 22 {
+		return nil, errors.New("invalid APR1-MD5 hash")
+	}
+	return apr1Hash{salt: parts[0], sum: parts[1]}, nil
+}
+
+func (h apr1Hash) Verify(password string) bool {
+	pw := []byte(password)
+	salt := []byte(h.salt)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write(salt)
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	d := md5.New()
+	d.Write(pw)
+	d.Write([]byte(apr1Magic))
+	d.Write(salt)
+	for n := len(pw); n > 0; n -= md5.Size {
+		if n > md5.Size {
+			d.Write(altSum)
+		} else {
+			d.Write(altSum[:n])
+		}
+	}
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	sum := d.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i&1 == 1 {
+			d.Write(pw)
+		} else {
+			d.Write(sum)
+		}
+		if i%3 != 0 {
+			d.Write(salt)
+		}
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+		if i&1 == 1 {
+			d.Write(sum)
+		} else {
+			d.Write(pw)
+		}
+		sum = d.Sum(nil)
+	}
+
+	encoded := cryptEncode(sum, apr1Order)
+	return subtle.ConstantTimeCompare([]byte(encoded), []byte(h.sum)) == 1
+}
+
+const (
+	sha256CryptMagic = "$5$"
+	sha512CryptMagic = "$6$"
+
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSalt       = 16
+)
+
+// The orders in which the bytes of SHA crypt digests are encoded
+var (
+	sha256CryptOrder = []int{
+		0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14,
+		15, 25, 5, 6, 16, 26, 27, 7, 17, 18, 28, 8, 9, 19, 29,
+		31, 30,
+	}
+	sha512CryptOrder = []int{
+		0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+		47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51,
+		31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35,
+		15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+		62, 20, 41, 63,
+	}
+)
+
+// shaCryptHash is a "$5$" (SHA-256) or "$6$" (SHA-512) crypt(3) hash
+type shaCryptHash struct {
+	newHash func() hash.Hash
+	order   []int
+	rounds  int
+	salt    string
+	sum     string
+}
+
+func parseSHACryptHash(h string, magic string, newHash func() hash.Hash, order []int) (htpasswdHash, error) {
+	rest := strings.TrimPrefix(h, magic)
+	rounds := shaCryptDefaultRounds
+	if strings.HasPrefix(rest, "rounds=") {
+		parts := strings.SplitN(strings.TrimPrefix(rest, "rounds="), "$", 2)
+		n, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s hash: invalid rounds", shaCryptName(magic))
+		}
+		rounds = n
+		if rounds < shaCryptMinRounds {
+			rounds = shaCryptMinRounds
+		}
+		if rounds > shaCryptMaxRounds {
+			rounds = shaCryptMaxRounds
+		}
+		rest = parts[1]
+	}
+
+	i := strings.LastIndex(rest, "$")
+	encodedLen := (len(order)*8 + 5) / 6
+	if i < 0 || len(rest)-i-1 != encodedLen {
+		return nil, fmt.Errorf("invalid %s hash", shaCryptName(magic))
+	}
+	salt := rest[:i]
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+	return shaCryptHash{
+		newHash: newHash,
+		order:   order,
+		rounds:  rounds,
+		salt:    salt,
+		sum:     rest[i+1:],
+	}, nil
+}
+
+func shaCryptName(magic string) string {
+	if magic == sha256CryptMagic {
+		return "SHA-256 crypt"
+	}
+	return "SHA-512 crypt"
+}
+
+func (h shaCryptHash) Verify(password string) bool {
+	pw := []byte(password)
+	salt := []byte(h.salt)
+
+	b := h.newHash()
+	b.Write(pw)
+	b.Write(salt)
+	b.Write(pw)
+	bSum := b.Sum(nil)
+
+	a := h.newHash()
+	a.Write(pw)
+	a.Write(salt)
+	a.Write(repeatBytes(bSum, len(pw)))
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 == 1 {
+			a.Write(bSum)
+		} else {
+			a.Write(pw)
+		}
+	}
+	aSum := a.Sum(nil)
+
+	dp := h.newHash()
+	for i := 0; i < len(pw); i++ {
+		dp.Write(pw)
+	}
+	p := repeatBytes(dp.Sum(nil), len(pw))
+
+	ds := h.newHash()
+	for i := 0; i < 16+int(aSum[0]); i++ {
+		ds.Write(salt)
+	}
+	s := repeatBytes(ds.Sum(nil), len(salt))
+
+	sum := aSum
+	for i := 0; i < h.rounds; i++ {
+		c := h.newHash()
+		if i&1 == 1 {
+			c.Write(p)
+		} else {
+			c.Write(sum)
+		}
+		if i%3 != 0 {
+			c.Write(s)
+		}
+		if i%7 != 0 {
+			c.Write(p)
+		}
+		if i&1 == 1 {
+			c.Write(sum)
+		} else {
+			c.Write(p)
+		}
+		sum = c.Sum(nil)
+	}
+
+	encoded := cryptEncode(sum, h.order)
+	return subtle.ConstantTimeCompare([]byte(encoded), []byte(h.sum)) == 1
+}
+
+// repeatBytes repeats b until it is n bytes long
+func repeatBytes(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		if n-len(out) < len(b) {
+			return append(out, b[:n-len(out)]...)
+		}
+		out = append(out, b...)
+	}
+	return out
+}
+
+// argon2idHash is an argon2id hash in the PHC string format, eg
+// "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>"
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	sum     []byte
+}
+
+func parseArgon2idHash(h string) (htpasswdHash, error) {
+	parts := strings.Split(h, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("invalid argon2id hash: unsupported version %q", parts[2])
+	}
+
+	var a argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.memory, &a.time, &a.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: invalid parameters %q", parts[3])
+	}
+	if a.time == 0 || a.threads == 0 {
+		return nil, fmt.Errorf("invalid argon2id hash: invalid parameters %q", parts[3])
+	}
+
+	var err error
+	if a.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errors.New("invalid argon2id hash: invalid salt")
+	}
+	if a.sum, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(a.sum) == 0 {
+		return nil, errors.New("invalid argon2id hash: invalid hash")
+	}
+	return a, nil
+}
+
+func (h argon2idHash) Verify(password string) bool {
+	sum := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.sum)))
+	return subtle.ConstantTimeCompare(sum, h.sum) == 1
+}
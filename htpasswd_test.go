@@ -2,10 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -36,3 +41,95 @@ func TestBcrypt(t *testing.T) {
 	valid = h.Validate("testuser2", "top-secret")
 	assert.Equal(t, valid, true)
 }
+
+func TestHtpasswdHashes(t *testing.T) {
+	salt := []byte("somesaltsomesalt")
+	argon2Sum := argon2.IDKey([]byte("argon2-password"), salt, 1, 64, 1, 32)
+	argon2Hash := fmt.Sprintf("$argon2id$v=19$m=64,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(argon2Sum))
+
+	testCases := []struct {
+		name     string
+		hash     string
+		password string
+	}{
+		{name: "APR1-MD5", hash: "$apr1$qHDFfhPC$nITSVHgYbDAK1Y0acGRnY0", password: "myPassword"},
+		{name: "SHA-256 crypt", hash: "$5$saltstring$jfZe1.O5rA9aUKHLYLEqMjNCNYEqmZMJ.KAMmcgZPz5", password: "Hello"},
+		{name: "SHA-256 crypt with rounds", hash: "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA", password: "Hello world!"},
+		{name: "SHA-512 crypt", hash: "$6$saltstring$aQzKv7HhksN4CNT5HySRdxOEHxZvlWWP2je/lOgbrHx5iLYj3NJfVnC287n/dwkODYWL1.LZUdO9vX84fkCna/", password: "Hello"},
+		{name: "SHA-512 crypt with rounds and a long salt", hash: "$6$rounds=5000$toolongsaltstrin$iGlL7EUUfzNQx59x3ydJZ.zXPMUu1dOynSEl/vcNhLlas77qD0DzRswhhB6LdrXTz250at0syAfUXra.XrxAI1", password: "Hello world!"},
+		{name: "argon2id", hash: argon2Hash, password: "argon2-password"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := NewHtpasswd(bytes.NewBufferString("testuser:" + tc.hash + "\n"))
+			assert.NoError(t, err)
+
+			assert.True(t, h.Validate("testuser", tc.password))
+			assert.False(t, h.Validate("testuser", tc.password+"x"))
+			assert.False(t, h.Validate("otheruser", tc.password))
+		})
+	}
+}
+
+func TestHtpasswdInvalidEntries(t *testing.T) {
+	contents := `# comment
+testuser1:{SHA}PaVBVZkYqAjCQCu6UBL2xgsnZhw=
+testuser2:abc
+testuser3
+
+testuser4:$apr1$qHDFfhPC$short
+testuser5:$2y$05$short
+testuser6:$argon2id$v=16$m=64,t=1,p=1$c2FsdA$c3Vt
+`
+	_, err := NewHtpasswd(bytes.NewBufferString(contents))
+	assert.EqualError(t, err, "invalid htpasswd entries:\n"+
+		"  line 3 (testuser2): unsupported password hash, must be a bcrypt, SHA, APR1-MD5, SHA-256 crypt, SHA-512 crypt or argon2id hash\n"+
+		"  line 4: must be of the form user:hash\n"+
+		"  line 6 (testuser4): invalid APR1-MD5 hash\n"+
+		"  line 7 (testuser5): invalid bcrypt hash: crypto/bcrypt: hashedSecret too short to be a bcrypted password\n"+
+		"  line 8 (testuser6): invalid argon2id hash: unsupported version \"v=16\"")
+}
+
+func TestHtpasswdFileReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "test_htpasswd_")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("testuser:{SHA}PaVBVZkYqAjCQCu6UBL2xgsnZhw=\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	done := make(chan bool)
+	defer close(done)
+	updated := make(chan bool, 1)
+	h, err := newHtpasswdFromFile(f.Name(), done, func() {
+		select {
+		case updated <- true:
+		default:
+		}
+	})
+	assert.NoError(t, err)
+	assert.True(t, h.Validate("testuser", "asdf"))
+
+	// Replace the file atomically so that it is not reloaded while it is
+	// partially written
+	replaceFile := func(contents string) {
+		tmp := f.Name() + ".new"
+		assert.NoError(t, ioutil.WriteFile(tmp, []byte(contents), 0600))
+		assert.NoError(t, os.Rename(tmp, f.Name()))
+		select {
+		case <-updated:
+		case <-time.After(5 * time.Second):
+			t.Fatal("htpasswd file was not reloaded")
+		}
+	}
+
+	replaceFile("testuser:$apr1$qHDFfhPC$nITSVHgYbDAK1Y0acGRnY0\n")
+	assert.False(t, h.Validate("testuser", "asdf"))
+	assert.True(t, h.Validate("testuser", "myPassword"))
+
+	// Invalid files are not loaded
+	replaceFile("testuser:invalid\n")
+	assert.True(t, h.Validate("testuser", "myPassword"))
+}
@@ -4,13 +4,18 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/metrics"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/util"
 )
 
 // Server represents an HTTP server
@@ -18,15 +23,144 @@ type Server struct {
 	Handler http.Handler
 	Opts    *options.Options
 	stop    chan struct{} // channel for waiting shutdown
+	handler atomic.Value  // holds the *servedHandler serving requests
+
+	initHandlerOnce sync.Once
+
+	shutdownOnce sync.Once
+	shutdown     chan struct{} // closed to shut down all listeners
+	draining     int32         // set to 1 once the server is shutting down
+}
+
+// servedHandler counts the requests in flight on a handler, so that it is
+// known when a handler that has been replaced no longer serves requests
+type servedHandler struct {
+	http.Handler
+
+	mu       sync.Mutex
+	inFlight int
+	replaced bool
+	idle     chan struct{} // closed once replaced and no requests are in flight
+}
+
+func newServedHandler(handler http.Handler) *servedHandler {
+	return &servedHandler{Handler: handler, idle: make(chan struct{})}
+}
+
+// acquire counts a request in flight. It returns false if the handler has
+// been replaced, in which case the request must be served by the current one.
+func (h *servedHandler) acquire() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.replaced {
+		return false
+	}
+	h.inFlight++
+	return true
+}
+
+// release counts a request that has completed
+func (h *servedHandler) release() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inFlight--
+	if h.replaced && h.inFlight == 0 {
+		close(h.idle)
+	}
+}
+
+// replace marks the handler as replaced and returns a channel that is closed
+// once its requests in flight have completed
+func (h *servedHandler) replace() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.replaced = true
+	if h.inFlight == 0 {
+		close(h.idle)
+	}
+	return h.idle
+}
+
+// SetHandler atomically replaces the handler serving requests. Requests in
+// flight complete with the handler that they started with, and the returned
+// channel is closed once they have, so that the resources of the previous
+// handler can then be released. It must not be called concurrently.
+func (s *Server) SetHandler(handler http.Handler) <-chan struct{} {
+	previous := s.currentHandler()
+	s.handler.Store(newServedHandler(handler))
+	return previous.replace()
+}
+
+// currentHandler returns the handler set by SetHandler, or Handler if it has
+// not been called
+func (s *Server) currentHandler() *servedHandler {
+	s.initHandlerOnce.Do(func() {
+		s.handler.Store(newServedHandler(s.Handler))
+	})
+	return s.handler.Load().(*servedHandler)
+}
+
+// serveRequest serves the request with the current handler
+func (s *Server) serveRequest(rw http.ResponseWriter, req *http.Request) {
+	for {
+		// The handler may be replaced between loading and acquiring it
+		h := s.currentHandler()
+		if h.acquire() {
+			defer h.release()
+			h.ServeHTTP(rw, req)
+			return
+		}
+	}
 }
 
-// ListenAndServe will serve traffic on HTTP or HTTPS depending on TLS options
+// ListenAndServe will serve traffic on HTTPS if the TLS options are set, and
+// on HTTP otherwise or if ServeHTTPWithHTTPS is set too. Both listeners are
+// shut down together when a value is sent on stop.
 func (s *Server) ListenAndServe() {
-	if s.Opts.TLSKeyFile != "" || s.Opts.TLSCertFile != "" {
-		s.ServeHTTPS()
-	} else {
+	if s.Opts.TLSKeyFile == "" && s.Opts.TLSCertFile == "" {
 		s.ServeHTTP()
+		return
+	}
+	if !s.Opts.ServeHTTPWithHTTPS {
+		s.ServeHTTPS()
+		return
 	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.ServeHTTP()
+	}()
+	go func() {
+		defer wg.Done()
+		s.ServeHTTPS()
+	}()
+	wg.Wait()
+}
+
+// Ready returns false once the server is shutting down, so that the readiness
+// checks report unhealthy and load balancers stop sending new requests
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.draining) == 0
+}
+
+// shutdownSignal returns a channel that is closed once a value is sent on
+// stop and the drain period has passed, so that every listener is notified
+func (s *Server) shutdownSignal() <-chan struct{} {
+	s.shutdownOnce.Do(func() {
+		s.shutdown = make(chan struct{})
+		go func() {
+			<-s.stop
+			atomic.StoreInt32(&s.draining, 1)
+			if s.Opts.ShutdownDrainPeriod > 0 {
+				logger.Printf("draining connections for %s before shutting down", s.Opts.ShutdownDrainPeriod)
+				time.Sleep(s.Opts.ShutdownDrainPeriod)
+			}
+			close(s.shutdown)
+		}()
+	})
+	return s.shutdown
 }
 
 // ServeHTTP constructs a net.Listener and starts handling HTTP requests
@@ -55,26 +189,17 @@ func (s *Server) ServeHTTP() {
 		logger.Fatalf("FATAL: listen (%s, %s) failed - %s", networkType, listenAddr, err)
 	}
 	logger.Printf("HTTP: listening on %s", listenAddr)
-	s.serve(listener)
+	conns := &connTracker{}
+	s.serve(conns.track(listener), conns)
 	logger.Printf("HTTP: closing %s", listener.Addr())
 }
 
 // ServeHTTPS constructs a net.Listener and starts handling HTTPS requests
 func (s *Server) ServeHTTPS() {
 	addr := s.Opts.HTTPSAddress
-	config := &tls.Config{
-		MinVersion: tls.VersionTLS12,
-		MaxVersion: tls.VersionTLS12,
-	}
-	if config.NextProtos == nil {
-		config.NextProtos = []string{"http/1.1"}
-	}
-
-	var err error
-	config.Certificates = make([]tls.Certificate, 1)
-	config.Certificates[0], err = tls.LoadX509KeyPair(s.Opts.TLSCertFile, s.Opts.TLSKeyFile)
+	config, err := s.tlsConfig()
 	if err != nil {
-		logger.Fatalf("FATAL: loading tls config (%s, %s) failed - %s", s.Opts.TLSCertFile, s.Opts.TLSKeyFile, err)
+		logger.Fatalf("FATAL: loading tls config failed - %s", err)
 	}
 
 	ln, err := net.Listen("tcp", addr)
@@ -83,23 +208,93 @@ func (s *Server) ServeHTTPS() {
 	}
 	logger.Printf("HTTPS: listening on %s", ln.Addr())
 
-	tlsListener := tls.NewListener(tcpKeepAliveListener{ln.(*net.TCPListener)}, config)
-	s.serve(tlsListener)
+	conns := &connTracker{}
+	tlsListener := tls.NewListener(conns.track(tcpKeepAliveListener{ln.(*net.TCPListener)}), config)
+	s.serve(tlsListener, conns)
 	logger.Printf("HTTPS: closing %s", tlsListener.Addr())
 }
 
-func (s *Server) serve(listener net.Listener) {
-	srv := &http.Server{Handler: s.Handler}
+// tlsConfig builds the TLS config of the HTTPS listener. The certificates are
+// reloaded when their files change.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	files := []certificateFiles{{certFile: s.Opts.TLSCertFile, keyFile: s.Opts.TLSKeyFile}}
+	for _, pair := range s.Opts.TLSSNICerts {
+		certFile, keyFile, err := util.ParseCertKeyPair(pair)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, certificateFiles{certFile: certFile, keyFile: keyFile})
+	}
+	certificates, err := newCertificateStore(files, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetCertificate: certificates.GetCertificate,
+		NextProtos:     []string{"http/1.1"},
+	}
+	config.MinVersion, err = util.ParseTLSVersion(s.Opts.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	config.MaxVersion, err = util.ParseTLSVersion(s.Opts.TLSMaxVersion)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Opts.TLSCipherSuites) > 0 {
+		config.CipherSuites, err = util.ParseCipherSuites(s.Opts.TLSCipherSuites)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Opts.TLSClientAuth != "" {
+		config.ClientCAs, err = util.GetCertPool([]string{s.Opts.TLSClientCAFile})
+		if err != nil {
+			return nil, fmt.Errorf("loading client CA file (%s) failed: %v", s.Opts.TLSClientCAFile, err)
+		}
+		config.ClientAuth = tlsClientAuthType(s.Opts.TLSClientAuth)
+	}
+	return config, nil
+}
+
+// tlsClientAuthType returns the client certificate policy for the
+// tls-client-auth mode
+func tlsClientAuthType(mode string) tls.ClientAuthType {
+	switch mode {
+	case options.TLSClientAuthRequire:
+		return tls.RequireAndVerifyClientCert
+	case options.TLSClientAuthOptional:
+		return tls.VerifyClientCertIfGiven
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// serve serves requests on the listener until the server is shut down. conns
+// tracks the connections accepted by the listener, so that the connections
+// that are still open once the HTTP server has shut down, such as hijacked
+// websocket connections, can be closed.
+func (s *Server) serve(listener net.Listener, conns *connTracker) {
+	srv := &http.Server{Handler: http.HandlerFunc(s.serveRequest)}
 
 	// See https://golang.org/pkg/net/http/#Server.Shutdown
+	shutdown := s.shutdownSignal()
 	idleConnsClosed := make(chan struct{})
 	go func() {
-		<-s.stop // wait notification for stopping server
+		<-shutdown // wait notification for stopping server
 
 		// We received an interrupt signal, shut down.
-		if err := srv.Shutdown(context.Background()); err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Opts.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
 			// Error from closing listeners, or context timeout:
 			logger.Printf("HTTP server Shutdown: %v", err)
+			srv.Close()
+		}
+		if n := conns.closeAll(); n > 0 {
+			logger.Printf("HTTP server Shutdown: closed %d hijacked connections", n)
 		}
 		close(idleConnsClosed)
 	}()
@@ -107,10 +302,27 @@ func (s *Server) serve(listener net.Listener) {
 	err := srv.Serve(listener)
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Printf("ERROR: http.Serve() - %s", err)
+		// Shut down the other listeners too
+		select {
+		case s.stop <- struct{}{}:
+		default:
+		}
 	}
 	<-idleConnsClosed
 }
 
+// serveMetrics serves the Prometheus metrics on a separate address so that
+// they are not exposed to clients of the proxy
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	logger.Printf("Metrics: listening on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		logger.Fatalf("FATAL: metrics listen (%s) failed - %s", addr, err)
+	}
+}
+
 // tcpKeepAliveListener sets TCP keep-alive timeouts on accepted
 // connections. It's used by ListenAndServe and ListenAndServeTLS so
 // dead TCP connections (e.g. closing laptop mid-download) eventually
@@ -128,3 +340,68 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 	tc.SetKeepAlivePeriod(3 * time.Minute)
 	return tc, nil
 }
+
+// connTracker tracks the open connections accepted by listeners
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+// track returns a listener that tracks the connections accepted by ln
+func (t *connTracker) track(ln net.Listener) net.Listener {
+	return trackingListener{Listener: ln, tracker: t}
+}
+
+func (t *connTracker) add(c *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns == nil {
+		t.conns = make(map[*trackedConn]struct{})
+	}
+	t.conns[c] = struct{}{}
+}
+
+func (t *connTracker) remove(c *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c)
+}
+
+// closeAll closes the open connections and returns how many there were
+func (t *connTracker) closeAll() int {
+	t.mu.Lock()
+	conns := t.conns
+	t.conns = nil
+	t.mu.Unlock()
+
+	for c := range conns {
+		c.Conn.Close()
+	}
+	return len(conns)
+}
+
+type trackingListener struct {
+	net.Listener
+	tracker *connTracker
+}
+
+func (ln trackingListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &trackedConn{Conn: c, tracker: ln.tracker}
+	ln.tracker.add(tc)
+	return tc, nil
+}
+
+// trackedConn stops being tracked once it is closed
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+}
+
+func (c *trackedConn) Close() error {
+	c.tracker.remove(c)
+	return c.Conn.Close()
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGracefulShutdown(t *testing.T) {
@@ -37,3 +43,187 @@ func TestGracefulShutdown(t *testing.T) {
 
 	assert.Len(t, stop, 0) // check if stop chan is empty
 }
+
+func TestListenAndServeHTTPAndHTTPS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := options.NewOptions()
+	opts.HTTPAddress = freeAddress(t)
+	opts.HTTPSAddress = freeAddress(t)
+	opts.ServeHTTPWithHTTPS = true
+	opts.TLSCertFile = filepath.Join(dir, "tls.crt")
+	opts.TLSKeyFile = filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, opts.TLSCertFile, opts.TLSKeyFile, "localhost")
+
+	stop := make(chan struct{}, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.TLS != nil {
+			rw.Write([]byte("https"))
+		} else {
+			rw.Write([]byte("http"))
+		}
+	})
+	srv := &Server{Handler: handler, Opts: opts, stop: stop}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		srv.ListenAndServe()
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	for url, expected := range map[string]string{
+		"http://" + opts.HTTPAddress + "/":   "http",
+		"https://" + opts.HTTPSAddress + "/": "https",
+	} {
+		var body []byte
+		assert.Eventually(t, func() bool {
+			resp, err := client.Get(url)
+			if err != nil {
+				return false
+			}
+			defer resp.Body.Close()
+			body, err = ioutil.ReadAll(resp.Body)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, expected, string(body))
+	}
+
+	stop <- struct{}{} // emulate catching signals
+	select {
+	case <-stopped:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Both listeners should shut down gracefully but timeout has occurred")
+	}
+	assert.Len(t, stop, 0)
+}
+
+// freeAddress returns a local address with a free port
+func freeAddress(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestGracefulShutdownDrain(t *testing.T) {
+	opts := options.NewOptions()
+	opts.HTTPAddress = freeAddress(t)
+	opts.ShutdownDrainPeriod = 200 * time.Millisecond
+
+	stop := make(chan struct{}, 1)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("OK"))
+	})
+	srv := &Server{Handler: handler, Opts: opts, stop: stop}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		srv.ServeHTTP()
+	}()
+
+	url := "http://" + opts.HTTPAddress + "/"
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, srv.Ready())
+
+	start := time.Now()
+	stop <- struct{}{} // emulate catching signals
+	assert.Eventually(t, func() bool { return !srv.Ready() }, time.Second, time.Millisecond)
+
+	// Requests are still served while draining
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	select {
+	case <-stopped:
+		assert.True(t, time.Since(start) >= opts.ShutdownDrainPeriod)
+	case <-time.After(1 * time.Second):
+		t.Fatal("Server should return gracefully but timeout has occurred")
+	}
+}
+
+func TestGracefulShutdownClosesConnections(t *testing.T) {
+	testCases := []struct {
+		name    string
+		handler func(release <-chan struct{}) http.Handler
+	}{
+		{
+			name: "hijacked connection",
+			handler: func(release <-chan struct{}) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					conn, buf, err := rw.(http.Hijacker).Hijack()
+					if err != nil {
+						return
+					}
+					buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+					buf.Flush()
+					// Keep the connection open, like a websocket
+					<-release
+					conn.Close()
+				})
+			},
+		},
+		{
+			name: "request exceeding the shutdown timeout",
+			handler: func(release <-chan struct{}) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					rw.WriteHeader(http.StatusOK)
+					rw.(http.Flusher).Flush()
+					<-release
+				})
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := options.NewOptions()
+			opts.HTTPAddress = freeAddress(t)
+			opts.ShutdownTimeout = 100 * time.Millisecond
+
+			release := make(chan struct{})
+			defer close(release)
+			stop := make(chan struct{}, 1)
+			srv := &Server{Handler: tc.handler(release), Opts: opts, stop: stop}
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				srv.ServeHTTP()
+			}()
+
+			var conn net.Conn
+			require.Eventually(t, func() bool {
+				var err error
+				conn, err = net.Dial("tcp", opts.HTTPAddress)
+				return err == nil
+			}, time.Second, 10*time.Millisecond)
+			defer conn.Close()
+			_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			require.NoError(t, err)
+			_, err = conn.Read(make([]byte, 1024))
+			require.NoError(t, err)
+
+			stop <- struct{}{} // emulate catching signals
+			select {
+			case <-stopped:
+			case <-time.After(1 * time.Second):
+				t.Fatal("Server should return after the shutdown timeout but timeout has occurred")
+			}
+
+			// The connection has been closed by the server
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+			_, err = ioutil.ReadAll(conn)
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
@@ -16,6 +17,8 @@ import (
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/middleware"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/validation"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
 )
 
 func main() {
@@ -23,6 +26,9 @@ func main() {
 	flagSet := options.NewFlagSet()
 
 	config := flagSet.String("config", "", "path to config file")
+	structuredConfig := flagSet.String("structured-config", "", "path to structured config file (YAML or JSON), applied on top of the other options")
+	watchConfig := flagSet.Bool("watch-config", false, "reload the configuration when the config files change; it is always reloaded on SIGHUP")
+	convertConfig := flagSet.Bool("convert-config", false, "print the options from the config file and flags in the structured config format, then exit")
 	showVersion := flagSet.Bool("version", false, "print version string")
 
 	flagSet.Parse(os.Args[1:])
@@ -32,24 +38,122 @@ func main() {
 		return
 	}
 
-	opts := options.NewOptions()
-	err := options.Load(*config, flagSet, opts)
+	if *convertConfig {
+		opts := options.NewOptions()
+		err := options.Load(*config, flagSet, opts)
+		if err != nil {
+			logger.Printf("ERROR: Failed to load config: %v", err)
+			os.Exit(1)
+		}
+		out, err := yaml.Marshal(options.NewStructuredOptions(opts))
+		if err != nil {
+			logger.Printf("ERROR: Failed to convert config: %v", err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+		return
+	}
+
+	load := func() (*options.Options, error) {
+		return loadOptions(*config, *structuredConfig, flagSet)
+	}
+	opts, err := load()
 	if err != nil {
-		logger.Printf("ERROR: Failed to load config: %v", err)
+		logger.Printf("ERROR: %v", err)
 		os.Exit(1)
 	}
 
-	err = validation.Validate(opts)
+	rand.Seed(time.Now().UnixNano())
+
+	s := &Server{
+		Opts: opts,
+		stop: make(chan struct{}, 1),
+	}
+	build := func(opts *options.Options) (http.Handler, func(), error) {
+		return newHandler(opts, s.Ready)
+	}
+	handler, stopHandler, err := build(opts)
 	if err != nil {
-		logger.Printf("%s", err)
+		logger.Printf("ERROR: Failed to initialise OAuth2 Proxy: %v", err)
 		os.Exit(1)
 	}
+	s.Handler = handler
+
+	if opts.MetricsAddress != "" {
+		go serveMetrics(opts.MetricsAddress)
+	}
+
+	reloader := &configReloader{
+		load:    load,
+		build:   build,
+		server:  s,
+		opts:    opts,
+		stopOld: stopHandler,
+	}
+	if *watchConfig {
+		for _, fileName := range []string{*config, *structuredConfig} {
+			if fileName != "" {
+				WatchForUpdates(fileName, nil, reloader.Reload)
+			}
+		}
+	}
+
+	// Observe signals in background goroutine.
+	go func() {
+		sigint := make(chan os.Signal, 1)
+		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
+		<-sigint
+		s.stop <- struct{}{} // notify having caught signal
+	}()
+	go func() {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for range sighup {
+			logger.Printf("reloading config after SIGHUP")
+			reloader.Reload()
+		}
+	}()
+	s.ListenAndServe()
+	reloader.Stop()
+}
+
+// loadOptions loads the options from the config files, the environment and
+// flags, and validates them
+func loadOptions(config, structuredConfig string, flagSet *pflag.FlagSet) (*options.Options, error) {
+	opts := options.NewOptions()
+	err := options.Load(config, flagSet, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %v", err)
+	}
+
+	if structuredConfig != "" {
+		err = loadStructuredConfig(structuredConfig, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load structured config: %v", err)
+		}
+	}
+
+	err = validation.Validate(opts)
+	if err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
 
-	validator := NewValidator(opts.EmailDomains, opts.AuthenticatedEmailsFile)
+// newHandler builds the handler serving requests from the options. stop
+// releases its resources once it no longer serves requests. The readiness
+// checks report unhealthy once ready returns false.
+func newHandler(opts *options.Options, ready func() bool) (handler http.Handler, stop func(), err error) {
+	done := make(chan bool)
+	validator := newValidatorImpl(opts.EmailDomains, opts.AuthenticatedEmailsFile, done, func() {})
 	oauthproxy, err := NewOAuthProxy(opts, validator)
 	if err != nil {
-		logger.Printf("ERROR: Failed to initialise OAuth2 Proxy: %v", err)
-		os.Exit(1)
+		close(done)
+		return nil, nil, err
+	}
+	stop = func() {
+		close(done)
+		oauthproxy.Stop()
 	}
 
 	if len(opts.Banner) >= 1 {
@@ -68,51 +172,68 @@ func main() {
 
 	if opts.HtpasswdFile != "" {
 		logger.Printf("using htpasswd file %s", opts.HtpasswdFile)
-		oauthproxy.HtpasswdFile, err = NewHtpasswdFromFile(opts.HtpasswdFile)
+		oauthproxy.HtpasswdFile, err = NewHtpasswdFromFile(opts.HtpasswdFile, done)
 		oauthproxy.DisplayHtpasswdForm = opts.DisplayHtpasswdForm
 		if err != nil {
-			logger.Fatalf("FATAL: unable to open %s %s", opts.HtpasswdFile, err)
+			stop()
+			return nil, nil, fmt.Errorf("error loading htpasswd file %s: %v", opts.HtpasswdFile, err)
 		}
 	}
 
-	rand.Seed(time.Now().UnixNano())
-
 	chain := alice.New()
 
-	if opts.ForceHTTPS {
-		_, httpsPort, err := net.SplitHostPort(opts.HTTPSAddress)
-		if err != nil {
-			logger.Fatalf("FATAL: invalid HTTPS address %q: %v", opts.HTTPAddress, err)
-		}
-		chain = chain.Append(middleware.NewRedirectToHTTPS(httpsPort))
-	}
-
 	healthCheckPaths := []string{opts.PingPath}
 	healthCheckUserAgents := []string{opts.PingUserAgent}
+	readinessCheckPaths := []string{opts.ReadyPath}
 	if opts.GCPHealthChecks {
-		healthCheckPaths = append(healthCheckPaths, "/liveness_check", "/readiness_check")
+		healthCheckPaths = append(healthCheckPaths, "/liveness_check")
 		healthCheckUserAgents = append(healthCheckUserAgents, "GoogleHC/1.0")
+		readinessCheckPaths = append(readinessCheckPaths, "/readiness_check")
+	}
+	healthChecks := alice.New(
+		middleware.NewReadinessCheck(readinessCheckPaths, readinessChecks(opts, oauthproxy, ready)),
+		middleware.NewHealthCheck(healthCheckPaths, healthCheckUserAgents),
+	)
+
+	// Requests are counted right after they are logged, so that the metrics
+	// include every response that is logged, eg redirects to HTTPS
+	logging := alice.New(LoggingHandler)
+	if opts.MetricsAddress != "" {
+		logging = logging.Append(middleware.NewRequestMetrics())
 	}
 
 	// To silence logging of health checks, register the health check handler before
 	// the logging handler
 	if opts.Logging.SilencePing {
-		chain = chain.Append(middleware.NewHealthCheck(healthCheckPaths, healthCheckUserAgents), LoggingHandler)
+		chain = chain.Extend(healthChecks).Extend(logging)
 	} else {
-		chain = chain.Append(LoggingHandler, middleware.NewHealthCheck(healthCheckPaths, healthCheckUserAgents))
+		chain = chain.Extend(logging).Extend(healthChecks)
 	}
 
-	s := &Server{
-		Handler: chain.Then(oauthproxy),
-		Opts:    opts,
-		stop:    make(chan struct{}, 1),
+	// Redirect to HTTPS after the health checks, so that they can be served
+	// over HTTP
+	if opts.ForceHTTPS {
+		_, httpsPort, err := net.SplitHostPort(opts.HTTPSAddress)
+		if err != nil {
+			stop()
+			return nil, nil, fmt.Errorf("invalid HTTPS address %q: %v", opts.HTTPSAddress, err)
+		}
+		chain = chain.Append(middleware.NewRedirectToHTTPS(httpsPort))
 	}
-	// Observe signals in background goroutine.
-	go func() {
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
-		<-sigint
-		s.stop <- struct{}{} // notify having caught signal
-	}()
-	s.ListenAndServe()
+
+	return chain.Then(oauthproxy), stop, nil
+}
+
+// loadStructuredConfig applies the structured config file on top of the
+// options loaded from the config file, the environment and flags
+func loadStructuredConfig(fileName string, opts *options.Options) error {
+	structured := options.NewStructuredOptions(opts)
+	if err := options.LoadStructured(fileName, structured); err != nil {
+		return err
+	}
+	if err := validation.ValidateStructured(structured); err != nil {
+		return err
+	}
+	structured.MergeInto(opts)
+	return nil
 }
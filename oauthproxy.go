@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -22,11 +23,16 @@ import (
 	ipapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/ip"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/authorization"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/cookies"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/header"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/ip"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/metrics"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/admin"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/upstream"
 	"github.com/oauth2-proxy/oauth2-proxy/providers"
 	"github.com/yhat/wsutil"
 )
@@ -40,6 +46,14 @@ const (
 	httpsScheme = "https"
 
 	applicationJSON = "application/json"
+
+	// providerNonceSeparator separates the CSRF nonce from the ID of the
+	// chosen provider in the OAuth state and CSRF cookie
+	providerNonceSeparator = "."
+
+	// backChannelLogoutEvent is the event member required in an OIDC
+	// back-channel logout token
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
 )
 
 // SignatureHeaders contains the headers to be signed by the hmac algorithm
@@ -89,34 +103,39 @@ type OAuthProxy struct {
 	AuthOnlyPath      string
 	UserInfoPath      string
 
+	BackChannelLogoutPath string
+	SessionAdminPath      string
+
 	redirectURL             *url.URL // the url to receive requests at
 	whitelistDomains        []string
 	provider                providers.Provider
+	extraProviders          []providers.Provider
 	providerNameOverride    string
 	sessionStore            sessionsapi.SessionStore
 	ProxyPrefix             string
 	SignInMessage           string
 	HtpasswdFile            *HtpasswdFile
 	DisplayHtpasswdForm     bool
+	clientCertAuth          bool
 	serveMux                http.Handler
-	SetXAuthRequest         bool
-	PassBasicAuth           bool
-	SetBasicAuth            bool
+	upstreamPools           []*upstream.Pool
+	upstreamURLs            []*url.URL
 	SkipProviderButton      bool
-	PassUserHeaders         bool
-	BasicAuthPassword       string
-	PassAccessToken         bool
-	SetAuthorization        bool
-	PassAuthorization       bool
-	PreferEmailToUser       bool
+	revokeTokensOnSignOut   bool
 	skipAuthRegex           []string
 	skipAuthPreflight       bool
 	skipJwtBearerTokens     bool
 	mainJwtBearerVerifier   *oidc.IDTokenVerifier
 	extraJwtBearerVerifiers []*oidc.IDTokenVerifier
+	logoutTokenVerifier     *oidc.IDTokenVerifier
+	sessionAdmin            http.Handler
 	compiledRegex           []*regexp.Regexp
+	authorizationRules      *authorization.RuleSet
+	requestHeaders          *header.Injector
+	responseHeaders         *header.Injector
 	templates               *template.Template
 	realClientIPParser      ipapi.RealClientIPParser
+	reverseProxy            bool
 	Banner                  string
 	Footer                  string
 }
@@ -163,6 +182,7 @@ func setProxyErrorHandler(proxy *httputil.ReverseProxy, opts *options.Options) {
 	templates := loadTemplates(opts.CustomTemplatesDir)
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, proxyErr error) {
 		logger.Printf("Error proxying to upstream server: %v", proxyErr)
+		upstream.MarkFailed(r)
 		w.WriteHeader(http.StatusBadGateway)
 		data := struct {
 			Title       string
@@ -231,27 +251,60 @@ func NewWebSocketOrRestReverseProxy(u *url.URL, opts *options.Options, auth hmac
 	}
 }
 
-// NewOAuthProxy creates a new instance of OAuthProxy from the options provided
-func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthProxy, error) {
-	sessionStore, err := sessions.NewSessionStore(&opts.Session, &opts.Cookie)
-	if err != nil {
-		return nil, fmt.Errorf("error initialising session store: %v", err)
+// upstreamTransport returns the transport used to health check upstreams
+func upstreamTransport(opts *options.Options) http.RoundTripper {
+	if opts.SSLUpstreamInsecureSkipVerify {
+		return &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
 	}
+	return nil
+}
 
+// newUpstreamMux maps the paths of the upstreams to handlers serving them. It
+// also returns the HTTP(S) upstreams that aren't balanced in a pool. The pools
+// it creates must be stopped when the mux is no longer used.
+func newUpstreamMux(urls []*url.URL, opts *options.Options, auth hmacauth.HmacAuth) (*http.ServeMux, []*upstream.Pool, []*url.URL, error) {
 	serveMux := http.NewServeMux()
-	var auth hmacauth.HmacAuth
-	if sigData := opts.GetSignatureData(); sigData != nil {
-		auth = hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key),
-			SignatureHeader, SignatureHeaders)
+	var upstreamPools []*upstream.Pool
+	var upstreamURLs []*url.URL
+
+	// HTTP(S) upstreams with the same path are balanced as a pool
+	pools := make(map[string][]*url.URL)
+	for _, u := range urls {
+		if u.Scheme == httpScheme || u.Scheme == httpsScheme {
+			pools[u.Path] = append(pools[u.Path], u)
+		}
 	}
-	for _, u := range opts.GetProxyURLs() {
+	for _, u := range urls {
 		path := u.Path
 		host := u.Host
 		switch u.Scheme {
 		case httpScheme, httpsScheme:
-			logger.Printf("mapping path %q => upstream %q", path, u)
-			proxy := NewWebSocketOrRestReverseProxy(u, opts, auth)
-			serveMux.Handle(path, proxy)
+			backends, ok := pools[path]
+			if !ok {
+				// The pool of this path has already been mapped
+				continue
+			}
+			delete(pools, path)
+			if len(backends) == 1 {
+				logger.Printf("mapping path %q => upstream %q", path, u)
+				proxy := NewWebSocketOrRestReverseProxy(u, opts, auth)
+				serveMux.Handle(path, proxy)
+				upstreamURLs = append(upstreamURLs, u)
+				continue
+			}
+
+			logger.Printf("mapping path %q => %s pool of upstreams %q", path, opts.UpstreamPool.Strategy, backends)
+			pool, err := upstream.NewPool(backends, opts.UpstreamPool, upstreamTransport(opts), func(u *url.URL) http.Handler {
+				return NewWebSocketOrRestReverseProxy(u, opts, auth)
+			})
+			if err != nil {
+				stopPools(upstreamPools)
+				return nil, nil, nil, fmt.Errorf("error initialising upstream pool for %q: %v", path, err)
+			}
+			upstreamPools = append(upstreamPools, pool)
+			serveMux.Handle(path, pool)
 		case "static":
 			responseCode, err := strconv.Atoi(host)
 			if err != nil {
@@ -277,9 +330,112 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 			}
 			serveMux.Handle(path, &uProxy)
 		default:
-			panic(fmt.Sprintf("unknown upstream protocol %s", u.Scheme))
+			stopPools(upstreamPools)
+			return nil, nil, nil, fmt.Errorf("unknown upstream protocol %s", u.Scheme)
+		}
+	}
+	return serveMux, upstreamPools, upstreamURLs, nil
+}
+
+func stopPools(pools []*upstream.Pool) {
+	for _, pool := range pools {
+		pool.Stop()
+	}
+}
+
+// closeSessionStore closes the session store if it holds resources, such as
+// the connections to a database or the sessions of the memory store
+func closeSessionStore(store sessionsapi.SessionStore) {
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Printf("Error closing session store: %v", err)
+		}
+	}
+}
+
+// NewOAuthProxy creates a new instance of OAuthProxy from the options provided
+func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthProxy, error) {
+	templates, err := parseTemplates(opts.CustomTemplatesDir)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing templates: %v", err)
+	}
+
+	authorizationRules, err := authorization.NewRuleSet(opts.AuthorizationRules)
+	if err != nil {
+		return nil, fmt.Errorf("error initialising authorization rules: %v", err)
+	}
+
+	requestHeaders, err := header.NewInjector(append(header.RequestHeaderPresets(opts), opts.InjectRequestHeaders...))
+	if err != nil {
+		return nil, fmt.Errorf("error initialising request headers: %v", err)
+	}
+	responseHeaders, err := header.NewInjector(append(header.ResponseHeaderPresets(opts), opts.InjectResponseHeaders...))
+	if err != nil {
+		return nil, fmt.Errorf("error initialising response headers: %v", err)
+	}
+
+	var logoutTokenVerifier *oidc.IDTokenVerifier
+	if opts.OIDCBackChannelLogout {
+		logoutTokenVerifier = opts.GetOIDCVerifier()
+	}
+
+	var auth hmacauth.HmacAuth
+	if sigData := opts.GetSignatureData(); sigData != nil {
+		auth = hmacauth.NewHmacAuth(sigData.Hash, []byte(sigData.Key),
+			SignatureHeader, SignatureHeaders)
+	}
+	serveMux, upstreamPools, upstreamURLs, err := newUpstreamMux(opts.GetProxyURLs(), opts, auth)
+	if err != nil {
+		return nil, err
+	}
+	router := upstream.NewHostRouter(serveMux)
+	for _, virtualHost := range opts.VirtualHosts {
+		urls := make([]*url.URL, 0, len(virtualHost.Upstreams))
+		for _, raw := range virtualHost.Upstreams {
+			u, err := upstream.ParseURL(raw)
+			if err != nil {
+				stopPools(upstreamPools)
+				return nil, fmt.Errorf("error parsing upstream of virtual hosts %q: %v", virtualHost.Hosts, err)
+			}
+			urls = append(urls, u)
+		}
+
+		logger.Printf("mapping virtual hosts %q", virtualHost.Hosts)
+		hostMux, hostPools, hostURLs, err := newUpstreamMux(urls, opts, auth)
+		upstreamPools = append(upstreamPools, hostPools...)
+		upstreamURLs = append(upstreamURLs, hostURLs...)
+		if err != nil {
+			stopPools(upstreamPools)
+			return nil, err
+		}
+		for _, host := range virtualHost.Hosts {
+			if err := router.Handle(host, hostMux); err != nil {
+				stopPools(upstreamPools)
+				return nil, fmt.Errorf("error initialising virtual host %q: %v", host, err)
+			}
+		}
+	}
+
+	// The session store is created last, as it must be closed if the proxy
+	// can't be created
+	sessionStore, err := sessions.NewSessionStore(&opts.Session, &opts.Cookie)
+	if err != nil {
+		stopPools(upstreamPools)
+		return nil, fmt.Errorf("error initialising session store: %v", err)
+	}
+
+	sessionAdminPath := fmt.Sprintf("%s/admin/sessions", opts.ProxyPrefix)
+	var sessionAdmin http.Handler
+	if opts.Session.AdminToken != "" {
+		store, ok := sessionStore.(sessionsapi.SessionAdmin)
+		if !ok {
+			stopPools(upstreamPools)
+			closeSessionStore(sessionStore)
+			return nil, fmt.Errorf("session store %q does not support the session admin API", opts.Session.Type)
 		}
+		sessionAdmin = admin.NewHandler(sessionAdminPath, opts.Session.AdminToken, store)
 	}
+
 	for _, u := range opts.GetCompiledRegex() {
 		logger.Printf("compiled skip-auth-regex => %q", u)
 	}
@@ -324,36 +480,49 @@ func NewOAuthProxy(opts *options.Options, validator func(string) bool) (*OAuthPr
 		AuthOnlyPath:      fmt.Sprintf("%s/auth", opts.ProxyPrefix),
 		UserInfoPath:      fmt.Sprintf("%s/userinfo", opts.ProxyPrefix),
 
+		BackChannelLogoutPath: fmt.Sprintf("%s/backchannel_logout", opts.ProxyPrefix),
+		SessionAdminPath:      sessionAdminPath,
+
 		ProxyPrefix:             opts.ProxyPrefix,
 		provider:                opts.GetProvider(),
+		extraProviders:          opts.GetExtraProviders(),
 		providerNameOverride:    opts.ProviderName,
 		sessionStore:            sessionStore,
-		serveMux:                serveMux,
+		serveMux:                router,
+		upstreamPools:           upstreamPools,
+		upstreamURLs:            upstreamURLs,
 		redirectURL:             redirectURL,
 		whitelistDomains:        opts.WhitelistDomains,
+		clientCertAuth:          opts.TLSClientAuth != "",
 		skipAuthRegex:           opts.SkipAuthRegex,
 		skipAuthPreflight:       opts.SkipAuthPreflight,
 		skipJwtBearerTokens:     opts.SkipJwtBearerTokens,
 		mainJwtBearerVerifier:   opts.GetOIDCVerifier(),
 		extraJwtBearerVerifiers: opts.GetJWTBearerVerifiers(),
+		logoutTokenVerifier:     logoutTokenVerifier,
+		sessionAdmin:            sessionAdmin,
 		compiledRegex:           opts.GetCompiledRegex(),
+		authorizationRules:      authorizationRules,
+		reverseProxy:            opts.ReverseProxy,
+		requestHeaders:          requestHeaders,
+		responseHeaders:         responseHeaders,
 		realClientIPParser:      opts.GetRealClientIPParser(),
-		SetXAuthRequest:         opts.SetXAuthRequest,
-		PassBasicAuth:           opts.PassBasicAuth,
-		SetBasicAuth:            opts.SetBasicAuth,
-		PassUserHeaders:         opts.PassUserHeaders,
-		BasicAuthPassword:       opts.BasicAuthPassword,
-		PassAccessToken:         opts.PassAccessToken,
-		SetAuthorization:        opts.SetAuthorization,
-		PassAuthorization:       opts.PassAuthorization,
-		PreferEmailToUser:       opts.PreferEmailToUser,
 		SkipProviderButton:      opts.SkipProviderButton,
-		templates:               loadTemplates(opts.CustomTemplatesDir),
+		revokeTokensOnSignOut:   opts.RevokeTokensOnSignOut,
+		templates:               templates,
 		Banner:                  opts.Banner,
 		Footer:                  opts.Footer,
 	}, nil
 }
 
+// Stop releases the resources of the proxy once it no longer serves requests,
+// eg when it has been replaced after the configuration was reloaded or the
+// server has shut down
+func (p *OAuthProxy) Stop() {
+	stopPools(p.upstreamPools)
+	closeSessionStore(p.sessionStore)
+}
+
 // GetRedirectURI returns the redirectURL that the upstream OAuth Provider will
 // redirect clients to once authenticated
 func (p *OAuthProxy) GetRedirectURI(host string) string {
@@ -377,29 +546,31 @@ func (p *OAuthProxy) displayCustomLoginForm() bool {
 	return p.HtpasswdFile != nil && p.DisplayHtpasswdForm
 }
 
-func (p *OAuthProxy) redeemCode(ctx context.Context, host, code string) (s *sessionsapi.SessionState, err error) {
+func (p *OAuthProxy) redeemCode(ctx context.Context, provider providers.Provider, host, code, codeVerifier string) (s *sessionsapi.SessionState, err error) {
 	if code == "" {
 		return nil, errors.New("missing code")
 	}
 	redirectURI := p.GetRedirectURI(host)
-	s, err = p.provider.Redeem(ctx, redirectURI, code)
+	s, err = provider.Redeem(ctx, redirectURI, code, codeVerifier)
 	if err != nil {
+		metrics.IncRedeemFailure(provider.Data().ProviderName)
 		return
 	}
+	s.ProviderID = provider.Data().ID
 
 	if s.Email == "" {
-		s.Email, err = p.provider.GetEmailAddress(ctx, s)
+		s.Email, err = provider.GetEmailAddress(ctx, s)
 	}
 
 	if s.PreferredUsername == "" {
-		s.PreferredUsername, err = p.provider.GetPreferredUsername(ctx, s)
+		s.PreferredUsername, err = provider.GetPreferredUsername(ctx, s)
 		if err != nil && err.Error() == "not implemented" {
 			err = nil
 		}
 	}
 
 	if s.User == "" {
-		s.User, err = p.provider.GetUserName(ctx, s)
+		s.User, err = provider.GetUserName(ctx, s)
 		if err != nil && err.Error() == "not implemented" {
 			err = nil
 		}
@@ -451,17 +622,40 @@ func (p *OAuthProxy) SetCSRFCookie(rw http.ResponseWriter, req *http.Request, va
 // ClearSessionCookie creates a cookie to unset the user's authentication cookie
 // stored in the user's session
 func (p *OAuthProxy) ClearSessionCookie(rw http.ResponseWriter, req *http.Request) error {
-	return p.sessionStore.Clear(rw, req)
+	start := time.Now()
+	err := p.sessionStore.Clear(rw, req)
+	metrics.ObserveSessionStore(metrics.SessionClear, start, err)
+	return err
 }
 
 // LoadCookiedSession reads the user's authentication details from the request
 func (p *OAuthProxy) LoadCookiedSession(req *http.Request) (*sessionsapi.SessionState, error) {
-	return p.sessionStore.Load(req)
+	start := time.Now()
+	session, err := p.sessionStore.Load(req)
+	// Requests without a session are not session store errors
+	if p.hasSessionCookie(req) {
+		metrics.ObserveSessionStore(metrics.SessionLoad, start, err)
+	}
+	return session, err
+}
+
+// hasSessionCookie checks whether the request has a session cookie, which
+// may have been split into several cookies
+func (p *OAuthProxy) hasSessionCookie(req *http.Request) bool {
+	for _, name := range []string{p.CookieName, p.CookieName + "_0"} {
+		if _, err := req.Cookie(name); err == nil {
+			return true
+		}
+	}
+	return false
 }
 
 // SaveSession creates a new session cookie value and sets this on the response
 func (p *OAuthProxy) SaveSession(rw http.ResponseWriter, req *http.Request, s *sessionsapi.SessionState) error {
-	return p.sessionStore.Save(rw, req, s)
+	start := time.Now()
+	err := p.sessionStore.Save(rw, req, s)
+	metrics.ObserveSessionStore(metrics.SessionSave, start, err)
+	return err
 }
 
 // RobotsTxt disallows scraping pages from the OAuthProxy
@@ -504,6 +698,7 @@ func (p *OAuthProxy) SignInPage(rw http.ResponseWriter, req *http.Request, code
 
 	t := struct {
 		ProviderName  string
+		Providers     []signInProvider
 		SignInMessage template.HTML
 		CustomLogin   bool
 		Redirect      string
@@ -522,9 +717,49 @@ func (p *OAuthProxy) SignInPage(rw http.ResponseWriter, req *http.Request, code
 	if p.providerNameOverride != "" {
 		t.ProviderName = p.providerNameOverride
 	}
+	t.Providers = append(t.Providers, signInProvider{Name: t.ProviderName})
+	for _, provider := range p.extraProviders {
+		t.Providers = append(t.Providers, signInProvider{
+			ID:   provider.Data().ID,
+			Name: provider.Data().ProviderName,
+		})
+	}
 	p.templates.ExecuteTemplate(rw, "sign_in.html", t)
 }
 
+// signInProvider is a provider offered on the sign-in page. The ID is empty
+// for the provider configured by the top level options.
+type signInProvider struct {
+	ID   string
+	Name string
+}
+
+// providerByID returns the provider with the given ID, or nil if no such
+// provider is configured. The empty ID is the provider configured by the top
+// level options.
+func (p *OAuthProxy) providerByID(id string) providers.Provider {
+	if id == "" {
+		return p.provider
+	}
+	for _, provider := range p.extraProviders {
+		if provider.Data().ID == id {
+			return provider
+		}
+	}
+	return nil
+}
+
+// requestedProviderID returns the ID of the provider chosen on the sign-in
+// page. It is only read on the start endpoint, as OAuthStart is also called
+// for requests to the upstreams when the provider button is skipped, and
+// their parameters are not meant for the proxy.
+func (p *OAuthProxy) requestedProviderID(req *http.Request) string {
+	if req.URL.Path != p.OAuthStartPath {
+		return ""
+	}
+	return req.FormValue("provider")
+}
+
 // ManualSignIn handles basic auth logins to the proxy
 func (p *OAuthProxy) ManualSignIn(rw http.ResponseWriter, req *http.Request) (string, bool) {
 	if req.Method != "POST" || p.HtpasswdFile == nil {
@@ -650,7 +885,7 @@ func (p *OAuthProxy) IsValidRedirect(redirect string) bool {
 // IsWhitelistedRequest is used to check if auth should be skipped for this request
 func (p *OAuthProxy) IsWhitelistedRequest(req *http.Request) bool {
 	isPreflightRequestAllowed := p.skipAuthPreflight && req.Method == "OPTIONS"
-	return isPreflightRequestAllowed || p.IsWhitelistedPath(req.URL.Path)
+	return isPreflightRequestAllowed || p.IsWhitelistedPath(req.URL.Path) || p.authorizationRules.Skip(req)
 }
 
 // IsWhitelistedPath is used to check if the request path is allowed without auth
@@ -686,6 +921,8 @@ func (p *OAuthProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	switch path := req.URL.Path; {
 	case path == p.RobotsPath:
 		p.RobotsTxt(rw)
+	case path == p.AuthOnlyPath:
+		p.AuthenticateOnly(rw, req)
 	case p.IsWhitelistedRequest(req):
 		p.serveMux.ServeHTTP(rw, req)
 	case path == p.SignInPath:
@@ -696,10 +933,12 @@ func (p *OAuthProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		p.OAuthStart(rw, req)
 	case path == p.OAuthCallbackPath:
 		p.OAuthCallback(rw, req)
-	case path == p.AuthOnlyPath:
-		p.AuthenticateOnly(rw, req)
 	case path == p.UserInfoPath:
 		p.UserInfo(rw, req)
+	case path == p.BackChannelLogoutPath && p.logoutTokenVerifier != nil:
+		p.BackChannelLogout(rw, req)
+	case p.sessionAdmin != nil && (path == p.SessionAdminPath || strings.HasPrefix(path, p.SessionAdminPath+"/")):
+		p.sessionAdmin.ServeHTTP(rw, req)
 	default:
 		p.Proxy(rw, req)
 	}
@@ -756,20 +995,154 @@ func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
 		p.ErrorPage(rw, 500, "Internal Error", err.Error())
 		return
 	}
+
+	if p.hasSessionCookie(req) {
+		session, err := p.LoadCookiedSession(req)
+		if err == nil && session != nil {
+			if provider := p.providerByID(session.ProviderID); provider != nil {
+				if p.revokeTokensOnSignOut {
+					// Sign out goes ahead even if the tokens could not be revoked
+					if err := provider.RevokeToken(req.Context(), session); err != nil {
+						logger.Printf("Error revoking tokens of %s on sign out: %v", session.Email, err)
+					}
+				}
+				if provider.Data().LogoutURL != nil && session.IDToken != "" {
+					redirect = p.buildLogoutURL(provider.Data().LogoutURL, session.IDToken, redirect, req.Host)
+				}
+			}
+		}
+	}
+
 	p.ClearSessionCookie(rw, req)
 	http.Redirect(rw, req, redirect, http.StatusFound)
 }
 
+// buildLogoutURL returns the provider end_session_endpoint for RP-initiated
+// logout, passing the ID token as a hint and the absolute post logout redirect
+func (p *OAuthProxy) buildLogoutURL(logoutURL *url.URL, idToken, redirect, host string) string {
+	postLogoutRedirect := redirect
+	if base, err := url.Parse(p.GetRedirectURI(host)); err == nil {
+		if target, err := url.Parse(redirect); err == nil {
+			postLogoutRedirect = base.ResolveReference(target).String()
+		}
+	}
+
+	u := *logoutURL
+	params := u.Query()
+	params.Set("id_token_hint", idToken)
+	params.Set("post_logout_redirect_uri", postLogoutRedirect)
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// BackChannelLogout validates an OIDC back-channel logout token sent by the
+// provider and revokes any stored sessions matching its subject or session ID
+func (p *OAuthProxy) BackChannelLogout(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Cache-Control", "no-store")
+	if req.Method != http.MethodPost {
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	subject, sessionID, err := p.verifyLogoutToken(req.Context(), req.PostFormValue("logout_token"))
+	if err != nil {
+		logger.Printf("Error validating logout token: %v", err)
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	revoker, ok := p.sessionStore.(sessionsapi.SessionRevoker)
+	if !ok {
+		logger.Printf("Error handling back-channel logout: session store does not support revocation")
+		http.Error(rw, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+	revoked, err := revoker.RevokeSessions(req.Context(), subject, sessionID)
+	if err != nil {
+		logger.Printf("Error revoking sessions for back-channel logout: %v", err)
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	logger.Printf("Back-channel logout revoked %d session(s) (sub=%q sid=%q)", revoked, subject, sessionID)
+	rw.WriteHeader(http.StatusOK)
+}
+
+// verifyLogoutToken checks the signature and claims of a logout token as
+// described in OpenID Connect Back-Channel Logout 1.0 section 2.6
+func (p *OAuthProxy) verifyLogoutToken(ctx context.Context, rawToken string) (string, string, error) {
+	if rawToken == "" {
+		return "", "", errors.New("missing logout_token")
+	}
+	token, err := p.logoutTokenVerifier.Verify(ctx, rawToken)
+	if err != nil {
+		return "", "", err
+	}
+
+	var claims struct {
+		Subject   string                     `json:"sub"`
+		SessionID string                     `json:"sid"`
+		Nonce     *string                    `json:"nonce"`
+		Events    map[string]json.RawMessage `json:"events"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return "", "", fmt.Errorf("failed to parse logout token claims: %v", err)
+	}
+	if _, ok := claims.Events[backChannelLogoutEvent]; !ok {
+		return "", "", errors.New("logout token is missing the back-channel logout event")
+	}
+	if claims.Nonce != nil {
+		return "", "", errors.New("logout token must not contain a nonce")
+	}
+	if claims.Subject == "" && claims.SessionID == "" {
+		return "", "", errors.New("logout token must contain a sub or sid claim")
+	}
+	return claims.Subject, claims.SessionID, nil
+}
+
 // OAuthStart starts the OAuth2 authentication flow
 func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 	prepareNoCache(rw)
+	providerID := p.requestedProviderID(req)
+	provider := p.providerByID(providerID)
+	if provider == nil {
+		logger.Printf("Error starting OAuth2 flow: unknown provider %q", providerID)
+		p.ErrorPage(rw, 400, "Bad Request", "Unknown provider")
+		return
+	}
+
 	nonce, err := encryption.Nonce()
 	if err != nil {
 		logger.Printf("Error obtaining nonce: %s", err.Error())
 		p.ErrorPage(rw, 500, "Internal Error", err.Error())
 		return
 	}
-	p.SetCSRFCookie(rw, req, nonce)
+	if id := provider.Data().ID; id != "" {
+		// Binding the provider to the nonce lets the callback know which
+		// provider to redeem the code with, and that it was not changed
+		nonce = nonce + providerNonceSeparator + id
+	}
+
+	csrfValue := nonce
+	var codeChallenge string
+	if method := provider.Data().CodeChallengeMethod; method != "" {
+		codeVerifier, err := encryption.CodeVerifier()
+		if err != nil {
+			logger.Printf("Error obtaining code verifier: %s", err.Error())
+			p.ErrorPage(rw, 500, "Internal Error", err.Error())
+			return
+		}
+		codeChallenge, err = encryption.CodeChallenge(codeVerifier, method)
+		if err != nil {
+			logger.Printf("Error obtaining code challenge: %s", err.Error())
+			p.ErrorPage(rw, 500, "Internal Error", err.Error())
+			return
+		}
+		// The code verifier is kept alongside the nonce in the CSRF cookie
+		// so that it is available again when the code is redeemed
+		csrfValue = fmt.Sprintf("%v:%v", nonce, codeVerifier)
+	}
+	p.SetCSRFCookie(rw, req, csrfValue)
 	redirect, err := p.GetRedirect(req)
 	if err != nil {
 		logger.Printf("Error obtaining redirect: %s", err.Error())
@@ -777,7 +1150,27 @@ func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 	redirectURI := p.GetRedirectURI(req.Host)
-	http.Redirect(rw, req, p.provider.GetLoginURL(redirectURI, fmt.Sprintf("%v:%v", nonce, redirect)), http.StatusFound)
+	http.Redirect(rw, req, provider.GetLoginURL(redirectURI, fmt.Sprintf("%v:%v", nonce, redirect), codeChallenge), http.StatusFound)
+}
+
+// providerIDFromNonce returns the ID of the provider bound to the nonce of
+// the OAuth state by OAuthStart
+func providerIDFromNonce(nonce string) string {
+	s := strings.SplitN(nonce, providerNonceSeparator, 2)
+	if len(s) == 2 {
+		return s[1]
+	}
+	return ""
+}
+
+// splitCSRFCookieValue separates the nonce from the PKCE code verifier stored
+// in the CSRF cookie. The code verifier is empty when PKCE is not in use.
+func splitCSRFCookieValue(value string) (nonce, codeVerifier string) {
+	s := strings.SplitN(value, ":", 2)
+	if len(s) == 2 {
+		return s[0], s[1]
+	}
+	return value, ""
 }
 
 // OAuthCallback is the OAuth2 authentication flow callback that finishes the
@@ -799,11 +1192,10 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	session, err := p.redeemCode(req.Context(), req.Host, req.Form.Get("code"))
-	if err != nil {
-		logger.Printf("Error redeeming code during OAuth2 callback: %s ", err.Error())
-		p.ErrorPage(rw, 500, "Internal Error", "Internal Error")
-		return
+	var csrfNonce, codeVerifier string
+	c, csrfErr := req.Cookie(p.CSRFCookieName)
+	if csrfErr == nil {
+		csrfNonce, codeVerifier = splitCSRFCookieValue(c.Value)
 	}
 
 	s := strings.SplitN(req.Form.Get("state"), ":", 2)
@@ -814,14 +1206,27 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	}
 	nonce := s[0]
 	redirect := s[1]
-	c, err := req.Cookie(p.CSRFCookieName)
+
+	provider := p.providerByID(providerIDFromNonce(nonce))
+	if provider == nil {
+		logger.Printf("Error while parsing OAuth2 state: unknown provider %q", providerIDFromNonce(nonce))
+		p.ErrorPage(rw, 500, "Internal Error", "Invalid State")
+		return
+	}
+
+	session, err := p.redeemCode(req.Context(), provider, req.Host, req.Form.Get("code"), codeVerifier)
 	if err != nil {
+		logger.Printf("Error redeeming code during OAuth2 callback: %s ", err.Error())
+		p.ErrorPage(rw, 500, "Internal Error", "Internal Error")
+		return
+	}
+	if csrfErr != nil {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: unable too obtain CSRF cookie")
-		p.ErrorPage(rw, 403, "Permission Denied", err.Error())
+		p.ErrorPage(rw, 403, "Permission Denied", csrfErr.Error())
 		return
 	}
 	p.ClearCSRFCookie(rw, req)
-	if c.Value != nonce {
+	if csrfNonce != nonce {
 		logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Invalid authentication via OAuth2: csrf token mismatch, potential attack")
 		p.ErrorPage(rw, 403, "Permission Denied", "csrf failed")
 		return
@@ -832,7 +1237,7 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	// set cookie, or deny
-	if p.Validator(session.Email) && p.provider.ValidateGroup(session.Email) {
+	if p.Validator(session.Email) && provider.Data().ValidateEmailDomain(session.Email) && provider.ValidateGroup(session) {
 		logger.PrintAuthf(session.Email, req, logger.AuthSuccess, "Authenticated via OAuth2: %s", session)
 		err := p.SaveSession(rw, req, session)
 		if err != nil {
@@ -847,19 +1252,67 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// AuthenticateOnly checks whether the user is currently logged in
+// AuthenticateOnly checks whether the user is currently logged in and may
+// access the request that is authenticated, see authRequestTarget
 func (p *OAuthProxy) AuthenticateOnly(rw http.ResponseWriter, req *http.Request) {
+	target := p.authRequestTarget(req)
+	if p.IsWhitelistedRequest(target) {
+		rw.WriteHeader(http.StatusAccepted)
+		return
+	}
+
 	session, err := p.getAuthenticatedSession(rw, req)
 	if err != nil {
 		http.Error(rw, "unauthorized request", http.StatusUnauthorized)
 		return
 	}
 
+	if !p.isAuthorized(target, session) {
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
 	// we are authenticated
 	p.addHeadersForProxying(rw, req, session)
 	rw.WriteHeader(http.StatusAccepted)
 }
 
+// authRequestTarget returns the request that a request to the auth endpoint
+// authenticates. Nginx auth_request and Traefik forwardAuth pass the method,
+// host and URI of the protected request in headers, which are only trusted
+// behind a reverse proxy (--reverse-proxy). Otherwise the request itself is
+// returned.
+func (p *OAuthProxy) authRequestTarget(req *http.Request) *http.Request {
+	if !p.reverseProxy {
+		return req
+	}
+	target := req.Clone(req.Context())
+	if method := firstHeader(req.Header, "X-Forwarded-Method", "X-Original-Method"); method != "" {
+		target.Method = method
+	}
+	if host := req.Header.Get("X-Forwarded-Host"); host != "" {
+		target.Host = host
+	}
+	if uri := firstHeader(req.Header, "X-Forwarded-Uri", "X-Original-URI"); uri != "" {
+		if u, err := url.ParseRequestURI(uri); err == nil {
+			target.URL.Path = u.Path
+			target.URL.RawPath = u.RawPath
+			target.URL.RawQuery = u.RawQuery
+		}
+	}
+	return target
+}
+
+// firstHeader returns the value of the first of the headers that is set
+func firstHeader(header http.Header, names ...string) string {
+	for _, name := range names {
+		if value := header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
 // Proxy proxies the user request if the user is authenticated else it prompts
 // them to authenticate
 func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
@@ -867,6 +1320,10 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	switch err {
 	case nil:
 		// we are authenticated
+		if !p.isAuthorized(req, session) {
+			p.ErrorPage(rw, http.StatusForbidden, "Permission Denied", "Unauthorized")
+			return
+		}
 		p.addHeadersForProxying(rw, req, session)
 		p.serveMux.ServeHTTP(rw, req)
 
@@ -893,6 +1350,16 @@ func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 
 }
 
+// isAuthorized checks the authenticated session against the authorization rules
+// that match the request
+func (p *OAuthProxy) isAuthorized(req *http.Request, session *sessionsapi.SessionState) bool {
+	if p.authorizationRules.Authorize(req, session) {
+		return true
+	}
+	logger.PrintAuthf(session.Email, req, logger.AuthFailure, "Unauthorized request to %s %s: denied by authorization rules", req.Method, req.URL.Path)
+	return false
+}
+
 // getAuthenticatedSession checks whether a user is authenticated and returns a session object and nil error if so
 // Returns nil, ErrNeedsLogin if user needs to login.
 // Set-Cookie headers may be set on the response as a side-effect of calling this method.
@@ -900,6 +1367,7 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 	var session *sessionsapi.SessionState
 	var err error
 	var saveSession, clearSession, revalidated bool
+	provider := p.provider
 
 	if p.skipJwtBearerTokens && req.Header.Get("Authorization") != "" {
 		session, err = p.GetJwtSession(req)
@@ -916,6 +1384,19 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 		session, err = p.LoadCookiedSession(req)
 		if err != nil {
 			logger.Printf("Error loading cookied session: %s", err)
+			if errors.Is(err, sessionsapi.ErrSessionRevoked) {
+				// The cookie of a revoked session can't be used again
+				clearSession = true
+			}
+		}
+
+		if session != nil {
+			provider = p.providerByID(session.ProviderID)
+			if provider == nil {
+				logger.Printf("Removing session: provider %q is not configured %s", session.ProviderID, session)
+				session = nil
+				clearSession = true
+			}
 		}
 
 		if session != nil {
@@ -924,11 +1405,13 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 				saveSession = true
 			}
 
-			if ok, err := p.provider.RefreshSessionIfNeeded(req.Context(), session); err != nil {
+			if ok, err := provider.RefreshSessionIfNeeded(req.Context(), session); err != nil {
 				logger.Printf("%s removing session. error refreshing access token %s %s", remoteAddr, err, session)
+				metrics.IncTokenRefresh(metrics.RefreshError)
 				clearSession = true
 				session = nil
 			} else if ok {
+				metrics.IncTokenRefresh(metrics.RefreshSuccess)
 				saveSession = true
 				revalidated = true
 			}
@@ -943,7 +1426,7 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 	}
 
 	if saveSession && !revalidated && session != nil && session.AccessToken != "" {
-		if !p.provider.ValidateSessionState(req.Context(), session) {
+		if !provider.ValidateSessionState(req.Context(), session) {
 			logger.Printf("Removing session: error validating %s", session)
 			saveSession = false
 			session = nil
@@ -951,7 +1434,7 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 		}
 	}
 
-	if session != nil && session.Email != "" && !p.Validator(session.Email) {
+	if session != nil && session.Email != "" && (!p.Validator(session.Email) || !provider.Data().ValidateEmailDomain(session.Email)) {
 		logger.Printf(session.Email, req, logger.AuthFailure, "Invalid authentication via session: removing session %s", session)
 		session = nil
 		saveSession = false
@@ -977,6 +1460,10 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 		}
 	}
 
+	if session == nil {
+		session = p.CheckClientCert(req)
+	}
+
 	if session == nil {
 		return nil, ErrNeedsLogin
 	}
@@ -986,109 +1473,8 @@ func (p *OAuthProxy) getAuthenticatedSession(rw http.ResponseWriter, req *http.R
 
 // addHeadersForProxying adds the appropriate headers the request / response for proxying
 func (p *OAuthProxy) addHeadersForProxying(rw http.ResponseWriter, req *http.Request, session *sessionsapi.SessionState) {
-	if p.PassBasicAuth {
-		if p.PreferEmailToUser && session.Email != "" {
-			req.SetBasicAuth(session.Email, p.BasicAuthPassword)
-			req.Header["X-Forwarded-User"] = []string{session.Email}
-			req.Header.Del("X-Forwarded-Email")
-		} else {
-			req.SetBasicAuth(session.User, p.BasicAuthPassword)
-			req.Header["X-Forwarded-User"] = []string{session.User}
-			if session.Email != "" {
-				req.Header["X-Forwarded-Email"] = []string{session.Email}
-			} else {
-				req.Header.Del("X-Forwarded-Email")
-			}
-		}
-		if session.PreferredUsername != "" {
-			req.Header["X-Forwarded-Preferred-Username"] = []string{session.PreferredUsername}
-		} else {
-			req.Header.Del("X-Forwarded-Preferred-Username")
-		}
-	}
-
-	if p.PassUserHeaders {
-		if p.PreferEmailToUser && session.Email != "" {
-			req.Header["X-Forwarded-User"] = []string{session.Email}
-			req.Header.Del("X-Forwarded-Email")
-		} else {
-			req.Header["X-Forwarded-User"] = []string{session.User}
-			if session.Email != "" {
-				req.Header["X-Forwarded-Email"] = []string{session.Email}
-			} else {
-				req.Header.Del("X-Forwarded-Email")
-			}
-		}
-
-		if session.PreferredUsername != "" {
-			req.Header["X-Forwarded-Preferred-Username"] = []string{session.PreferredUsername}
-		} else {
-			req.Header.Del("X-Forwarded-Preferred-Username")
-		}
-	}
-
-	if p.SetXAuthRequest {
-		rw.Header().Set("X-Auth-Request-User", session.User)
-		if session.Email != "" {
-			rw.Header().Set("X-Auth-Request-Email", session.Email)
-		} else {
-			rw.Header().Del("X-Auth-Request-Email")
-		}
-		if session.PreferredUsername != "" {
-			rw.Header().Set("X-Auth-Request-Preferred-Username", session.PreferredUsername)
-		} else {
-			rw.Header().Del("X-Auth-Request-Preferred-Username")
-		}
-
-		if p.PassAccessToken {
-			if session.AccessToken != "" {
-				rw.Header().Set("X-Auth-Request-Access-Token", session.AccessToken)
-			} else {
-				rw.Header().Del("X-Auth-Request-Access-Token")
-			}
-		}
-	}
-
-	if p.PassAccessToken {
-		if session.AccessToken != "" {
-			req.Header["X-Forwarded-Access-Token"] = []string{session.AccessToken}
-		} else {
-			req.Header.Del("X-Forwarded-Access-Token")
-		}
-	}
-
-	if p.PassAuthorization {
-		if session.IDToken != "" {
-			req.Header["Authorization"] = []string{fmt.Sprintf("Bearer %s", session.IDToken)}
-		} else {
-			req.Header.Del("Authorization")
-		}
-	}
-	if p.SetBasicAuth {
-		switch {
-		case p.PreferEmailToUser && session.Email != "":
-			authVal := b64.StdEncoding.EncodeToString([]byte(session.Email + ":" + p.BasicAuthPassword))
-			rw.Header().Set("Authorization", "Basic "+authVal)
-		case session.User != "":
-			authVal := b64.StdEncoding.EncodeToString([]byte(session.User + ":" + p.BasicAuthPassword))
-			rw.Header().Set("Authorization", "Basic "+authVal)
-		default:
-			rw.Header().Del("Authorization")
-		}
-	}
-	if p.SetAuthorization {
-		if session.IDToken != "" {
-			rw.Header().Set("Authorization", fmt.Sprintf("Bearer %s", session.IDToken))
-		} else {
-			rw.Header().Del("Authorization")
-		}
-	}
-
-	if session.Email == "" {
-		rw.Header().Set("GAP-Auth", session.User)
-	} else {
-		rw.Header().Set("GAP-Auth", session.Email)
-	}
+	p.requestHeaders.Inject(req.Header, session)
+	p.responseHeaders.Inject(rw.Header(), session)
 }
 
 // CheckBasicAuth checks the requests Authorization header for basic auth
@@ -1121,6 +1507,31 @@ func (p *OAuthProxy) CheckBasicAuth(req *http.Request) (*sessionsapi.SessionStat
 	return nil, nil
 }
 
+// CheckClientCert authenticates requests by the client certificate verified
+// during the TLS handshake. The user is the common name of the certificate
+// subject and the email is its first email SAN, if any.
+func (p *OAuthProxy) CheckClientCert(req *http.Request) *sessionsapi.SessionState {
+	if !p.clientCertAuth || req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+
+	session := &sessionsapi.SessionState{User: cert.Subject.CommonName}
+	if len(cert.EmailAddresses) > 0 {
+		session.Email = cert.EmailAddresses[0]
+	}
+	if session.User == "" {
+		session.User = session.Email
+	}
+	if session.User == "" {
+		logger.PrintAuthf("", req, logger.AuthFailure, "Invalid authentication via client certificate: no common name or email address in %q", cert.Subject)
+		return nil
+	}
+	// Client certificate sessions aren't saved, so a success isn't logged:
+	// it would be logged, and counted by the auth metrics, on every request
+	return session
+}
+
 // isAjax checks if a request is an ajax request
 func isAjax(req *http.Request) bool {
 	acceptValues := req.Header.Values("Accept")
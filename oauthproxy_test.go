@@ -2,8 +2,12 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -22,6 +26,7 @@ import (
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/cookie"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/validation"
@@ -489,7 +494,7 @@ type TestProvider struct {
 	*providers.ProviderData
 	EmailAddress   string
 	ValidToken     bool
-	GroupValidator func(string) bool
+	GroupValidator func(*sessions.SessionState) bool
 }
 
 var _ providers.Provider = (*TestProvider)(nil)
@@ -516,7 +521,7 @@ func NewTestProvider(providerURL *url.URL, emailAddress string) *TestProvider {
 			Scope: "profile.email",
 		},
 		EmailAddress: emailAddress,
-		GroupValidator: func(s string) bool {
+		GroupValidator: func(s *sessions.SessionState) bool {
 			return true
 		},
 	}
@@ -705,6 +710,41 @@ func TestPassUserHeadersWithEmail(t *testing.T) {
 	}
 }
 
+func TestInjectHeaders(t *testing.T) {
+	opts := baseTestOptions()
+	opts.PassBasicAuth = false
+	opts.PassUserHeaders = true
+	opts.InjectRequestHeaders = []options.Header{
+		{Name: "X-Forwarded-User", Values: []options.HeaderValue{{Claim: "email"}}},
+		{Name: "X-Forwarded-Roles", Values: []options.HeaderValue{{Claim: "roles"}}},
+	}
+	opts.InjectResponseHeaders = []options.Header{
+		{Name: "X-Auth-Request-Tenant", Values: []options.HeaderValue{{Value: "example"}}},
+	}
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	session := &sessions.SessionState{
+		User:   "9fcab5c9b889a557",
+		Email:  "john.doe@example.com",
+		Claims: map[string][]string{"roles": {"editor", "viewer"}},
+	}
+
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", opts.ProxyPrefix+"/testCase0", nil)
+	req.Header.Set("X-Forwarded-Preferred-Username", "spoofed")
+	proxy, err := NewOAuthProxy(opts, func(email string) bool {
+		return true
+	})
+	assert.NoError(t, err)
+	proxy.addHeadersForProxying(rw, req, session)
+	assert.Equal(t, []string{"john.doe@example.com"}, req.Header["X-Forwarded-User"])
+	assert.Equal(t, []string{"john.doe@example.com"}, req.Header["X-Forwarded-Email"])
+	assert.Equal(t, []string{"editor,viewer"}, req.Header["X-Forwarded-Roles"])
+	assert.Equal(t, "", req.Header.Get("X-Forwarded-Preferred-Username"))
+	assert.Equal(t, "example", rw.Header().Get("X-Auth-Request-Tenant"))
+}
+
 type PassAccessTokenTest struct {
 	providerServer *httptest.Server
 	proxy          *OAuthProxy
@@ -1023,7 +1063,8 @@ func NewProcessCookieTest(opts ProcessCookieTestOpts, modifiers ...OptionsModifi
 		panic(err)
 	}
 	pcTest.proxy.provider = &TestProvider{
-		ValidToken: opts.providerValidateCookieResponse,
+		ProviderData: &providers.ProviderData{},
+		ValidToken:   opts.providerValidateCookieResponse,
 	}
 
 	// Now, zero-out proxy.CookieRefresh for the cases that don't involve
@@ -1218,6 +1259,130 @@ func TestAuthOnlyEndpointUnauthorizedOnEmailValidationFailure(t *testing.T) {
 	assert.Equal(t, "unauthorized request\n", string(bodyBytes))
 }
 
+func TestAuthOnlyEndpointForbiddenByAuthorizationRules(t *testing.T) {
+	test := NewAuthOnlyEndpointTest(func(opts *options.Options) {
+		opts.AuthorizationRules = []options.AuthorizationRule{
+			{Policy: "allow", Emails: []string{"admin@example.com"}},
+		}
+	})
+	created := time.Now()
+	startSession := &sessions.SessionState{
+		Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created}
+	test.SaveSession(startSession)
+
+	test.proxy.ServeHTTP(test.rw, test.req)
+	assert.Equal(t, http.StatusForbidden, test.rw.Code)
+}
+
+func TestAuthOnlyEndpointAuthorizationRulesMatchForwardedRequest(t *testing.T) {
+	rules := []options.AuthorizationRule{
+		{Policy: "skip", Methods: []string{"GET"}, Path: "^/public/"},
+		{Policy: "allow", Hosts: []string{"admin.example.com"}, Emails: []string{"admin@example.com"}},
+		{Policy: "deny", Methods: []string{"DELETE"}, Path: "^/admin/", EmailDomains: []string{"gsa.gov"}},
+	}
+	testCases := []struct {
+		name         string
+		reverseProxy bool
+		session      bool
+		headers      map[string]string
+		expectedCode int
+	}{
+		{
+			name:         "denied by the forwarded method and URI",
+			reverseProxy: true,
+			session:      true,
+			headers:      map[string]string{"X-Forwarded-Method": "DELETE", "X-Forwarded-Uri": "/admin/users?id=1"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "denied by the original method and URI",
+			reverseProxy: true,
+			session:      true,
+			headers:      map[string]string{"X-Original-Method": "DELETE", "X-Original-URI": "/admin/users"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "not denied for another method",
+			reverseProxy: true,
+			session:      true,
+			headers:      map[string]string{"X-Forwarded-Method": "GET", "X-Forwarded-Uri": "/admin/users"},
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:         "denied by the forwarded host",
+			reverseProxy: true,
+			session:      true,
+			headers:      map[string]string{"X-Forwarded-Host": "admin.example.com", "X-Forwarded-Uri": "/"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "skipped by the forwarded URI",
+			reverseProxy: true,
+			headers:      map[string]string{"X-Forwarded-Uri": "/public/index.html"},
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:         "forwarded headers ignored without --reverse-proxy",
+			session:      true,
+			headers:      map[string]string{"X-Forwarded-Method": "DELETE", "X-Forwarded-Host": "admin.example.com", "X-Forwarded-Uri": "/admin/users"},
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:         "not skipped without --reverse-proxy",
+			headers:      map[string]string{"X-Forwarded-Uri": "/public/index.html"},
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test := NewAuthOnlyEndpointTest(func(opts *options.Options) {
+				opts.AuthorizationRules = rules
+				opts.ReverseProxy = tc.reverseProxy
+			})
+			if tc.session {
+				created := time.Now()
+				err := test.SaveSession(&sessions.SessionState{
+					Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created})
+				require.NoError(t, err)
+				test.rw = httptest.NewRecorder()
+			}
+			for name, value := range tc.headers {
+				test.req.Header.Set(name, value)
+			}
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedCode, test.rw.Code)
+		})
+	}
+}
+
+func TestProxyForbiddenByAuthorizationRules(t *testing.T) {
+	test := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.AuthorizationRules = []options.AuthorizationRule{
+			{Policy: "deny", Path: "^/admin", EmailDomains: []string{"gsa.gov"}},
+		}
+	})
+	test.req, _ = http.NewRequest("GET", "/admin/users", nil)
+	created := time.Now()
+	startSession := &sessions.SessionState{
+		Email: "michael.bland@gsa.gov", AccessToken: "my_access_token", CreatedAt: &created}
+	test.SaveSession(startSession)
+
+	test.proxy.ServeHTTP(test.rw, test.req)
+	assert.Equal(t, http.StatusForbidden, test.rw.Code)
+}
+
+func TestSkipAuthorizationRule(t *testing.T) {
+	test := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+		opts.AuthorizationRules = []options.AuthorizationRule{
+			{Policy: "skip", Methods: []string{"GET"}, Path: "^/public"},
+		}
+	})
+	assert.True(t, test.proxy.IsWhitelistedRequest(httptest.NewRequest("GET", "/public/index.html", nil)))
+	assert.False(t, test.proxy.IsWhitelistedRequest(httptest.NewRequest("POST", "/public/index.html", nil)))
+}
+
 func TestAuthOnlyEndpointSetXAuthRequestHeaders(t *testing.T) {
 	var pcTest ProcessCookieTest
 
@@ -1235,7 +1400,8 @@ func TestAuthOnlyEndpointSetXAuthRequestHeaders(t *testing.T) {
 		panic(err)
 	}
 	pcTest.proxy.provider = &TestProvider{
-		ValidToken: true,
+		ProviderData: &providers.ProviderData{},
+		ValidToken:   true,
 	}
 
 	pcTest.validateUser = true
@@ -1246,13 +1412,84 @@ func TestAuthOnlyEndpointSetXAuthRequestHeaders(t *testing.T) {
 
 	created := time.Now()
 	startSession := &sessions.SessionState{
-		User: "oauth_user", Email: "oauth_user@example.com", AccessToken: "oauth_token", CreatedAt: &created}
+		User: "oauth_user", Email: "oauth_user@example.com", AccessToken: "oauth_token", CreatedAt: &created,
+		Groups: []string{"oauth_group1", "oauth_group2"}}
 	pcTest.SaveSession(startSession)
 
 	pcTest.proxy.ServeHTTP(pcTest.rw, pcTest.req)
 	assert.Equal(t, http.StatusAccepted, pcTest.rw.Code)
 	assert.Equal(t, "oauth_user", pcTest.rw.Header().Get("X-Auth-Request-User"))
 	assert.Equal(t, "oauth_user@example.com", pcTest.rw.Header().Get("X-Auth-Request-Email"))
+	assert.Equal(t, "oauth_group1,oauth_group2", pcTest.rw.Header().Get("X-Auth-Request-Groups"))
+}
+
+func TestAuthOnlyEndpointClientCert(t *testing.T) {
+	testCases := []struct {
+		name           string
+		clientCertAuth bool
+		cert           *x509.Certificate
+		expectedCode   int
+		expectedUser   string
+		expectedEmail  string
+	}{
+		{
+			name:           "common name and email",
+			clientCertAuth: true,
+			cert: &x509.Certificate{
+				Subject:        pkix.Name{CommonName: "machine-client"},
+				EmailAddresses: []string{"machine@example.com", "other@example.com"},
+			},
+			expectedCode:  http.StatusAccepted,
+			expectedUser:  "machine-client",
+			expectedEmail: "machine@example.com",
+		},
+		{
+			name:           "email only",
+			clientCertAuth: true,
+			cert:           &x509.Certificate{EmailAddresses: []string{"machine@example.com"}},
+			expectedCode:   http.StatusAccepted,
+			expectedUser:   "machine@example.com",
+			expectedEmail:  "machine@example.com",
+		},
+		{
+			name:           "no identity",
+			clientCertAuth: true,
+			cert:           &x509.Certificate{Subject: pkix.Name{Organization: []string{"Example"}}},
+			expectedCode:   http.StatusUnauthorized,
+		},
+		{
+			name:           "no certificate",
+			clientCertAuth: true,
+			expectedCode:   http.StatusUnauthorized,
+		},
+		{
+			name:         "client certificate auth disabled",
+			cert:         &x509.Certificate{Subject: pkix.Name{CommonName: "machine-client"}},
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			test := NewAuthOnlyEndpointTest(func(opts *options.Options) {
+				opts.SetXAuthRequest = true
+			})
+			test.proxy.clientCertAuth = tc.clientCertAuth
+			test.req.TLS = &tls.ConnectionState{}
+			if tc.cert != nil {
+				test.req.TLS.VerifiedChains = [][]*x509.Certificate{{tc.cert}}
+			}
+
+			buf := bytes.NewBuffer(nil)
+			logger.SetOutput(buf)
+			defer logger.SetOutput(os.Stdout)
+
+			test.proxy.ServeHTTP(test.rw, test.req)
+			assert.Equal(t, tc.expectedCode, test.rw.Code)
+			assert.Equal(t, tc.expectedUser, test.rw.Header().Get("X-Auth-Request-User"))
+			assert.Equal(t, tc.expectedEmail, test.rw.Header().Get("X-Auth-Request-Email"))
+			assert.NotContains(t, buf.String(), string(logger.AuthSuccess))
+		})
+	}
 }
 
 func TestAuthOnlyEndpointSetBasicAuthTrueRequestHeaders(t *testing.T) {
@@ -1271,7 +1508,8 @@ func TestAuthOnlyEndpointSetBasicAuthTrueRequestHeaders(t *testing.T) {
 		panic(err)
 	}
 	pcTest.proxy.provider = &TestProvider{
-		ValidToken: true,
+		ProviderData: &providers.ProviderData{},
+		ValidToken:   true,
 	}
 
 	pcTest.validateUser = true
@@ -1309,7 +1547,8 @@ func TestAuthOnlyEndpointSetBasicAuthFalseRequestHeaders(t *testing.T) {
 		panic(err)
 	}
 	pcTest.proxy.provider = &TestProvider{
-		ValidToken: true,
+		ProviderData: &providers.ProviderData{},
+		ValidToken:   true,
 	}
 
 	pcTest.validateUser = true
@@ -1355,6 +1594,88 @@ func TestAuthSkippedForPreflightRequests(t *testing.T) {
 	assert.Equal(t, "response", rw.Body.String())
 }
 
+func TestUpstreamPool(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	first := newBackend("first")
+	defer first.Close()
+	second := newBackend("second")
+	defer second.Close()
+
+	opts := baseTestOptions()
+	opts.Upstreams = []string{first.URL + "/app/", second.URL + "/app/"}
+	opts.SkipAuthRegex = []string{"^/app/"}
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return false })
+	assert.NoError(t, err)
+
+	var served []string
+	for i := 0; i < 4; i++ {
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/app/page", nil)
+		proxy.ServeHTTP(rw, req)
+		assert.Equal(t, http.StatusOK, rw.Code)
+		served = append(served, rw.Body.String())
+	}
+	assert.Equal(t, []string{"first", "second", "first", "second"}, served)
+}
+
+func TestVirtualHosts(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + " " + r.URL.Path))
+		}))
+	}
+	defaultBackend := newBackend("default")
+	defer defaultBackend.Close()
+	app1 := newBackend("app1")
+	defer app1.Close()
+	apps := newBackend("apps")
+	defer apps.Close()
+
+	opts := baseTestOptions()
+	opts.Upstreams = []string{defaultBackend.URL}
+	opts.VirtualHosts = []options.VirtualHost{
+		{Hosts: []string{"app1.example.com"}, Upstreams: []string{app1.URL}},
+		{Hosts: []string{"*.apps.example.com"}, Upstreams: []string{apps.URL + "/api/"}},
+	}
+	opts.SkipAuthRegex = []string{"^/"}
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return false })
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		host         string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{host: "app1.example.com", path: "/page", expectedCode: http.StatusOK, expectedBody: "app1 /page"},
+		{host: "app1.example.com:443", path: "/page", expectedCode: http.StatusOK, expectedBody: "app1 /page"},
+		{host: "one.apps.example.com", path: "/api/items", expectedCode: http.StatusOK, expectedBody: "apps /api/items"},
+		// Paths that are not upstreams of the virtual host are not proxied
+		{host: "one.apps.example.com", path: "/page", expectedCode: http.StatusNotFound, expectedBody: "404 page not found\n"},
+		{host: "example.com", path: "/page", expectedCode: http.StatusOK, expectedBody: "default /page"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.host+tc.path, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.Host = tc.host
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			assert.Equal(t, tc.expectedBody, rw.Body.String())
+		})
+	}
+}
+
 type SignatureAuthenticator struct {
 	auth hmacauth.HmacAuth
 }
@@ -1694,7 +2015,7 @@ func TestGetJwtSession(t *testing.T) {
 		opts.SetJWTBearerVerifiers(append(opts.GetJWTBearerVerifiers(), verifier))
 	})
 	tp, _ := test.proxy.provider.(*TestProvider)
-	tp.GroupValidator = func(s string) bool {
+	tp.GroupValidator = func(s *sessions.SessionState) bool {
 		return true
 	}
 
@@ -1833,6 +2154,50 @@ func Test_noCacheHeadersDoesNotExistsInResponseHeadersFromUpstream(t *testing.T)
 	}
 }
 
+func TestOAuthStartWithCodeChallenge(t *testing.T) {
+	opts := baseTestOptions()
+	opts.CodeChallengeMethod = "S256"
+	err := validation.Validate(opts)
+	assert.NoError(t, err)
+	proxy, err := NewOAuthProxy(opts, func(email string) bool {
+		return true
+	})
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/oauth2/start?rd=/", nil)
+	proxy.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusFound, rec.Code)
+
+	var csrfValue string
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == proxy.CSRFCookieName {
+			csrfValue = c.Value
+		}
+	}
+	nonce, codeVerifier := splitCSRFCookieValue(csrfValue)
+	assert.NotEmpty(t, nonce)
+	assert.NotEmpty(t, codeVerifier)
+
+	codeChallenge, err := encryption.CodeChallenge(codeVerifier, "S256")
+	assert.NoError(t, err)
+	loginURL, err := url.Parse(rec.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, codeChallenge, loginURL.Query().Get("code_challenge"))
+	assert.Equal(t, "S256", loginURL.Query().Get("code_challenge_method"))
+	assert.True(t, strings.HasPrefix(loginURL.Query().Get("state"), nonce+":"))
+}
+
+func TestSplitCSRFCookieValue(t *testing.T) {
+	nonce, codeVerifier := splitCSRFCookieValue("nonce")
+	assert.Equal(t, "nonce", nonce)
+	assert.Equal(t, "", codeVerifier)
+
+	nonce, codeVerifier = splitCSRFCookieValue("nonce:verifier")
+	assert.Equal(t, "nonce", nonce)
+	assert.Equal(t, "verifier", codeVerifier)
+}
+
 func baseTestOptions() *options.Options {
 	opts := options.NewOptions()
 	opts.Cookie.Secret = rawCookieSecret
@@ -1841,3 +2206,473 @@ func baseTestOptions() *options.Options {
 	opts.EmailDomains = []string{"*"}
 	return opts
 }
+
+type fakeRevokingSessionStore struct {
+	sessions.SessionStore
+	subject   string
+	sessionID string
+}
+
+func (f *fakeRevokingSessionStore) RevokeSessions(_ context.Context, subject, sessionID string) (int, error) {
+	f.subject = subject
+	f.sessionID = sessionID
+	return 1, nil
+}
+
+type fakeRevokedSessionStore struct {
+	sessions.SessionStore
+}
+
+func (f *fakeRevokedSessionStore) Load(_ *http.Request) (*sessions.SessionState, error) {
+	return nil, fmt.Errorf("error loading session: %w", sessions.ErrSessionRevoked)
+}
+
+func TestRevokedSessionCookieCleared(t *testing.T) {
+	pcTest := NewProcessCookieTestWithOptionsModifiers()
+	pcTest.proxy.sessionStore = &fakeRevokedSessionStore{SessionStore: pcTest.proxy.sessionStore}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: pcTest.proxy.CookieName, Value: "revoked"})
+	rw := httptest.NewRecorder()
+	session, err := pcTest.proxy.getAuthenticatedSession(rw, req)
+	assert.Nil(t, session)
+	assert.Equal(t, ErrNeedsLogin, err)
+
+	cookies := rw.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, pcTest.proxy.CookieName, cookies[0].Name)
+	assert.Equal(t, "", cookies[0].Value)
+	assert.True(t, cookies[0].Expires.Before(time.Now()))
+}
+
+func TestSignOutRPInitiatedLogout(t *testing.T) {
+	testCases := []struct {
+		name             string
+		idToken          string
+		expectedLocation string
+	}{
+		{
+			name:    "with an ID token",
+			idToken: "id.token.value",
+			expectedLocation: "https://provider.example.com/logout?id_token_hint=id.token.value" +
+				"&post_logout_redirect_uri=https%3A%2F%2Fexample.com%2Ffoo",
+		},
+		{
+			name:             "without an ID token",
+			idToken:          "",
+			expectedLocation: "/foo",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pcTest := NewProcessCookieTestWithDefaults()
+			pcTest.proxy.provider = &TestProvider{
+				ProviderData: &providers.ProviderData{
+					LogoutURL: &url.URL{Scheme: "https", Host: "provider.example.com", Path: "/logout"},
+				},
+				ValidToken: true,
+			}
+			pcTest.req = httptest.NewRequest("GET", pcTest.opts.ProxyPrefix+"/sign_out?rd=/foo", nil)
+			err := pcTest.SaveSession(&sessions.SessionState{Email: "john.doe@example.com", IDToken: tc.idToken})
+			require.NoError(t, err)
+
+			rw := httptest.NewRecorder()
+			pcTest.proxy.ServeHTTP(rw, pcTest.req)
+			assert.Equal(t, http.StatusFound, rw.Code)
+			assert.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+		})
+	}
+}
+
+func TestSignOutRevokesTokens(t *testing.T) {
+	var revokedToken string
+	revokeServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		revokedToken = req.PostFormValue("token")
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer revokeServer.Close()
+	revokeURL, err := url.Parse(revokeServer.URL)
+	require.NoError(t, err)
+
+	pcTest := NewProcessCookieTestWithDefaults()
+	pcTest.proxy.provider = &TestProvider{
+		ProviderData: &providers.ProviderData{RevokeURL: revokeURL},
+		ValidToken:   true,
+	}
+	signOut := func() *httptest.ResponseRecorder {
+		pcTest.req = httptest.NewRequest("GET", pcTest.opts.ProxyPrefix+"/sign_out?rd=/foo", nil)
+		err := pcTest.SaveSession(&sessions.SessionState{
+			Email:        "john.doe@example.com",
+			AccessToken:  "access.token",
+			RefreshToken: "refresh.token",
+		})
+		require.NoError(t, err)
+
+		rw := httptest.NewRecorder()
+		pcTest.proxy.ServeHTTP(rw, pcTest.req)
+		return rw
+	}
+
+	// The tokens are only revoked when enabled
+	rw := signOut()
+	assert.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, "", revokedToken)
+
+	pcTest.proxy.revokeTokensOnSignOut = true
+	rw = signOut()
+	assert.Equal(t, http.StatusFound, rw.Code)
+	assert.Equal(t, "/foo", rw.Header().Get("Location"))
+	assert.Equal(t, "refresh.token", revokedToken)
+
+	// The session cookie is cleared even if the tokens can't be revoked
+	revokeServer.Close()
+	pcTest.req = httptest.NewRequest("GET", pcTest.opts.ProxyPrefix+"/sign_out?rd=/foo", nil)
+	err = pcTest.SaveSession(&sessions.SessionState{Email: "john.doe@example.com", AccessToken: "access.token"})
+	require.NoError(t, err)
+
+	rw = httptest.NewRecorder()
+	pcTest.proxy.ServeHTTP(rw, pcTest.req)
+	assert.Equal(t, http.StatusFound, rw.Code)
+	assert.Contains(t, rw.Header().Get("Set-Cookie"), "Expires=")
+}
+
+func TestBackChannelLogout(t *testing.T) {
+	encodeToken := func(claims string) string {
+		return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+	}
+	const event = `"events":{"http://schemas.openid.net/event/backchannel-logout":{}}`
+
+	testCases := []struct {
+		name              string
+		method            string
+		token             string
+		expectedCode      int
+		expectedSubject   string
+		expectedSessionID string
+	}{
+		{
+			name:              "valid token with sid",
+			method:            "POST",
+			token:             encodeToken(`{"iss":"https://issuer.example.com","aud":"client-id","sub":"user1","sid":"session1",` + event + `}`),
+			expectedCode:      http.StatusOK,
+			expectedSubject:   "user1",
+			expectedSessionID: "session1",
+		},
+		{
+			name:            "valid token with only sub",
+			method:          "POST",
+			token:           encodeToken(`{"iss":"https://issuer.example.com","aud":"client-id","sub":"user1",` + event + `}`),
+			expectedCode:    http.StatusOK,
+			expectedSubject: "user1",
+		},
+		{
+			name:         "token without the logout event",
+			method:       "POST",
+			token:        encodeToken(`{"iss":"https://issuer.example.com","aud":"client-id","sub":"user1"}`),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "token with a nonce",
+			method:       "POST",
+			token:        encodeToken(`{"iss":"https://issuer.example.com","aud":"client-id","sub":"user1","nonce":"abc",` + event + `}`),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "token without sub or sid",
+			method:       "POST",
+			token:        encodeToken(`{"iss":"https://issuer.example.com","aud":"client-id",` + event + `}`),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "token for another client",
+			method:       "POST",
+			token:        encodeToken(`{"iss":"https://issuer.example.com","aud":"other","sub":"user1",` + event + `}`),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "missing token",
+			method:       "POST",
+			token:        "",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "GET request",
+			method:       "GET",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier := oidc.NewVerifier("https://issuer.example.com", NoOpKeySet{},
+				&oidc.Config{ClientID: "client-id", SkipExpiryCheck: true})
+			pcTest := NewProcessCookieTestWithOptionsModifiers(func(opts *options.Options) {
+				opts.OIDCBackChannelLogout = true
+				opts.SetOIDCVerifier(verifier)
+			})
+			store := &fakeRevokingSessionStore{SessionStore: pcTest.proxy.sessionStore}
+			pcTest.proxy.sessionStore = store
+
+			form := url.Values{}
+			form.Set("logout_token", tc.token)
+			req := httptest.NewRequest(tc.method, pcTest.opts.ProxyPrefix+"/backchannel_logout", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rw := httptest.NewRecorder()
+			pcTest.proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			assert.Equal(t, "no-store", rw.Header().Get("Cache-Control"))
+			assert.Equal(t, tc.expectedSubject, store.subject)
+			assert.Equal(t, tc.expectedSessionID, store.sessionID)
+		})
+	}
+}
+
+func newMultipleProvidersTest(t *testing.T) (*OAuthProxy, *TestProvider, *TestProvider) {
+	providerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+		w.Write([]byte(`{"access_token": "my_auth_token"}`))
+	}))
+	t.Cleanup(providerServer.Close)
+	providerURL, _ := url.Parse(providerServer.URL)
+
+	opts := baseTestOptions()
+	opts.Cookie.Secret = "xyzzyplughxyzzyplughxyzzyplughxp"
+	opts.Cookie.Secure = false
+	require.NoError(t, validation.Validate(opts))
+
+	employees := NewTestProvider(providerURL, "employee@example.com")
+	contractors := NewTestProvider(providerURL, "contractor@example.com")
+	contractors.ID = "contractors"
+	contractors.ProviderName = "Contractors"
+	contractors.LoginURL.Path = "/contractors/authorize"
+	opts.SetProvider(employees)
+	opts.SetExtraProviders([]providers.Provider{contractors})
+
+	proxy, err := NewOAuthProxy(opts, func(string) bool { return true })
+	require.NoError(t, err)
+	return proxy, employees, contractors
+}
+
+func TestMultipleProvidersSignInPage(t *testing.T) {
+	proxy, _, _ := newMultipleProvidersTest(t)
+
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/sign_in", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	body := rw.Body.String()
+	assert.Contains(t, body, "Sign in with Test Provider")
+	assert.Contains(t, body, "Sign in with Contractors")
+	assert.Contains(t, body, `<input type="hidden" name="provider" value="contractors">`)
+	assert.Equal(t, 1, strings.Count(body, `name="provider"`))
+}
+
+func TestMultipleProvidersOAuthStart(t *testing.T) {
+	proxy, _, _ := newMultipleProvidersTest(t)
+
+	testCases := []struct {
+		name          string
+		provider      string
+		expectedCode  int
+		expectedPath  string
+		expectedNonce string
+	}{
+		{
+			name:         "default provider",
+			provider:     "",
+			expectedCode: http.StatusFound,
+			expectedPath: "/oauth/authorize",
+		},
+		{
+			name:          "extra provider",
+			provider:      "contractors",
+			expectedCode:  http.StatusFound,
+			expectedPath:  "/contractors/authorize",
+			expectedNonce: ".contractors",
+		},
+		{
+			name:         "unknown provider",
+			provider:     "unknown",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/start?rd=/&provider="+tc.provider, nil))
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedCode != http.StatusFound {
+				return
+			}
+
+			loginURL, err := url.Parse(rw.Header().Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPath, loginURL.Path)
+
+			var csrfNonce string
+			for _, c := range rw.Result().Cookies() {
+				if c.Name == proxy.CSRFCookieName {
+					csrfNonce, _ = splitCSRFCookieValue(c.Value)
+				}
+			}
+			assert.Equal(t, csrfNonce+":/", loginURL.Query().Get("state"))
+			if tc.expectedNonce != "" {
+				assert.True(t, strings.HasSuffix(csrfNonce, tc.expectedNonce))
+			} else {
+				assert.NotContains(t, csrfNonce, providerNonceSeparator)
+			}
+		})
+	}
+}
+
+func TestMultipleProvidersOAuthStartPKCE(t *testing.T) {
+	proxy, _, contractors := newMultipleProvidersTest(t)
+	contractors.CodeChallengeMethod = encryption.CodeChallengeMethodS256
+
+	for provider, pkce := range map[string]bool{"": false, "contractors": true} {
+		rw := httptest.NewRecorder()
+		proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/oauth2/start?rd=/&provider="+provider, nil))
+		require.Equal(t, http.StatusFound, rw.Code)
+
+		loginURL, err := url.Parse(rw.Header().Get("Location"))
+		require.NoError(t, err)
+		assert.Equal(t, pkce, loginURL.Query().Get("code_challenge") != "", provider)
+	}
+}
+
+func TestMultipleProvidersSkipProviderButton(t *testing.T) {
+	proxy, _, _ := newMultipleProvidersTest(t)
+	proxy.SkipProviderButton = true
+
+	// The provider parameter of an upstream URL is not read by the proxy
+	rw := httptest.NewRecorder()
+	proxy.ServeHTTP(rw, httptest.NewRequest("GET", "/app?provider=unknown", nil))
+	assert.Equal(t, http.StatusFound, rw.Code)
+
+	loginURL, err := url.Parse(rw.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/oauth/authorize", loginURL.Path)
+}
+
+func TestMultipleProvidersOAuthCallback(t *testing.T) {
+	testCases := []struct {
+		name               string
+		nonce              string
+		emailDomains       []string
+		expectedCode       int
+		expectedEmail      string
+		expectedProviderID string
+	}{
+		{
+			name:          "default provider",
+			nonce:         "nonce",
+			expectedCode:  http.StatusFound,
+			expectedEmail: "employee@example.com",
+		},
+		{
+			name:               "extra provider",
+			nonce:              "nonce.contractors",
+			expectedCode:       http.StatusFound,
+			expectedEmail:      "contractor@example.com",
+			expectedProviderID: "contractors",
+		},
+		{
+			name:               "email domain of the extra provider",
+			nonce:              "nonce.contractors",
+			emailDomains:       []string{"example.com"},
+			expectedCode:       http.StatusFound,
+			expectedEmail:      "contractor@example.com",
+			expectedProviderID: "contractors",
+		},
+		{
+			name:         "rejected by the email domains of the extra provider",
+			nonce:        "nonce.contractors",
+			emailDomains: []string{"partners.example.com"},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "unknown provider",
+			nonce:        "nonce.unknown",
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, _, contractors := newMultipleProvidersTest(t)
+			contractors.EmailDomains = tc.emailDomains
+
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/oauth2/callback?code=callback_code&state="+tc.nonce+":/", nil)
+			req.AddCookie(proxy.MakeCSRFCookie(req, tc.nonce, proxy.CookieExpire, time.Now()))
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedCode != http.StatusFound {
+				return
+			}
+
+			req = httptest.NewRequest("GET", "/", nil)
+			for _, c := range rw.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			session, err := proxy.LoadCookiedSession(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedEmail, session.Email)
+			assert.Equal(t, tc.expectedProviderID, session.ProviderID)
+		})
+	}
+}
+
+func TestMultipleProvidersSessionValidation(t *testing.T) {
+	testCases := []struct {
+		name         string
+		providerID   string
+		expectedCode int
+	}{
+		{
+			name:         "validated by the session provider",
+			providerID:   "contractors",
+			expectedCode: http.StatusAccepted,
+		},
+		{
+			name:         "rejected by the session provider",
+			providerID:   "",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "provider no longer configured",
+			providerID:   "removed",
+			expectedCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, employees, contractors := newMultipleProvidersTest(t)
+			employees.ValidToken = false
+			contractors.ValidToken = true
+			// Refresh the cookie so that the session is validated
+			proxy.CookieRefresh = time.Minute
+
+			created := time.Now().Add(-time.Hour)
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/oauth2/auth", nil)
+			err := proxy.SaveSession(rw, req, &sessions.SessionState{
+				Email:       "user@example.com",
+				AccessToken: "my_access_token",
+				CreatedAt:   &created,
+				ProviderID:  tc.providerID,
+			})
+			require.NoError(t, err)
+			for _, c := range rw.Result().Cookies() {
+				req.AddCookie(c)
+			}
+
+			rw = httptest.NewRecorder()
+			proxy.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expectedCode, rw.Code)
+		})
+	}
+}
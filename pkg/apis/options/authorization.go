@@ -0,0 +1,39 @@
+package options
+
+// AuthorizationRule matches requests by method, host, path and headers and
+// decides how matching requests should be authorized.
+// Rules can only be configured within the config file.
+type AuthorizationRule struct {
+	// Policy is one of "allow", "deny" or "skip".
+	// "allow" permits authenticated users that meet the requirements of the rule,
+	// "deny" refuses authenticated users that meet the requirements of the rule
+	// and "skip" does not require authentication at all.
+	Policy string `cfg:"policy"`
+
+	// Methods, Hosts, Path and Headers restrict which requests the rule matches.
+	// Empty values match any request.
+	// Path is a regular expression matched against the request path.
+	// Headers are of the form "Header-Name=regex".
+	Methods []string `cfg:"methods"`
+	Hosts   []string `cfg:"hosts"`
+	Path    string   `cfg:"path"`
+	Headers []string `cfg:"headers"`
+
+	// Emails, EmailDomains, Groups and Claims are the requirements of the rule.
+	// A user meets the requirements when they match at least one entry of each
+	// non-empty requirement.
+	// Claims are of the form "claim=value".
+	Emails       []string `cfg:"emails"`
+	EmailDomains []string `cfg:"email_domains"`
+	Groups       []string `cfg:"groups"`
+	Claims       []string `cfg:"claims"`
+}
+
+// AllowAuthorizationPolicy permits users meeting the requirements of the rule
+const AllowAuthorizationPolicy = "allow"
+
+// DenyAuthorizationPolicy refuses users meeting the requirements of the rule
+const DenyAuthorizationPolicy = "deny"
+
+// SkipAuthorizationPolicy allows requests without authentication
+const SkipAuthorizationPolicy = "skip"
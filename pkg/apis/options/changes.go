@@ -0,0 +1,34 @@
+package options
+
+import "reflect"
+
+// ChangedOptions returns the config file names of the options that differ
+// between old and new, eg "upstreams". The internal values that are set by
+// validation are not compared.
+func ChangedOptions(old, new *Options) []string {
+	return changedFields(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), nil)
+}
+
+func changedFields(old, new reflect.Value, changed []string) []string {
+	typ := old.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			// Unexported internal values
+			continue
+		}
+
+		cfgName := field.Tag.Get("cfg")
+		switch cfgName {
+		case ",internal":
+			continue
+		case ",squash":
+			changed = changedFields(old.Field(i), new.Field(i), changed)
+			continue
+		}
+		if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+			changed = append(changed, cfgName)
+		}
+	}
+	return changed
+}
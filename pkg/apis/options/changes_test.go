@@ -0,0 +1,41 @@
+package options
+
+import (
+	"net/url"
+	"regexp"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ChangedOptions", func() {
+	It("returns nothing for equal options", func() {
+		Expect(ChangedOptions(NewOptions(), NewOptions())).To(BeEmpty())
+	})
+
+	It("returns the config names of the changed options", func() {
+		old := NewOptions()
+		old.Upstreams = []string{"http://127.0.0.1:8080/"}
+
+		new := NewOptions()
+		new.Upstreams = []string{"http://127.0.0.1:8081/"}
+		new.Cookie.Expire = time.Hour
+		new.Session.Redis.ConnectionURL = "redis://127.0.0.1:6379"
+		new.InjectRequestHeaders = []Header{{Name: "X-Forwarded-User"}}
+
+		Expect(ChangedOptions(old, new)).To(ConsistOf(
+			"upstreams",
+			"cookie_expire",
+			"redis_connection_url",
+			"inject_request_headers",
+		))
+	})
+
+	It("ignores the internal values set by validation", func() {
+		new := NewOptions()
+		new.SetProxyURLs([]*url.URL{{Scheme: "http", Host: "127.0.0.1:8080"}})
+		new.SetCompiledRegex([]*regexp.Regexp{regexp.MustCompile("^/ping$")})
+		Expect(ChangedOptions(NewOptions(), new)).To(BeEmpty())
+	})
+})
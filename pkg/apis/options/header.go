@@ -0,0 +1,44 @@
+package options
+
+// Header is a header that is injected into the upstream request or into the
+// response of the auth endpoints, with values taken from the session.
+// Headers can only be configured within the config file.
+type Header struct {
+	// Name is the name of the header to set.
+	Name string `cfg:"name" json:"name"`
+
+	// Values are added to the header in order. Values that are empty for the
+	// session are omitted. A header without any values is removed so that it
+	// cannot be supplied by the client.
+	Values []HeaderValue `cfg:"values" json:"values,omitempty"`
+}
+
+// HeaderValue is a single value of an injected header.
+// Exactly one of Value and Claim must be set.
+type HeaderValue struct {
+	// Value is static text.
+	Value string `cfg:"value" json:"value,omitempty"`
+
+	// Claim is one of the session fields "user", "email", "preferred_username",
+	// "groups", "access_token" or "id_token", or the name of a claim stored in
+	// the session. Lists are joined with commas.
+	// FallbackClaim is used when the session does not have a value for Claim.
+	Claim         string `cfg:"claim" json:"claim,omitempty"`
+	FallbackClaim string `cfg:"fallback_claim" json:"fallbackClaim,omitempty"`
+
+	// Encoding is one of "", "base64" or "basic_auth".
+	// "basic_auth" encodes the value as the user of HTTP basic auth
+	// credentials with BasicAuthPassword as the password.
+	Encoding          string `cfg:"encoding" json:"encoding,omitempty"`
+	BasicAuthPassword string `cfg:"basic_auth_password" json:"basicAuthPassword,omitempty"`
+
+	// Prefix is prepended to the value after it has been encoded,
+	// for example "Basic " or "Bearer ".
+	Prefix string `cfg:"prefix" json:"prefix,omitempty"`
+}
+
+// Base64HeaderEncoding encodes the header value with standard base64
+const Base64HeaderEncoding = "base64"
+
+// BasicAuthHeaderEncoding encodes the header value as basic auth credentials
+const BasicAuthHeaderEncoding = "basic_auth"
@@ -54,6 +54,7 @@ func Load(configFileName string, flagSet *pflag.FlagSet, into interface{}) error
 // - For fields, set `cfg` and `flag` so that `flag` is the name of the flag associated to this config option
 // - For exported fields that are not user facing, set the `cfg` to `,internal`
 // - For structs containing user facing fields, set the `cfg` to `,squash`
+// - For lists of structs, set only the `cfg`; these can only be set in the config file
 func registerFlags(v *viper.Viper, prefix string, flagSet *pflag.FlagSet, options interface{}) error {
 	val := reflect.ValueOf(options)
 	var typ reflect.Type
@@ -93,6 +94,14 @@ func registerFlags(v *viper.Viper, prefix string, flagSet *pflag.FlagSet, option
 			continue
 		}
 
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			if cfgName == "" {
+				return fmt.Errorf("field %q does not have required tag (cfg)", fieldName)
+			}
+			// Lists of structs cannot be represented as flags
+			continue
+		}
+
 		flagName := field.Tag.Get("flag")
 		if flagName == "" || cfgName == "" {
 			return fmt.Errorf("field %q does not have required tags (cfg, flag)", fieldName)
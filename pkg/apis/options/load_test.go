@@ -47,6 +47,15 @@ var _ = Describe("Load", func() {
 			Sub          TestOptionSubStruct `cfg:",squash"`
 		}
 
+		type TestOptionListStruct struct {
+			Name string `cfg:"name"`
+		}
+
+		type ListTestOptions struct {
+			StringOption string                 `flag:"string-option" cfg:"string_option"`
+			List         []TestOptionListStruct `cfg:"list"`
+		}
+
 		var testOptionsConfigBytes = []byte(`
 			string_option="foo"
 			string_slice_option="a,b,c,d"
@@ -295,6 +304,26 @@ var _ = Describe("Load", func() {
 					},
 				},
 			}),
+			Entry("with a list of structs in the config file", &testOptionsTableInput{
+				configFile: []byte(`
+					string_option="foo"
+
+					[[list]]
+					name="a"
+
+					[[list]]
+					name="b"
+				`),
+				flagSet: func() *pflag.FlagSet { return testOptionsFlagSet },
+				input:   &ListTestOptions{},
+				expectedOutput: &ListTestOptions{
+					StringOption: "foo",
+					List: []TestOptionListStruct{
+						{Name: "a"},
+						{Name: "b"},
+					},
+				},
+			}),
 			Entry("with an empty Options struct, should return default values", &testOptionsTableInput{
 				flagSet:        NewFlagSet,
 				input:          &Options{},
@@ -0,0 +1,69 @@
+package options
+
+// Provider configures an identity provider in addition to the one configured
+// by the top level provider options. Each configured provider is offered on
+// the sign-in page.
+// Providers can only be configured within the config file.
+type Provider struct {
+	// ID identifies the provider in the OAuth state and in sessions.
+	// It must be unique and may only contain letters, digits, '-' and '_'.
+	// Changing the ID of a provider invalidates existing sessions.
+	ID string `cfg:"id" json:"id,omitempty"`
+
+	// Type is the provider type, as for the provider option (eg "github").
+	Type string `cfg:"provider" json:"type,omitempty"`
+
+	// Name is displayed on the sign-in page button.
+	// Defaults to the name of the provider type.
+	Name string `cfg:"provider_display_name" json:"name,omitempty"`
+
+	ClientID         string `cfg:"client_id" json:"clientID,omitempty"`
+	ClientSecret     string `cfg:"client_secret" json:"clientSecret,omitempty"`
+	ClientSecretFile string `cfg:"client_secret_file" json:"clientSecretFile,omitempty"`
+	Scope            string `cfg:"scope" json:"scope,omitempty"`
+	Prompt           string `cfg:"prompt" json:"prompt,omitempty"`
+	ApprovalPrompt   string `cfg:"approval_prompt" json:"approvalPrompt,omitempty"`
+
+	// CodeChallengeMethod enables PKCE for the provider, as the top level
+	// code_challenge_method option does for the top level provider.
+	CodeChallengeMethod string `cfg:"code_challenge_method" json:"codeChallengeMethod,omitempty"`
+
+	LoginURL    string `cfg:"login_url" json:"loginURL,omitempty"`
+	RedeemURL   string `cfg:"redeem_url" json:"redeemURL,omitempty"`
+	ProfileURL  string `cfg:"profile_url" json:"profileURL,omitempty"`
+	ValidateURL string `cfg:"validate_url" json:"validateURL,omitempty"`
+	RevokeURL   string `cfg:"revoke_url" json:"revokeURL,omitempty"`
+	// LogoutURL is used for RP-initiated logout when it is enabled by the
+	// top level oidc_rp_initiated_logout option. It is discovered from the
+	// OIDC issuer if not set.
+	LogoutURL string `cfg:"logout_url" json:"logoutURL,omitempty"`
+
+	// OIDCIssuerURL is used for discovery of the OIDC endpoints and keys
+	// unless OIDCJwksURL is set, in which case LoginURL and RedeemURL must
+	// be set too.
+	OIDCIssuerURL                    string `cfg:"oidc_issuer_url" json:"oidcIssuerURL,omitempty"`
+	OIDCJwksURL                      string `cfg:"oidc_jwks_url" json:"oidcJwksURL,omitempty"`
+	InsecureOIDCAllowUnverifiedEmail bool   `cfg:"insecure_oidc_allow_unverified_email" json:"insecureOIDCAllowUnverifiedEmail,omitempty"`
+	UserIDClaim                      string `cfg:"user_id_claim" json:"userIDClaim,omitempty"`
+
+	// EmailDomains restricts the users of the provider to these email
+	// domains, in addition to the top level email_domains and
+	// authenticated_emails_file restrictions.
+	EmailDomains []string `cfg:"email_domains" json:"emailDomains,omitempty"`
+
+	// Provider specific restrictions, as for the top level options of the
+	// same name.
+	AzureTenant              string   `cfg:"azure_tenant" json:"azureTenant,omitempty"`
+	BitbucketTeam            string   `cfg:"bitbucket_team" json:"bitbucketTeam,omitempty"`
+	BitbucketRepository      string   `cfg:"bitbucket_repository" json:"bitbucketRepository,omitempty"`
+	GitHubOrg                string   `cfg:"github_org" json:"githubOrg,omitempty"`
+	GitHubTeam               string   `cfg:"github_team" json:"githubTeam,omitempty"`
+	GitHubRepo               string   `cfg:"github_repo" json:"githubRepo,omitempty"`
+	GitHubToken              string   `cfg:"github_token" json:"githubToken,omitempty"`
+	GitHubUsers              []string `cfg:"github_users" json:"githubUsers,omitempty"`
+	GitLabGroup              []string `cfg:"gitlab_groups" json:"gitlabGroups,omitempty"`
+	GoogleGroups             []string `cfg:"google_group" json:"googleGroups,omitempty"`
+	GoogleAdminEmail         string   `cfg:"google_admin_email" json:"googleAdminEmail,omitempty"`
+	GoogleServiceAccountJSON string   `cfg:"google_service_account_json" json:"googleServiceAccountJSON,omitempty"`
+	KeycloakGroup            string   `cfg:"keycloak_group" json:"keycloakGroup,omitempty"`
+}
@@ -1,9 +1,23 @@
 package options
 
+import "time"
+
 // SessionOptions contains configuration options for the SessionStore providers.
 type SessionOptions struct {
-	Type  string            `flag:"session-store-type" cfg:"session_store_type"`
-	Redis RedisStoreOptions `cfg:",squash"`
+	Type   string             `flag:"session-store-type" cfg:"session_store_type"`
+	Redis  RedisStoreOptions  `cfg:",squash"`
+	SQL    SQLStoreOptions    `cfg:",squash"`
+	Memory MemoryStoreOptions `cfg:",squash"`
+
+	// AdminToken enables the session admin API when set. Requests to the API
+	// must present it as a bearer token.
+	AdminToken string `flag:"session-admin-token" cfg:"session_admin_token"`
+
+	// IdleTimeout and MaxLifetime bound how long the redis session store
+	// keeps sessions: for IdleTimeout after they were last used and for at
+	// most MaxLifetime after they were created. Both are disabled when 0.
+	IdleTimeout time.Duration `flag:"session-idle-timeout" cfg:"session_idle_timeout"`
+	MaxLifetime time.Duration `flag:"session-max-lifetime" cfg:"session_max_lifetime"`
 }
 
 // CookieSessionStoreType is used to indicate the CookieSessionStore should be
@@ -14,6 +28,14 @@ var CookieSessionStoreType = "cookie"
 // used for storing sessions.
 var RedisSessionStoreType = "redis"
 
+// SQLSessionStoreType is used to indicate the SQLSessionStore should be used
+// for storing sessions.
+var SQLSessionStoreType = "sql"
+
+// MemorySessionStoreType is used to indicate the MemorySessionStore should be
+// used for storing sessions.
+var MemorySessionStoreType = "memory"
+
 // RedisStoreOptions contains configuration options for the RedisSessionStore.
 type RedisStoreOptions struct {
 	ConnectionURL          string   `flag:"redis-connection-url" cfg:"redis_connection_url"`
@@ -25,3 +47,26 @@ type RedisStoreOptions struct {
 	CAPath                 string   `flag:"redis-ca-path" cfg:"redis_ca_path"`
 	InsecureSkipTLSVerify  bool     `flag:"redis-insecure-skip-tls-verify" cfg:"redis_insecure_skip_tls_verify"`
 }
+
+// SQLStoreOptions contains configuration options for the SQLSessionStore.
+type SQLStoreOptions struct {
+	// Driver is the database/sql driver of the database, "sqlite3" or
+	// "postgres", and DSN its data source name
+	Driver string `flag:"sql-driver" cfg:"sql_driver"`
+	DSN    string `flag:"sql-dsn" cfg:"sql_dsn"`
+
+	// ReapInterval is how often expired sessions are deleted
+	ReapInterval time.Duration `flag:"sql-reap-interval" cfg:"sql_reap_interval"`
+}
+
+// MemoryStoreOptions contains configuration options for the
+// MemorySessionStore.
+type MemoryStoreOptions struct {
+	// MaxSessions caps the sessions kept in memory. The least recently used
+	// sessions are evicted beyond it.
+	MaxSessions int `flag:"memory-max-sessions" cfg:"memory_max_sessions"`
+
+	// SnapshotPath is the file the sessions are written to on shutdown and
+	// loaded from on start, so that restarts keep them. Disabled if empty.
+	SnapshotPath string `flag:"memory-snapshot-path" cfg:"memory_snapshot_path"`
+}
@@ -0,0 +1,434 @@
+package options
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"gopkg.in/yaml.v2"
+)
+
+// StructuredOptions is the structured config file format, written in YAML or
+// JSON. It groups the options for providers, upstreams, headers, sessions and
+// cookies into nested sections, instead of the flat fields of Options.
+//
+// The structured config is applied on top of the options set by flags, the
+// environment and the flat config file: any key it sets overrides them, and
+// lists it sets replace them.
+type StructuredOptions struct {
+	// Providers are the identity providers. The first provider is the default
+	// provider and must not have an ID; the keys it does not set keep their
+	// existing value. The other providers are offered on the sign-in page in
+	// addition to it and must have an ID.
+	Providers []Provider `json:"providers,omitempty"`
+
+	Upstreams             StructuredUpstreams `json:"upstreams"`
+	InjectRequestHeaders  []Header            `json:"injectRequestHeaders,omitempty"`
+	InjectResponseHeaders []Header            `json:"injectResponseHeaders,omitempty"`
+	Session               StructuredSession   `json:"session"`
+	Cookie                StructuredCookie    `json:"cookie"`
+}
+
+// StructuredUpstreams configures the upstreams. URLs are configured as for
+// the upstream option.
+type StructuredUpstreams struct {
+	URLs         []string               `json:"urls,omitempty"`
+	VirtualHosts []VirtualHost          `json:"virtualHosts,omitempty"`
+	Pool         StructuredUpstreamPool `json:"pool"`
+}
+
+// StructuredUpstreamPool configures the pools of upstreams that share a path,
+// as for the UpstreamPool options
+type StructuredUpstreamPool struct {
+	Strategy            string   `json:"strategy"`
+	MaxFails            int      `json:"maxFails"`
+	FailTimeout         Duration `json:"failTimeout"`
+	HealthCheckPath     string   `json:"healthCheckPath"`
+	HealthCheckInterval Duration `json:"healthCheckInterval"`
+	HealthCheckTimeout  Duration `json:"healthCheckTimeout"`
+}
+
+// StructuredSession configures the session store, as for the SessionOptions
+type StructuredSession struct {
+	Type        string           `json:"type"`
+	AdminToken  string           `json:"adminToken"`
+	IdleTimeout Duration         `json:"idleTimeout"`
+	MaxLifetime Duration         `json:"maxLifetime"`
+	Redis       StructuredRedis  `json:"redis"`
+	SQL         StructuredSQL    `json:"sql"`
+	Memory      StructuredMemory `json:"memory"`
+}
+
+// StructuredRedis configures the redis session store, as for the
+// RedisStoreOptions
+type StructuredRedis struct {
+	ConnectionURL          string   `json:"connectionURL"`
+	UseSentinel            bool     `json:"useSentinel"`
+	SentinelMasterName     string   `json:"sentinelMasterName"`
+	SentinelConnectionURLs []string `json:"sentinelConnectionURLs,omitempty"`
+	UseCluster             bool     `json:"useCluster"`
+	ClusterConnectionURLs  []string `json:"clusterConnectionURLs,omitempty"`
+	CAPath                 string   `json:"caPath"`
+	InsecureSkipTLSVerify  bool     `json:"insecureSkipTLSVerify"`
+}
+
+// StructuredSQL configures the sql session store, as for the
+// SQLStoreOptions
+type StructuredSQL struct {
+	Driver       string   `json:"driver"`
+	DSN          string   `json:"dsn"`
+	ReapInterval Duration `json:"reapInterval"`
+}
+
+// StructuredMemory configures the memory session store, as for the
+// MemoryStoreOptions
+type StructuredMemory struct {
+	MaxSessions  int    `json:"maxSessions"`
+	SnapshotPath string `json:"snapshotPath"`
+}
+
+// StructuredCookie configures the session cookie, as for the CookieOptions
+type StructuredCookie struct {
+	Name            string   `json:"name"`
+	Secret          string   `json:"secret"`
+	PreviousSecrets []string `json:"previousSecrets,omitempty"`
+	Domains         []string `json:"domains,omitempty"`
+	Path            string   `json:"path"`
+	Expire          Duration `json:"expire"`
+	Refresh         Duration `json:"refresh"`
+	Secure          bool     `json:"secure"`
+	HTTPOnly        bool     `json:"httpOnly"`
+	SameSite        string   `json:"sameSite"`
+}
+
+// Duration is a time.Duration that is written as a string, eg "168h0m0s",
+// in the structured config
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadStructured reads the structured config file at the path given into the
+// options. The file is parsed as JSON if its name ends with ".json" and as YAML
+// otherwise.
+// Keys that are not set in the file keep their existing value. Errors include
+// the path to the offending key, eg "upstreams.virtualHosts[0].hosts".
+func LoadStructured(fileName string, into *StructuredOptions) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("unable to load structured config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if strings.EqualFold(filepath.Ext(fileName), ".json") {
+		err = json.Unmarshal(data, &raw)
+	} else {
+		err = yaml.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to parse structured config file %s: %w", fileName, err)
+	}
+
+	errs := popUnknownKeys(raw)
+	if err := fillDefaultProvider(raw, into); err != nil {
+		return fmt.Errorf("error unmarshalling structured config %s: %w", fileName, err)
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:  decodeDuration,
+		ErrorUnused: true,
+		ZeroFields:  true,
+		Result:      into,
+		TagName:     "json",
+	})
+	if err != nil {
+		return fmt.Errorf("error creating decoder: %w", err)
+	}
+	if err := decoder.Decode(raw); err != nil {
+		var decodeErr *mapstructure.Error
+		if !errors.As(err, &decodeErr) {
+			return fmt.Errorf("error unmarshalling structured config %s: %w", fileName, err)
+		}
+		errs = append(errs, decodeErr.Errors...)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error unmarshalling structured config %s: %w", fileName, &mapstructure.Error{Errors: errs})
+	}
+	return nil
+}
+
+// popUnknownKeys removes the top level keys that are not part of the
+// structured config and returns an error for each of them. The decoder
+// reports the unknown keys of nested sections with their path, but not those
+// of the top level.
+func popUnknownKeys(raw map[string]interface{}) []string {
+	known := make(map[string]struct{})
+	typ := reflect.TypeOf(StructuredOptions{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		known[name] = struct{}{}
+	}
+
+	var errs []string
+	for key := range raw {
+		if _, ok := known[key]; !ok {
+			errs = append(errs, fmt.Sprintf("'%s' is not a valid key", key))
+			delete(raw, key)
+		}
+	}
+	return errs
+}
+
+// fillDefaultProvider sets the keys that the first of the configured providers
+// does not set from the existing default provider. The providers list replaces
+// the existing providers, so without this the default provider would lose the
+// values set by flags and their defaults (eg the user ID claim).
+func fillDefaultProvider(raw map[string]interface{}, into *StructuredOptions) error {
+	providers, ok := raw["providers"].([]interface{})
+	if !ok || len(providers) == 0 || len(into.Providers) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(into.Providers[0])
+	if err != nil {
+		return err
+	}
+	var defaults map[string]interface{}
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return err
+	}
+
+	switch provider := providers[0].(type) {
+	case map[string]interface{}:
+		for key, value := range defaults {
+			if _, ok := provider[key]; !ok {
+				provider[key] = value
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, value := range defaults {
+			if _, ok := provider[key]; !ok {
+				provider[key] = value
+			}
+		}
+	}
+	return nil
+}
+
+// decodeDuration parses durations written as strings, eg "30s"
+func decodeDuration(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to != reflect.TypeOf(Duration(0)) {
+		return data, nil
+	}
+	d, err := time.ParseDuration(data.(string))
+	if err != nil {
+		return nil, err
+	}
+	return Duration(d), nil
+}
+
+// MarshalYAML writes the structured config with the keys in the same order as
+// they are written in JSON, rather than sorted
+func (s *StructuredOptions) MarshalYAML() (interface{}, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var ordered yaml.MapSlice
+	if err := yaml.Unmarshal(data, &ordered); err != nil {
+		return nil, err
+	}
+	return ordered, nil
+}
+
+// NewStructuredOptions converts the options to the structured config format
+func NewStructuredOptions(o *Options) *StructuredOptions {
+	defaultProvider := Provider{
+		Type:                             o.ProviderType,
+		Name:                             o.ProviderName,
+		ClientID:                         o.ClientID,
+		ClientSecret:                     o.ClientSecret,
+		ClientSecretFile:                 o.ClientSecretFile,
+		Scope:                            o.Scope,
+		Prompt:                           o.Prompt,
+		ApprovalPrompt:                   o.ApprovalPrompt,
+		LoginURL:                         o.LoginURL,
+		RedeemURL:                        o.RedeemURL,
+		ProfileURL:                       o.ProfileURL,
+		ValidateURL:                      o.ValidateURL,
+		RevokeURL:                        o.RevokeURL,
+		OIDCIssuerURL:                    o.OIDCIssuerURL,
+		OIDCJwksURL:                      o.OIDCJwksURL,
+		InsecureOIDCAllowUnverifiedEmail: o.InsecureOIDCAllowUnverifiedEmail,
+		UserIDClaim:                      o.UserIDClaim,
+		AzureTenant:                      o.AzureTenant,
+		BitbucketTeam:                    o.BitbucketTeam,
+		BitbucketRepository:              o.BitbucketRepository,
+		GitHubOrg:                        o.GitHubOrg,
+		GitHubTeam:                       o.GitHubTeam,
+		GitHubRepo:                       o.GitHubRepo,
+		GitHubToken:                      o.GitHubToken,
+		GitHubUsers:                      o.GitHubUsers,
+		GitLabGroup:                      o.GitLabGroup,
+		GoogleGroups:                     o.GoogleGroups,
+		GoogleAdminEmail:                 o.GoogleAdminEmail,
+		GoogleServiceAccountJSON:         o.GoogleServiceAccountJSON,
+		KeycloakGroup:                    o.KeycloakGroup,
+	}
+
+	return &StructuredOptions{
+		Providers: append([]Provider{defaultProvider}, o.Providers...),
+		Upstreams: StructuredUpstreams{
+			URLs:         o.Upstreams,
+			VirtualHosts: o.VirtualHosts,
+			Pool: StructuredUpstreamPool{
+				Strategy:            o.UpstreamPool.Strategy,
+				MaxFails:            o.UpstreamPool.MaxFails,
+				FailTimeout:         Duration(o.UpstreamPool.FailTimeout),
+				HealthCheckPath:     o.UpstreamPool.HealthCheckPath,
+				HealthCheckInterval: Duration(o.UpstreamPool.HealthCheckInterval),
+				HealthCheckTimeout:  Duration(o.UpstreamPool.HealthCheckTimeout),
+			},
+		},
+		InjectRequestHeaders:  o.InjectRequestHeaders,
+		InjectResponseHeaders: o.InjectResponseHeaders,
+		Session: StructuredSession{
+			Type:        o.Session.Type,
+			AdminToken:  o.Session.AdminToken,
+			IdleTimeout: Duration(o.Session.IdleTimeout),
+			MaxLifetime: Duration(o.Session.MaxLifetime),
+			Redis: StructuredRedis{
+				ConnectionURL:          o.Session.Redis.ConnectionURL,
+				UseSentinel:            o.Session.Redis.UseSentinel,
+				SentinelMasterName:     o.Session.Redis.SentinelMasterName,
+				SentinelConnectionURLs: o.Session.Redis.SentinelConnectionURLs,
+				UseCluster:             o.Session.Redis.UseCluster,
+				ClusterConnectionURLs:  o.Session.Redis.ClusterConnectionURLs,
+				CAPath:                 o.Session.Redis.CAPath,
+				InsecureSkipTLSVerify:  o.Session.Redis.InsecureSkipTLSVerify,
+			},
+			SQL: StructuredSQL{
+				Driver:       o.Session.SQL.Driver,
+				DSN:          o.Session.SQL.DSN,
+				ReapInterval: Duration(o.Session.SQL.ReapInterval),
+			},
+			Memory: StructuredMemory{
+				MaxSessions:  o.Session.Memory.MaxSessions,
+				SnapshotPath: o.Session.Memory.SnapshotPath,
+			},
+		},
+		Cookie: StructuredCookie{
+			Name:            o.Cookie.Name,
+			Secret:          o.Cookie.Secret,
+			PreviousSecrets: o.Cookie.PreviousSecrets,
+			Domains:         o.Cookie.Domains,
+			Path:            o.Cookie.Path,
+			Expire:          Duration(o.Cookie.Expire),
+			Refresh:         Duration(o.Cookie.Refresh),
+			Secure:          o.Cookie.Secure,
+			HTTPOnly:        o.Cookie.HTTPOnly,
+			SameSite:        o.Cookie.SameSite,
+		},
+	}
+}
+
+// MergeInto sets the options from the structured config
+func (s *StructuredOptions) MergeInto(o *Options) {
+	if len(s.Providers) > 0 {
+		p := s.Providers[0]
+		o.ProviderType = p.Type
+		o.ProviderName = p.Name
+		o.ClientID = p.ClientID
+		o.ClientSecret = p.ClientSecret
+		o.ClientSecretFile = p.ClientSecretFile
+		o.Scope = p.Scope
+		o.Prompt = p.Prompt
+		o.ApprovalPrompt = p.ApprovalPrompt
+		o.LoginURL = p.LoginURL
+		o.RedeemURL = p.RedeemURL
+		o.ProfileURL = p.ProfileURL
+		o.ValidateURL = p.ValidateURL
+		o.RevokeURL = p.RevokeURL
+		o.OIDCIssuerURL = p.OIDCIssuerURL
+		o.OIDCJwksURL = p.OIDCJwksURL
+		o.InsecureOIDCAllowUnverifiedEmail = p.InsecureOIDCAllowUnverifiedEmail
+		o.UserIDClaim = p.UserIDClaim
+		o.AzureTenant = p.AzureTenant
+		o.BitbucketTeam = p.BitbucketTeam
+		o.BitbucketRepository = p.BitbucketRepository
+		o.GitHubOrg = p.GitHubOrg
+		o.GitHubTeam = p.GitHubTeam
+		o.GitHubRepo = p.GitHubRepo
+		o.GitHubToken = p.GitHubToken
+		o.GitHubUsers = p.GitHubUsers
+		o.GitLabGroup = p.GitLabGroup
+		o.GoogleGroups = p.GoogleGroups
+		o.GoogleAdminEmail = p.GoogleAdminEmail
+		o.GoogleServiceAccountJSON = p.GoogleServiceAccountJSON
+		o.KeycloakGroup = p.KeycloakGroup
+
+		o.Providers = nil
+		if len(s.Providers) > 1 {
+			o.Providers = s.Providers[1:]
+		}
+	}
+
+	o.Upstreams = s.Upstreams.URLs
+	o.VirtualHosts = s.Upstreams.VirtualHosts
+	o.UpstreamPool = UpstreamPool{
+		Strategy:            s.Upstreams.Pool.Strategy,
+		MaxFails:            s.Upstreams.Pool.MaxFails,
+		FailTimeout:         time.Duration(s.Upstreams.Pool.FailTimeout),
+		HealthCheckPath:     s.Upstreams.Pool.HealthCheckPath,
+		HealthCheckInterval: time.Duration(s.Upstreams.Pool.HealthCheckInterval),
+		HealthCheckTimeout:  time.Duration(s.Upstreams.Pool.HealthCheckTimeout),
+	}
+
+	o.InjectRequestHeaders = s.InjectRequestHeaders
+	o.InjectResponseHeaders = s.InjectResponseHeaders
+
+	o.Session.Type = s.Session.Type
+	o.Session.AdminToken = s.Session.AdminToken
+	o.Session.IdleTimeout = time.Duration(s.Session.IdleTimeout)
+	o.Session.MaxLifetime = time.Duration(s.Session.MaxLifetime)
+	o.Session.Redis = RedisStoreOptions{
+		ConnectionURL:          s.Session.Redis.ConnectionURL,
+		UseSentinel:            s.Session.Redis.UseSentinel,
+		SentinelMasterName:     s.Session.Redis.SentinelMasterName,
+		SentinelConnectionURLs: s.Session.Redis.SentinelConnectionURLs,
+		UseCluster:             s.Session.Redis.UseCluster,
+		ClusterConnectionURLs:  s.Session.Redis.ClusterConnectionURLs,
+		CAPath:                 s.Session.Redis.CAPath,
+		InsecureSkipTLSVerify:  s.Session.Redis.InsecureSkipTLSVerify,
+	}
+	o.Session.SQL = SQLStoreOptions{
+		Driver:       s.Session.SQL.Driver,
+		DSN:          s.Session.SQL.DSN,
+		ReapInterval: time.Duration(s.Session.SQL.ReapInterval),
+	}
+	o.Session.Memory = MemoryStoreOptions{
+		MaxSessions:  s.Session.Memory.MaxSessions,
+		SnapshotPath: s.Session.Memory.SnapshotPath,
+	}
+
+	o.Cookie = CookieOptions{
+		Name:            s.Cookie.Name,
+		Secret:          s.Cookie.Secret,
+		PreviousSecrets: s.Cookie.PreviousSecrets,
+		Domains:         s.Cookie.Domains,
+		Path:            s.Cookie.Path,
+		Expire:          time.Duration(s.Cookie.Expire),
+		Refresh:         time.Duration(s.Cookie.Refresh),
+		Secure:          s.Cookie.Secure,
+		HTTPOnly:        s.Cookie.HTTPOnly,
+		SameSite:        s.Cookie.SameSite,
+	}
+}
@@ -0,0 +1,195 @@
+package options
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+var _ = Describe("Structured Options", func() {
+	writeConfigFile := func(pattern string, data []byte) string {
+		configFile, err := ioutil.TempFile("", pattern)
+		Expect(err).ToNot(HaveOccurred())
+		defer configFile.Close()
+
+		_, err = configFile.Write(data)
+		Expect(err).ToNot(HaveOccurred())
+		return configFile.Name()
+	}
+
+	testOptions := func() *Options {
+		opts := NewOptions()
+		opts.ProviderType = "github"
+		opts.ClientID = "client-id"
+		opts.ClientSecret = "client-secret"
+		opts.GitHubUsers = []string{"alice", "bob"}
+		opts.Providers = []Provider{
+			{ID: "corp", Type: "oidc", ClientID: "corp-id", OIDCIssuerURL: "https://sso.example.com"},
+		}
+		opts.Upstreams = []string{"http://127.0.0.1:8080/", "http://127.0.0.1:8081/"}
+		opts.VirtualHosts = []VirtualHost{
+			{Hosts: []string{"*.example.com"}, Upstreams: []string{"http://127.0.0.1:9090/"}},
+		}
+		opts.UpstreamPool.Strategy = "least-connections"
+		opts.InjectRequestHeaders = []Header{
+			{Name: "X-Forwarded-User", Values: []HeaderValue{{Claim: "user"}}},
+		}
+		opts.Session.Type = RedisSessionStoreType
+		opts.Session.Redis.ConnectionURL = "redis://127.0.0.1:6379"
+		opts.Cookie.Secret = "secretthirtytwobytes+abcdefghijk"
+		opts.Cookie.Domains = []string{"example.com"}
+		opts.Cookie.Refresh = time.Hour
+		return opts
+	}
+
+	It("converts the options to the structured format and back", func() {
+		opts := testOptions()
+		merged := NewOptions()
+		NewStructuredOptions(opts).MergeInto(merged)
+		Expect(merged).To(Equal(opts))
+	})
+
+	It("loads the converted options written as YAML", func() {
+		opts := testOptions()
+		out, err := yaml.Marshal(NewStructuredOptions(opts))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(out)).To(ContainSubstring("refresh: 1h0m0s\n"))
+
+		configFileName := writeConfigFile("oauth2-proxy-test-structured-config-*.yaml", out)
+		defer os.Remove(configFileName)
+
+		structured := NewStructuredOptions(NewOptions())
+		Expect(LoadStructured(configFileName, structured)).To(Succeed())
+		merged := NewOptions()
+		structured.MergeInto(merged)
+		Expect(merged).To(Equal(opts))
+	})
+
+	type loadStructuredTableInput struct {
+		pattern        string
+		configFile     []byte
+		expectedErr    string
+		expectedOutput func() *Options
+	}
+
+	DescribeTable("LoadStructured",
+		func(in loadStructuredTableInput) {
+			configFileName := writeConfigFile(in.pattern, in.configFile)
+			defer os.Remove(configFileName)
+
+			opts := NewOptions()
+			structured := NewStructuredOptions(opts)
+			err := LoadStructured(configFileName, structured)
+			if in.expectedErr != "" {
+				Expect(err).To(MatchError(fmt.Sprintf(in.expectedErr, configFileName)))
+				return
+			}
+			Expect(err).ToNot(HaveOccurred())
+
+			structured.MergeInto(opts)
+			Expect(opts).To(Equal(in.expectedOutput()))
+		},
+		Entry("with a YAML file, keeping the keys it does not set", loadStructuredTableInput{
+			pattern: "oauth2-proxy-test-structured-config-*.yaml",
+			configFile: []byte(`
+upstreams:
+  urls:
+  - http://127.0.0.1:8080/
+  pool:
+    maxFails: 5
+    failTimeout: 1m
+cookie:
+  secret: secretthirtytwobytes+abcdefghijk
+  previousSecrets:
+  - oldsecretthirtytwobytes+abcdefgh
+  expire: 12h
+`),
+			expectedOutput: func() *Options {
+				opts := NewOptions()
+				opts.Upstreams = []string{"http://127.0.0.1:8080/"}
+				opts.UpstreamPool.MaxFails = 5
+				opts.UpstreamPool.FailTimeout = time.Minute
+				opts.Cookie.Secret = "secretthirtytwobytes+abcdefghijk"
+				opts.Cookie.PreviousSecrets = []string{"oldsecretthirtytwobytes+abcdefgh"}
+				opts.Cookie.Expire = 12 * time.Hour
+				return opts
+			},
+		}),
+		Entry("with a JSON file", loadStructuredTableInput{
+			pattern: "oauth2-proxy-test-structured-config-*.json",
+			configFile: []byte(`{
+	"providers": [
+		{"type": "gitlab", "clientID": "client-id", "gitlabGroups": ["admins"]},
+		{"id": "corp", "type": "oidc", "clientID": "corp-id"}
+	],
+	"injectResponseHeaders": [
+		{"name": "X-Auth-Request-Email", "values": [{"claim": "email"}]}
+	],
+	"session": {"type": "redis", "redis": {"connectionURL": "redis://127.0.0.1:6379"}}
+}`),
+			expectedOutput: func() *Options {
+				opts := NewOptions()
+				opts.ProviderType = "gitlab"
+				opts.ClientID = "client-id"
+				opts.GitLabGroup = []string{"admins"}
+				opts.Providers = []Provider{{ID: "corp", Type: "oidc", ClientID: "corp-id"}}
+				opts.InjectResponseHeaders = []Header{
+					{Name: "X-Auth-Request-Email", Values: []HeaderValue{{Claim: "email"}}},
+				}
+				opts.Session.Type = RedisSessionStoreType
+				opts.Session.Redis.ConnectionURL = "redis://127.0.0.1:6379"
+				return opts
+			},
+		}),
+		Entry("with the sql session store", loadStructuredTableInput{
+			pattern: "oauth2-proxy-test-structured-config-*.yaml",
+			configFile: []byte(`
+session:
+  type: sql
+  sql:
+    driver: postgres
+    dsn: postgres://oauth2-proxy@127.0.0.1/sessions
+    reapInterval: 1m
+`),
+			expectedOutput: func() *Options {
+				opts := NewOptions()
+				opts.Session.Type = SQLSessionStoreType
+				opts.Session.SQL.Driver = "postgres"
+				opts.Session.SQL.DSN = "postgres://oauth2-proxy@127.0.0.1/sessions"
+				opts.Session.SQL.ReapInterval = time.Minute
+				return opts
+			},
+		}),
+		Entry("with unknown keys and invalid values", loadStructuredTableInput{
+			pattern: "oauth2-proxy-test-structured-config-*.yaml",
+			configFile: []byte(`
+unknown: true
+upstreams:
+  virtualHosts:
+  - hosts: [app.example.com]
+    upstream: [http://127.0.0.1:9090/]
+  pool:
+    maxFails: many
+cookie:
+  sameSite: lax
+  samesite: lax
+`),
+			expectedErr: "error unmarshalling structured config %s: 4 error(s) decoding:\n\n" +
+				"* 'cookie' has invalid keys: samesite\n" +
+				"* 'unknown' is not a valid key\n" +
+				"* 'upstreams.pool.maxFails' expected type 'int', got unconvertible type 'string'\n" +
+				"* 'upstreams.virtualHosts[0]' has invalid keys: upstream",
+		}),
+		Entry("with an invalid YAML file", loadStructuredTableInput{
+			pattern:     "oauth2-proxy-test-structured-config-*.yaml",
+			configFile:  []byte("upstreams: [\n"),
+			expectedErr: "unable to parse structured config file %s: yaml: line 1: did not find expected node content",
+		}),
+	)
+})
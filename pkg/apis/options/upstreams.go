@@ -0,0 +1,63 @@
+package options
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// VirtualHost routes requests for specific hosts to their own upstreams,
+// instead of the upstreams configured by the upstream option.
+// Virtual hosts can only be configured within the config file.
+type VirtualHost struct {
+	// Hosts are the host names the virtual host serves. A host name may start
+	// with "*." to match all of its subdomains (eg "*.example.com").
+	Hosts []string `cfg:"hosts" json:"hosts,omitempty"`
+
+	// Upstreams are configured as for the upstream option
+	Upstreams []string `cfg:"upstreams" json:"upstreams,omitempty"`
+}
+
+// UpstreamPool contains the options for balancing requests between the
+// backends of HTTP(S) upstreams that are configured with the same path
+type UpstreamPool struct {
+	// Strategy is one of round-robin, least-connections or consistent-hash.
+	// consistent-hash sends each user to the same backend while it is available.
+	Strategy string `flag:"upstream-balancing" cfg:"upstream_balancing"`
+
+	// A backend is ejected from its pool for FailTimeout after MaxFails
+	// consecutive failed requests. Ejection is disabled if MaxFails is 0.
+	MaxFails    int           `flag:"upstream-max-fails" cfg:"upstream_max_fails"`
+	FailTimeout time.Duration `flag:"upstream-fail-timeout" cfg:"upstream_fail_timeout"`
+
+	// When HealthCheckPath is set each backend is probed every
+	// HealthCheckInterval and is removed from its pool until a probe succeeds.
+	HealthCheckPath     string        `flag:"upstream-health-check-path" cfg:"upstream_health_check_path"`
+	HealthCheckInterval time.Duration `flag:"upstream-health-check-interval" cfg:"upstream_health_check_interval"`
+	HealthCheckTimeout  time.Duration `flag:"upstream-health-check-timeout" cfg:"upstream_health_check_timeout"`
+}
+
+func upstreamPoolFlagSet() *pflag.FlagSet {
+	flagSet := pflag.NewFlagSet("upstreampool", pflag.ExitOnError)
+
+	flagSet.String("upstream-balancing", "round-robin", "how requests are balanced between upstreams with the same path (one of: round-robin, least-connections, consistent-hash)")
+	flagSet.Int("upstream-max-fails", 3, "number of consecutive failed requests after which an upstream is ejected from its pool (0 to disable)")
+	flagSet.Duration("upstream-fail-timeout", 30*time.Second, "how long an upstream is ejected from its pool after upstream-max-fails failed requests")
+	flagSet.String("upstream-health-check-path", "", "path probed to check the health of upstreams that share a path (disabled if empty)")
+	flagSet.Duration("upstream-health-check-interval", 10*time.Second, "interval between upstream health checks")
+	flagSet.Duration("upstream-health-check-timeout", 2*time.Second, "timeout of upstream health checks")
+
+	return flagSet
+}
+
+// upstreamPoolDefaults creates an UpstreamPool, populating each field with
+// its default value
+func upstreamPoolDefaults() UpstreamPool {
+	return UpstreamPool{
+		Strategy:            "round-robin",
+		MaxFails:            3,
+		FailTimeout:         30 * time.Second,
+		HealthCheckInterval: 10 * time.Second,
+		HealthCheckTimeout:  2 * time.Second,
+	}
+}
@@ -0,0 +1,113 @@
+package sessions
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// MaxClaimsSize is the maximum combined length of the names and values of the
+// claims stored in a session. Sessions are often stored in cookies so the
+// claims must be bounded to keep the session within the cookie size limits.
+const MaxClaimsSize = 2048
+
+// excludedClaims are not stored in the session claims as they either describe
+// the token rather than the user or are already stored in the session
+var excludedClaims = map[string]bool{
+	"iss":                true,
+	"sub":                true,
+	"aud":                true,
+	"exp":                true,
+	"nbf":                true,
+	"iat":                true,
+	"jti":                true,
+	"nonce":              true,
+	"at_hash":            true,
+	"c_hash":             true,
+	"auth_time":          true,
+	"azp":                true,
+	"email":              true,
+	"email_verified":     true,
+	"preferred_username": true,
+	"groups":             true,
+}
+
+// SetClaims replaces the claims stored in the session with the given claims.
+// Claims are added in name order until MaxClaimsSize is reached, any claims
+// that do not fit are dropped.
+func (s *SessionState) SetClaims(claims map[string]interface{}) {
+	s.Claims = nil
+
+	names := make([]string, 0, len(claims))
+	for name := range claims {
+		if !excludedClaims[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	size := 0
+	for _, name := range names {
+		values := ClaimValues(claims[name])
+		if len(values) == 0 {
+			continue
+		}
+
+		claimSize := len(name)
+		for _, v := range values {
+			claimSize += len(v)
+		}
+		if size+claimSize > MaxClaimsSize {
+			continue
+		}
+		size += claimSize
+
+		if s.Claims == nil {
+			s.Claims = make(map[string][]string)
+		}
+		s.Claims[name] = values
+	}
+}
+
+// ClaimValues converts a claim, either a single value or a list of values,
+// to a list of strings. Objects are JSON encoded.
+func ClaimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if value, ok := claimValue(item); ok {
+				values = append(values, value)
+			}
+		}
+		return values
+	default:
+		if value, ok := claimValue(v); ok {
+			return []string{value}
+		}
+		return nil
+	}
+}
+
+func claimValue(claim interface{}) (string, bool) {
+	switch v := claim.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return v.String(), true
+	case nil:
+		return "", false
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+}
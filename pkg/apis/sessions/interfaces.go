@@ -1,12 +1,58 @@
 package sessions
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"time"
 )
 
+// ErrSessionRevoked is returned when loading a session that has been revoked
+var ErrSessionRevoked = errors.New("session has been revoked")
+
 // SessionStore is an interface to storing user sessions in the proxy
 type SessionStore interface {
 	Save(rw http.ResponseWriter, req *http.Request, s *SessionState) error
 	Load(req *http.Request) (*SessionState, error)
 	Clear(rw http.ResponseWriter, req *http.Request) error
 }
+
+// SessionRevoker is implemented by session stores that can revoke sessions
+// without a request from the user, for example for OIDC back-channel logout
+type SessionRevoker interface {
+	// RevokeSessions removes the sessions created from ID tokens with the
+	// given session ID or, when the session ID is empty, the given subject.
+	// It returns the number of sessions revoked.
+	RevokeSessions(ctx context.Context, subject, sessionID string) (int, error)
+}
+
+// HealthChecker is implemented by session stores that depend on an external
+// service, so that the readiness checks can check that it can be reached
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// SessionAdmin is implemented by session stores that can list and revoke
+// the sessions of a user, as used by the session admin API
+type SessionAdmin interface {
+	// ListSessions returns the sessions of the user with the given email
+	ListSessions(ctx context.Context, email string) ([]*SessionInfo, error)
+
+	// RevokeSession revokes the session with the given ID.
+	// It returns false if there is no such session.
+	RevokeSession(ctx context.Context, id string) (bool, error)
+
+	// RevokeUserSessions revokes all sessions of the user with the given
+	// email and returns the number of sessions revoked
+	RevokeUserSessions(ctx context.Context, email string) (int, error)
+}
+
+// SessionInfo describes a stored session without any of its tokens
+type SessionInfo struct {
+	ID         string     `json:"id"`
+	Email      string     `json:"email"`
+	User       string     `json:"user,omitempty"`
+	ProviderID string     `json:"provider_id,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	ExpiresOn  *time.Time `json:"expires_on,omitempty"`
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 	"unicode/utf8"
 
@@ -20,6 +21,13 @@ type SessionState struct {
 	Email             string     `json:",omitempty"`
 	User              string     `json:",omitempty"`
 	PreferredUsername string     `json:",omitempty"`
+
+	Groups []string            `json:",omitempty"`
+	Claims map[string][]string `json:",omitempty"`
+
+	// ProviderID is the ID of the provider that authenticated the session.
+	// It is empty for the provider configured by the top level options.
+	ProviderID string `json:",omitempty"`
 }
 
 // IsExpired checks whether the session has expired
@@ -41,6 +49,12 @@ func (s *SessionState) Age() time.Duration {
 // String constructs a summary of the session state
 func (s *SessionState) String() string {
 	o := fmt.Sprintf("Session{email:%s user:%s PreferredUsername:%s", s.Email, s.User, s.PreferredUsername)
+	if len(s.Groups) > 0 {
+		o += fmt.Sprintf(" groups:%s", strings.Join(s.Groups, ","))
+	}
+	if s.ProviderID != "" {
+		o += fmt.Sprintf(" provider:%s", s.ProviderID)
+	}
 	if s.AccessToken != "" {
 		o += " token:true"
 	}
@@ -67,16 +81,22 @@ func (s *SessionState) EncodeSessionState(c encryption.Cipher) (string, error) {
 		ss.Email = s.Email
 		ss.User = s.User
 		ss.PreferredUsername = s.PreferredUsername
+		ss.Groups = s.Groups
+		ss.Claims = s.Claims
+		ss.ProviderID = s.ProviderID
 	} else {
 		ss = *s
-		for _, s := range []*string{
+		// Copy the groups and claims so that encrypting them in place does
+		// not modify the original session
+		ss.Groups, ss.Claims = copyGroupsAndClaims(s.Groups, s.Claims)
+		for _, s := range append([]*string{
 			&ss.Email,
 			&ss.User,
 			&ss.PreferredUsername,
 			&ss.AccessToken,
 			&ss.IDToken,
 			&ss.RefreshToken,
-		} {
+		}, ss.groupsAndClaimsValues()...) {
 			err := into(s, c.Encrypt)
 			if err != nil {
 				return "", err
@@ -102,6 +122,9 @@ func DecodeSessionState(v string, c encryption.Cipher) (*SessionState, error) {
 			Email:             ss.Email,
 			User:              ss.User,
 			PreferredUsername: ss.PreferredUsername,
+			Groups:            ss.Groups,
+			Claims:            ss.Claims,
+			ProviderID:        ss.ProviderID,
 		}
 	} else {
 		// Backward compatibility with using unencrypted Email or User
@@ -119,12 +142,12 @@ func DecodeSessionState(v string, c encryption.Cipher) (*SessionState, error) {
 			}
 		}
 
-		for _, s := range []*string{
+		for _, s := range append([]*string{
 			&ss.PreferredUsername,
 			&ss.AccessToken,
 			&ss.IDToken,
 			&ss.RefreshToken,
-		} {
+		}, ss.groupsAndClaimsValues()...) {
 			err := into(s, c.Decrypt)
 			if err != nil {
 				return nil, err
@@ -134,6 +157,36 @@ func DecodeSessionState(v string, c encryption.Cipher) (*SessionState, error) {
 	return &ss, nil
 }
 
+// groupsAndClaimsValues returns pointers to each group and claim value so that
+// they can be encrypted or decrypted in place
+func (s *SessionState) groupsAndClaimsValues() []*string {
+	var values []*string
+	for i := range s.Groups {
+		values = append(values, &s.Groups[i])
+	}
+	for _, claim := range s.Claims {
+		for i := range claim {
+			values = append(values, &claim[i])
+		}
+	}
+	return values
+}
+
+func copyGroupsAndClaims(groups []string, claims map[string][]string) ([]string, map[string][]string) {
+	var groupsCopy []string
+	if groups != nil {
+		groupsCopy = append([]string{}, groups...)
+	}
+	var claimsCopy map[string][]string
+	if claims != nil {
+		claimsCopy = make(map[string][]string, len(claims))
+		for name, values := range claims {
+			claimsCopy[name] = append([]string{}, values...)
+		}
+	}
+	return groupsCopy, claimsCopy
+}
+
 // codecFunc is a function that takes a []byte and encodes/decodes it
 type codecFunc func([]byte) ([]byte, error)
 
@@ -137,6 +137,96 @@ func TestSessionStateSerializationNoCipherWithUser(t *testing.T) {
 	assert.Equal(t, "", ss.RefreshToken)
 }
 
+func TestSessionStateSerializationWithGroupsAndClaims(t *testing.T) {
+	c, err := newTestCipher([]byte(secret))
+	assert.Equal(t, nil, err)
+	s := &SessionState{
+		Email:  "user@domain.com",
+		Groups: []string{"group1", "group2"},
+		Claims: map[string][]string{
+			"role": {"admin", "editor"},
+		},
+	}
+	encoded, err := s.EncodeSessionState(c)
+	assert.Equal(t, nil, err)
+	assert.NotContains(t, encoded, "group1")
+	assert.NotContains(t, encoded, "editor")
+
+	// the original session must not be modified by encoding
+	assert.Equal(t, []string{"group1", "group2"}, s.Groups)
+	assert.Equal(t, []string{"admin", "editor"}, s.Claims["role"])
+
+	ss, err := DecodeSessionState(encoded, c)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, s.Groups, ss.Groups)
+	assert.Equal(t, s.Claims, ss.Claims)
+
+	// groups and claims are kept without a cipher, like the email and user
+	encoded, err = s.EncodeSessionState(nil)
+	assert.Equal(t, nil, err)
+	ss, err = DecodeSessionState(encoded, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, s.Groups, ss.Groups)
+	assert.Equal(t, s.Claims, ss.Claims)
+}
+
+func TestSessionStateSerializationWithProviderID(t *testing.T) {
+	c, err := newTestCipher([]byte(secret))
+	assert.Equal(t, nil, err)
+	s := &SessionState{
+		Email:       "user@domain.com",
+		AccessToken: "token1234",
+		ProviderID:  "github",
+	}
+
+	for _, cipher := range []encryption.Cipher{c, nil} {
+		encoded, err := s.EncodeSessionState(cipher)
+		assert.Equal(t, nil, err)
+		ss, err := DecodeSessionState(encoded, cipher)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, s.ProviderID, ss.ProviderID)
+	}
+}
+
+func TestSetClaims(t *testing.T) {
+	s := &SessionState{}
+	s.SetClaims(map[string]interface{}{
+		"iss":      "https://issuer.example.com",
+		"groups":   []interface{}{"group1"},
+		"role":     "admin",
+		"level":    float64(3),
+		"admin":    true,
+		"projects": []interface{}{"a", "b", float64(1)},
+		"address":  map[string]interface{}{"country": "NL"},
+		"empty":    nil,
+	})
+
+	assert.Equal(t, map[string][]string{
+		"role":     {"admin"},
+		"level":    {"3"},
+		"admin":    {"true"},
+		"projects": {"a", "b", "1"},
+		"address":  {`{"country":"NL"}`},
+	}, s.Claims)
+}
+
+func TestSetClaimsIsBounded(t *testing.T) {
+	large := make([]byte, MaxClaimsSize)
+	for i := range large {
+		large[i] = 'a'
+	}
+
+	s := &SessionState{}
+	s.SetClaims(map[string]interface{}{
+		"a_large": string(large),
+		"b_small": "small",
+	})
+
+	assert.Equal(t, map[string][]string{
+		"b_small": {"small"},
+	}, s.Claims)
+}
+
 func TestExpired(t *testing.T) {
 	s := &SessionState{ExpiresOn: timePtr(time.Now().Add(time.Duration(-1) * time.Minute))}
 	assert.Equal(t, true, s.IsExpired())
@@ -0,0 +1,254 @@
+package authorization
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+)
+
+// RuleSet is an ordered list of authorization rules.
+// The first rule that matches a request decides how it is authorized.
+type RuleSet struct {
+	rules []*rule
+}
+
+type rule struct {
+	policy  string
+	methods []string
+	hosts   []string
+	path    *regexp.Regexp
+	headers []headerMatcher
+
+	emails       []string
+	emailDomains []string
+	groups       []string
+	claims       []claimRequirement
+}
+
+type headerMatcher struct {
+	name  string
+	value *regexp.Regexp
+}
+
+type claimRequirement struct {
+	name  string
+	value string
+}
+
+// NewRuleSet compiles the configured authorization rules
+func NewRuleSet(rules []options.AuthorizationRule) (*RuleSet, error) {
+	rs := &RuleSet{}
+	for i, r := range rules {
+		compiled, err := newRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid authorization rule %d: %v", i, err)
+		}
+		rs.rules = append(rs.rules, compiled)
+	}
+	return rs, nil
+}
+
+func newRule(r options.AuthorizationRule) (*rule, error) {
+	switch r.Policy {
+	case options.AllowAuthorizationPolicy, options.DenyAuthorizationPolicy, options.SkipAuthorizationPolicy:
+	default:
+		return nil, fmt.Errorf("policy (%s) must be one of ['allow', 'deny', 'skip']", r.Policy)
+	}
+
+	compiled := &rule{
+		policy:       r.Policy,
+		methods:      r.Methods,
+		emails:       lowerAll(r.Emails),
+		emailDomains: lowerAll(r.EmailDomains),
+		groups:       r.Groups,
+	}
+
+	for _, host := range r.Hosts {
+		compiled.hosts = append(compiled.hosts, strings.ToLower(host))
+	}
+
+	if r.Path != "" {
+		path, err := regexp.Compile(r.Path)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling path regex=%q %v", r.Path, err)
+		}
+		compiled.path = path
+	}
+
+	for _, h := range r.Headers {
+		name, value, err := splitKeyValue(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid header matcher %q: %v", h, err)
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling header regex=%q %v", value, err)
+		}
+		compiled.headers = append(compiled.headers, headerMatcher{name: http.CanonicalHeaderKey(name), value: re})
+	}
+
+	for _, c := range r.Claims {
+		name, value, err := splitKeyValue(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid claim requirement %q: %v", c, err)
+		}
+		compiled.claims = append(compiled.claims, claimRequirement{name: name, value: value})
+	}
+
+	return compiled, nil
+}
+
+// Skip reports whether the request matches a rule that does not require
+// authentication
+func (rs *RuleSet) Skip(req *http.Request) bool {
+	r := rs.match(req)
+	return r != nil && r.policy == options.SkipAuthorizationPolicy
+}
+
+// Authorize reports whether the authenticated session may access the request.
+// Requests that do not match any rule are authorized.
+func (rs *RuleSet) Authorize(req *http.Request, s *sessions.SessionState) bool {
+	r := rs.match(req)
+	if r == nil {
+		return true
+	}
+
+	switch r.policy {
+	case options.AllowAuthorizationPolicy:
+		return r.requirementsMet(s)
+	case options.DenyAuthorizationPolicy:
+		return !r.requirementsMet(s)
+	default:
+		return true
+	}
+}
+
+func (rs *RuleSet) match(req *http.Request) *rule {
+	if rs == nil {
+		return nil
+	}
+	for _, r := range rs.rules {
+		if r.matches(req) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *rule) matches(req *http.Request) bool {
+	if len(r.methods) > 0 && !containsFold(r.methods, req.Method) {
+		return false
+	}
+
+	if len(r.hosts) > 0 {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !contains(r.hosts, strings.ToLower(host)) {
+			return false
+		}
+	}
+
+	if r.path != nil && !r.path.MatchString(req.URL.Path) {
+		return false
+	}
+
+	for _, h := range r.headers {
+		if !h.matches(req.Header) {
+			return false
+		}
+	}
+	return true
+}
+
+func (h headerMatcher) matches(header http.Header) bool {
+	for _, value := range header[h.name] {
+		if h.value.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// requirementsMet checks the session against each of the configured
+// requirements. A rule without requirements is met by every session.
+func (r *rule) requirementsMet(s *sessions.SessionState) bool {
+	email := strings.ToLower(s.Email)
+
+	if len(r.emails) > 0 && !contains(r.emails, email) {
+		return false
+	}
+
+	if len(r.emailDomains) > 0 && !r.emailDomainMatches(email) {
+		return false
+	}
+
+	if len(r.groups) > 0 && !containsAny(s.Groups, r.groups) {
+		return false
+	}
+
+	for _, c := range r.claims {
+		if !contains(s.Claims[c.name], c.value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *rule) emailDomainMatches(email string) bool {
+	for _, domain := range r.emailDomains {
+		if strings.HasSuffix(email, "@"+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitKeyValue(s string) (string, string, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("expected format name=value")
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+func lowerAll(in []string) []string {
+	var out []string
+	for _, s := range in {
+		out = append(out, strings.ToLower(s))
+	}
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(list []string, wanted []string) bool {
+	for _, w := range wanted {
+		if contains(list, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package authorization
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRuleSetErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      options.AuthorizationRule
+		errString string
+	}{
+		{
+			name:      "unknown policy",
+			rule:      options.AuthorizationRule{Policy: "maybe"},
+			errString: "invalid authorization rule 0: policy (maybe) must be one of ['allow', 'deny', 'skip']",
+		},
+		{
+			name:      "invalid path",
+			rule:      options.AuthorizationRule{Policy: "allow", Path: "("},
+			errString: "invalid authorization rule 0: error compiling path regex=\"(\" error parsing regexp: missing closing ): `(`",
+		},
+		{
+			name:      "invalid header",
+			rule:      options.AuthorizationRule{Policy: "allow", Headers: []string{"X-Foo"}},
+			errString: "invalid authorization rule 0: invalid header matcher \"X-Foo\": expected format name=value",
+		},
+		{
+			name:      "invalid claim",
+			rule:      options.AuthorizationRule{Policy: "allow", Claims: []string{"=admin"}},
+			errString: "invalid authorization rule 0: invalid claim requirement \"=admin\": expected format name=value",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRuleSet([]options.AuthorizationRule{tc.rule})
+			assert.EqualError(t, err, tc.errString)
+		})
+	}
+}
+
+func TestRuleSetSkip(t *testing.T) {
+	rs, err := NewRuleSet([]options.AuthorizationRule{
+		{Policy: "allow", Path: "^/public/private"},
+		{Policy: "skip", Methods: []string{"GET"}, Path: "^/public"},
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, rs.Skip(httptest.NewRequest("GET", "/public/index.html", nil)))
+	assert.False(t, rs.Skip(httptest.NewRequest("POST", "/public/index.html", nil)))
+	assert.False(t, rs.Skip(httptest.NewRequest("GET", "/public/private", nil)))
+	assert.False(t, rs.Skip(httptest.NewRequest("GET", "/other", nil)))
+	assert.False(t, (*RuleSet)(nil).Skip(httptest.NewRequest("GET", "/public", nil)))
+}
+
+func TestRuleSetAuthorize(t *testing.T) {
+	session := &sessions.SessionState{
+		Email:  "jane@example.com",
+		Groups: []string{"admins", "users"},
+		Claims: map[string][]string{
+			"role":  {"editor"},
+			"level": {"3"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		rule       options.AuthorizationRule
+		method     string
+		target     string
+		headers    map[string]string
+		authorized bool
+	}{
+		{
+			name:       "no matching rule",
+			rule:       options.AuthorizationRule{Policy: "deny", Path: "^/admin"},
+			target:     "/index.html",
+			authorized: true,
+		},
+		{
+			name:       "deny without requirements",
+			rule:       options.AuthorizationRule{Policy: "deny", Path: "^/admin"},
+			target:     "/admin",
+			authorized: false,
+		},
+		{
+			name:       "allow matching email",
+			rule:       options.AuthorizationRule{Policy: "allow", Emails: []string{"Jane@Example.com"}},
+			authorized: true,
+		},
+		{
+			name:       "allow other email",
+			rule:       options.AuthorizationRule{Policy: "allow", Emails: []string{"john@example.com"}},
+			authorized: false,
+		},
+		{
+			name:       "allow matching email domain",
+			rule:       options.AuthorizationRule{Policy: "allow", EmailDomains: []string{"example.com"}},
+			authorized: true,
+		},
+		{
+			name:       "allow other email domain",
+			rule:       options.AuthorizationRule{Policy: "allow", EmailDomains: []string{"ample.com"}},
+			authorized: false,
+		},
+		{
+			name:       "allow matching group",
+			rule:       options.AuthorizationRule{Policy: "allow", Groups: []string{"ops", "admins"}},
+			authorized: true,
+		},
+		{
+			name:       "allow other group",
+			rule:       options.AuthorizationRule{Policy: "allow", Groups: []string{"ops"}},
+			authorized: false,
+		},
+		{
+			name:       "allow matching claims",
+			rule:       options.AuthorizationRule{Policy: "allow", Claims: []string{"role=editor", "level=3"}},
+			authorized: true,
+		},
+		{
+			name:       "allow with one claim not matching",
+			rule:       options.AuthorizationRule{Policy: "allow", Claims: []string{"role=editor", "level=4"}},
+			authorized: false,
+		},
+		{
+			name:       "deny matching group",
+			rule:       options.AuthorizationRule{Policy: "deny", Groups: []string{"users"}},
+			authorized: false,
+		},
+		{
+			name:       "deny other group",
+			rule:       options.AuthorizationRule{Policy: "deny", Groups: []string{"contractors"}},
+			authorized: true,
+		},
+		{
+			name:       "method does not match",
+			rule:       options.AuthorizationRule{Policy: "deny", Methods: []string{"post", "put"}},
+			authorized: true,
+		},
+		{
+			name:       "method matches",
+			rule:       options.AuthorizationRule{Policy: "deny", Methods: []string{"post", "put"}},
+			method:     "POST",
+			authorized: false,
+		},
+		{
+			name:       "host matches",
+			rule:       options.AuthorizationRule{Policy: "deny", Hosts: []string{"Admin.Example.com"}},
+			target:     "http://admin.example.com:8080/",
+			authorized: false,
+		},
+		{
+			name:       "host does not match",
+			rule:       options.AuthorizationRule{Policy: "deny", Hosts: []string{"admin.example.com"}},
+			target:     "http://www.example.com/",
+			authorized: true,
+		},
+		{
+			name:       "header matches",
+			rule:       options.AuthorizationRule{Policy: "deny", Headers: []string{"x-client=^legacy-"}},
+			headers:    map[string]string{"X-Client": "legacy-app"},
+			authorized: false,
+		},
+		{
+			name:       "header does not match",
+			rule:       options.AuthorizationRule{Policy: "deny", Headers: []string{"x-client=^legacy-"}},
+			headers:    map[string]string{"X-Client": "new-app"},
+			authorized: true,
+		},
+		{
+			name:       "header missing",
+			rule:       options.AuthorizationRule{Policy: "deny", Headers: []string{"x-client=.*"}},
+			authorized: true,
+		},
+		{
+			name:       "skip rule",
+			rule:       options.AuthorizationRule{Policy: "skip", Emails: []string{"john@example.com"}},
+			authorized: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rs, err := NewRuleSet([]options.AuthorizationRule{tc.rule})
+			assert.NoError(t, err)
+
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			target := tc.target
+			if target == "" {
+				target = "/"
+			}
+			req := httptest.NewRequest(method, target, nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			assert.Equal(t, tc.authorized, rs.Authorize(req, session))
+		})
+	}
+}
+
+func TestRuleSetFirstMatchWins(t *testing.T) {
+	rs, err := NewRuleSet([]options.AuthorizationRule{
+		{Policy: "allow", Path: "^/admin/status$"},
+		{Policy: "allow", Path: "^/admin", Emails: []string{"admin@example.com"}},
+	})
+	assert.NoError(t, err)
+
+	session := &sessions.SessionState{Email: "jane@example.com"}
+	assert.True(t, rs.Authorize(httptest.NewRequest("GET", "/admin/status", nil), session))
+	assert.False(t, rs.Authorize(httptest.NewRequest("GET", "/admin/users", nil), session))
+}
@@ -29,6 +29,20 @@ func NewBase64Cipher(initCipher func([]byte) (Cipher, error), secret []byte) (Ci
 	return &base64Cipher{Cipher: c}, nil
 }
 
+// NewBase64Ciphers returns a Base64 wrapped Cipher for each of the secrets,
+// in the same order
+func NewBase64Ciphers(initCipher func([]byte) (Cipher, error), secrets []string) ([]Cipher, error) {
+	ciphers := make([]Cipher, 0, len(secrets))
+	for _, secret := range secrets {
+		c, err := NewBase64Cipher(initCipher, SecretBytes(secret))
+		if err != nil {
+			return nil, err
+		}
+		ciphers = append(ciphers, c)
+	}
+	return ciphers, nil
+}
+
 // Encrypt encrypts a value with the embedded Cipher & Base64 encodes it
 func (c *base64Cipher) Encrypt(value []byte) ([]byte, error) {
 	encrypted, err := c.Cipher.Encrypt(value)
@@ -0,0 +1,42 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	// CodeChallengeMethodPlain sends the code verifier unmodified as the
+	// code challenge (RFC 7636 section 4.2)
+	CodeChallengeMethodPlain = "plain"
+	// CodeChallengeMethodS256 sends the base64url encoded SHA256 hash of the
+	// code verifier as the code challenge (RFC 7636 section 4.2)
+	CodeChallengeMethodS256 = "S256"
+)
+
+// CodeVerifier generates a random PKCE code verifier.
+// 32 random bytes are encoded to a 43 character base64url string which is the
+// minimum length allowed by RFC 7636.
+func CodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create code verifier: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge derives the PKCE code challenge from the code verifier
+// using the given code challenge method
+func CodeChallenge(codeVerifier, method string) (string, error) {
+	switch method {
+	case CodeChallengeMethodPlain:
+		return codeVerifier, nil
+	case CodeChallengeMethodS256:
+		h := sha256.Sum256([]byte(codeVerifier))
+		return base64.RawURLEncoding.EncodeToString(h[:]), nil
+	default:
+		return "", fmt.Errorf("unknown code challenge method %q", method)
+	}
+}
@@ -0,0 +1,33 @@
+package encryption
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeVerifier(t *testing.T) {
+	v1, err := CodeVerifier()
+	assert.NoError(t, err)
+	assert.Equal(t, 43, len(v1))
+
+	v2, err := CodeVerifier()
+	assert.NoError(t, err)
+	assert.NotEqual(t, v1, v2)
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// Test vector from RFC 7636 Appendix B
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	challenge, err := CodeChallenge(verifier, CodeChallengeMethodS256)
+	assert.NoError(t, err)
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", challenge)
+
+	challenge, err = CodeChallenge(verifier, CodeChallengeMethodPlain)
+	assert.NoError(t, err)
+	assert.Equal(t, verifier, challenge)
+
+	_, err = CodeChallenge(verifier, "S512")
+	assert.Error(t, err)
+}
@@ -64,6 +64,17 @@ func Validate(cookie *http.Cookie, seed string, expiration time.Duration) (value
 	return
 }
 
+// ValidateWithSecrets ensures a cookie is properly signed by one of the seeds,
+// which are tried in order, and returns the index of the seed that signed it
+func ValidateWithSecrets(cookie *http.Cookie, seeds []string, expiration time.Duration) (value []byte, t time.Time, seed int, ok bool) {
+	for i, s := range seeds {
+		if value, t, ok = Validate(cookie, s, expiration); ok {
+			return value, t, i, true
+		}
+	}
+	return nil, time.Time{}, -1, false
+}
+
 // SignedValue returns a cookie that is signed and can later be checked with Validate
 func SignedValue(seed string, key string, value []byte, now time.Time) string {
 	encodedValue := base64.URLEncoding.EncodeToString(value)
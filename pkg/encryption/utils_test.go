@@ -7,7 +7,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -98,3 +100,21 @@ func TestSignAndValidate(t *testing.T) {
 	assert.False(t, checkSignature(sha256sig, seed, key, "tampered", epoch))
 	assert.False(t, checkSignature(sha1sig, seed, key, "tampered", epoch))
 }
+
+func TestValidateWithSecrets(t *testing.T) {
+	now := time.Now()
+	cookie := &http.Cookie{
+		Name:  "cookie-name",
+		Value: SignedValue("old-secret", "cookie-name", []byte("value"), now),
+	}
+
+	value, ts, seed, ok := ValidateWithSecrets(cookie, []string{"new-secret", "old-secret"}, time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), value)
+	assert.Equal(t, now.Unix(), ts.Unix())
+	assert.Equal(t, 1, seed)
+
+	_, _, seed, ok = ValidateWithSecrets(cookie, []string{"new-secret"}, time.Hour)
+	assert.False(t, ok)
+	assert.Equal(t, -1, seed)
+}
@@ -0,0 +1,119 @@
+package header
+
+import (
+	b64 "encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+)
+
+// Injector sets the configured headers from the session
+type Injector struct {
+	headers []*header
+}
+
+type header struct {
+	name   string
+	values []options.HeaderValue
+}
+
+// NewInjector validates the configured headers. When a header is configured
+// more than once the last definition is used, this allows headers to
+// override the presets.
+func NewInjector(headers []options.Header) (*Injector, error) {
+	injector := &Injector{}
+	byName := make(map[string]*header)
+	for i, h := range headers {
+		if err := validateHeader(h); err != nil {
+			return nil, fmt.Errorf("invalid header %d: %v", i, err)
+		}
+
+		name := http.CanonicalHeaderKey(h.Name)
+		if existing, ok := byName[name]; ok {
+			existing.values = h.Values
+			continue
+		}
+		byName[name] = &header{name: name, values: h.Values}
+		injector.headers = append(injector.headers, byName[name])
+	}
+	return injector, nil
+}
+
+func validateHeader(h options.Header) error {
+	if h.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, v := range h.Values {
+		if (v.Value == "") == (v.Claim == "") {
+			return fmt.Errorf("value for %s must set exactly one of value or claim", h.Name)
+		}
+		if v.FallbackClaim != "" && v.Claim == "" {
+			return fmt.Errorf("value for %s sets fallback_claim without claim", h.Name)
+		}
+		switch v.Encoding {
+		case "", options.Base64HeaderEncoding, options.BasicAuthHeaderEncoding:
+		default:
+			return fmt.Errorf("encoding (%s) for %s must be one of ['', 'base64', 'basic_auth']", v.Encoding, h.Name)
+		}
+	}
+	return nil
+}
+
+// Inject replaces the configured headers with the values from the session.
+// Headers without any values for the session are removed.
+func (i *Injector) Inject(headers http.Header, s *sessions.SessionState) {
+	if i == nil {
+		return
+	}
+	for _, h := range i.headers {
+		headers.Del(h.name)
+		for _, v := range h.values {
+			if value := headerValue(v, s); value != "" {
+				headers.Add(h.name, value)
+			}
+		}
+	}
+}
+
+func headerValue(v options.HeaderValue, s *sessions.SessionState) string {
+	value := v.Value
+	if v.Claim != "" {
+		value = claimValue(v.Claim, s)
+		if value == "" && v.FallbackClaim != "" {
+			value = claimValue(v.FallbackClaim, s)
+		}
+		if value == "" {
+			return ""
+		}
+	}
+
+	switch v.Encoding {
+	case options.Base64HeaderEncoding:
+		value = b64.StdEncoding.EncodeToString([]byte(value))
+	case options.BasicAuthHeaderEncoding:
+		value = b64.StdEncoding.EncodeToString([]byte(value + ":" + v.BasicAuthPassword))
+	}
+	return v.Prefix + value
+}
+
+func claimValue(claim string, s *sessions.SessionState) string {
+	switch claim {
+	case "user":
+		return s.User
+	case "email":
+		return s.Email
+	case "preferred_username":
+		return s.PreferredUsername
+	case "groups":
+		return strings.Join(s.Groups, ",")
+	case "access_token":
+		return s.AccessToken
+	case "id_token":
+		return s.IDToken
+	default:
+		return strings.Join(s.Claims[claim], ",")
+	}
+}
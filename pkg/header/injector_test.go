@@ -0,0 +1,270 @@
+package header
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewInjectorErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    options.Header
+		errString string
+	}{
+		{
+			name:      "missing name",
+			header:    options.Header{},
+			errString: "invalid header 0: name is required",
+		},
+		{
+			name:      "value and claim",
+			header:    options.Header{Name: "X-Foo", Values: []options.HeaderValue{{Value: "foo", Claim: "user"}}},
+			errString: "invalid header 0: value for X-Foo must set exactly one of value or claim",
+		},
+		{
+			name:      "neither value nor claim",
+			header:    options.Header{Name: "X-Foo", Values: []options.HeaderValue{{Prefix: "foo"}}},
+			errString: "invalid header 0: value for X-Foo must set exactly one of value or claim",
+		},
+		{
+			name:      "fallback claim without claim",
+			header:    options.Header{Name: "X-Foo", Values: []options.HeaderValue{{Value: "foo", FallbackClaim: "user"}}},
+			errString: "invalid header 0: value for X-Foo sets fallback_claim without claim",
+		},
+		{
+			name:      "unknown encoding",
+			header:    options.Header{Name: "X-Foo", Values: []options.HeaderValue{{Claim: "user", Encoding: "hex"}}},
+			errString: "invalid header 0: encoding (hex) for X-Foo must be one of ['', 'base64', 'basic_auth']",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewInjector([]options.Header{tc.header})
+			assert.EqualError(t, err, tc.errString)
+		})
+	}
+}
+
+func TestInject(t *testing.T) {
+	session := &sessions.SessionState{
+		User:        "9fcab5c9b889a557",
+		Email:       "john.doe@example.com",
+		Groups:      []string{"admins", "users"},
+		AccessToken: "access_token",
+		Claims: map[string][]string{
+			"roles": {"editor", "viewer"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		header   options.Header
+		existing []string
+		expected []string
+	}{
+		{
+			name:     "static value",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Value: "example"}}},
+			expected: []string{"example"},
+		},
+		{
+			name:     "session field",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "email"}}},
+			expected: []string{"john.doe@example.com"},
+		},
+		{
+			name:     "groups are joined",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "groups"}}},
+			expected: []string{"admins,users"},
+		},
+		{
+			name:     "claims are joined",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "roles"}}},
+			expected: []string{"editor,viewer"},
+		},
+		{
+			name:     "multiple values",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "user"}, {Value: "example"}}},
+			expected: []string{"9fcab5c9b889a557", "example"},
+		},
+		{
+			name:     "missing claim is omitted",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "id_token"}, {Value: "example"}}},
+			expected: []string{"example"},
+		},
+		{
+			name:     "fallback claim",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "preferred_username", FallbackClaim: "user"}}},
+			expected: []string{"9fcab5c9b889a557"},
+		},
+		{
+			name:     "existing values are replaced",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "user"}}},
+			existing: []string{"spoofed"},
+			expected: []string{"9fcab5c9b889a557"},
+		},
+		{
+			name:     "header without values is removed",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "id_token"}}},
+			existing: []string{"spoofed"},
+			expected: nil,
+		},
+		{
+			name:     "base64 encoding with prefix",
+			header:   options.Header{Name: "X-Tenant", Values: []options.HeaderValue{{Claim: "access_token", Encoding: "base64", Prefix: "Token "}}},
+			expected: []string{"Token YWNjZXNzX3Rva2Vu"},
+		},
+		{
+			name: "basic auth encoding",
+			header: options.Header{Name: "X-Tenant", Values: []options.HeaderValue{
+				{Claim: "email", Encoding: "basic_auth", BasicAuthPassword: "secret", Prefix: "Basic "},
+			}},
+			expected: []string{"Basic am9obi5kb2VAZXhhbXBsZS5jb206c2VjcmV0"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			injector, err := NewInjector([]options.Header{tc.header})
+			assert.NoError(t, err)
+
+			headers := http.Header{}
+			for _, v := range tc.existing {
+				headers.Add("X-Tenant", v)
+			}
+			injector.Inject(headers, session)
+			assert.Equal(t, tc.expected, headers["X-Tenant"])
+		})
+	}
+}
+
+func TestInjectLastDefinitionWins(t *testing.T) {
+	injector, err := NewInjector([]options.Header{
+		{Name: "x-tenant", Values: []options.HeaderValue{{Value: "first"}}},
+		{Name: "X-Other", Values: []options.HeaderValue{{Value: "other"}}},
+		{Name: "X-Tenant", Values: []options.HeaderValue{{Value: "second"}}},
+	})
+	assert.NoError(t, err)
+
+	headers := http.Header{}
+	injector.Inject(headers, &sessions.SessionState{})
+	assert.Equal(t, http.Header{"X-Tenant": {"second"}, "X-Other": {"other"}}, headers)
+}
+
+func TestPresets(t *testing.T) {
+	session := &sessions.SessionState{
+		User:              "9fcab5c9b889a557",
+		Email:             "john.doe@example.com",
+		PreferredUsername: "john",
+		Groups:            []string{"admins", "users"},
+		AccessToken:       "access_token",
+		IDToken:           "id_token",
+	}
+	basicAuthUser := "Basic OWZjYWI1YzliODg5YTU1NzpwYXNzd29yZA=="
+	basicAuthEmail := "Basic am9obi5kb2VAZXhhbXBsZS5jb206cGFzc3dvcmQ="
+
+	tests := []struct {
+		name     string
+		opts     options.Options
+		request  http.Header
+		response http.Header
+	}{
+		{
+			name: "no presets",
+			opts: options.Options{},
+			response: http.Header{
+				"Gap-Auth": {"john.doe@example.com"},
+			},
+		},
+		{
+			name: "pass basic auth",
+			opts: options.Options{PassBasicAuth: true, BasicAuthPassword: "password"},
+			request: http.Header{
+				"Authorization":                  {basicAuthUser},
+				"X-Forwarded-User":               {"9fcab5c9b889a557"},
+				"X-Forwarded-Email":              {"john.doe@example.com"},
+				"X-Forwarded-Preferred-Username": {"john"},
+				"X-Forwarded-Groups":             {"admins,users"},
+			},
+			response: http.Header{
+				"Gap-Auth": {"john.doe@example.com"},
+			},
+		},
+		{
+			name: "pass user headers preferring email",
+			opts: options.Options{PassUserHeaders: true, PreferEmailToUser: true},
+			request: http.Header{
+				"X-Forwarded-User":               {"john.doe@example.com"},
+				"X-Forwarded-Preferred-Username": {"john"},
+				"X-Forwarded-Groups":             {"admins,users"},
+			},
+			response: http.Header{
+				"Gap-Auth": {"john.doe@example.com"},
+			},
+		},
+		{
+			name: "pass access token and authorization",
+			opts: options.Options{PassBasicAuth: true, PassAccessToken: true, PassAuthorization: true},
+			request: http.Header{
+				"Authorization":                  {"Bearer id_token"},
+				"X-Forwarded-User":               {"9fcab5c9b889a557"},
+				"X-Forwarded-Email":              {"john.doe@example.com"},
+				"X-Forwarded-Preferred-Username": {"john"},
+				"X-Forwarded-Groups":             {"admins,users"},
+				"X-Forwarded-Access-Token":       {"access_token"},
+			},
+			response: http.Header{
+				"Gap-Auth": {"john.doe@example.com"},
+			},
+		},
+		{
+			name: "set xauthrequest and basic auth",
+			opts: options.Options{SetXAuthRequest: true, PassAccessToken: true, SetBasicAuth: true, PreferEmailToUser: true, BasicAuthPassword: "password"},
+			request: http.Header{
+				"X-Forwarded-Access-Token": {"access_token"},
+			},
+			response: http.Header{
+				"X-Auth-Request-User":               {"9fcab5c9b889a557"},
+				"X-Auth-Request-Email":              {"john.doe@example.com"},
+				"X-Auth-Request-Preferred-Username": {"john"},
+				"X-Auth-Request-Groups":             {"admins,users"},
+				"X-Auth-Request-Access-Token":       {"access_token"},
+				"Authorization":                     {basicAuthEmail},
+				"Gap-Auth":                          {"john.doe@example.com"},
+			},
+		},
+		{
+			name: "set authorization",
+			opts: options.Options{SetAuthorization: true},
+			response: http.Header{
+				"Authorization": {"Bearer id_token"},
+				"Gap-Auth":      {"john.doe@example.com"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			requestHeaders, err := NewInjector(RequestHeaderPresets(&tc.opts))
+			assert.NoError(t, err)
+			responseHeaders, err := NewInjector(ResponseHeaderPresets(&tc.opts))
+			assert.NoError(t, err)
+
+			request := http.Header{}
+			requestHeaders.Inject(request, session)
+			response := http.Header{}
+			responseHeaders.Inject(response, session)
+
+			if tc.request == nil {
+				tc.request = http.Header{}
+			}
+			assert.Equal(t, tc.request, request)
+			assert.Equal(t, tc.response, response)
+		})
+	}
+}
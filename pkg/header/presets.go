@@ -0,0 +1,95 @@
+package header
+
+import (
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+)
+
+// RequestHeaderPresets returns the upstream request headers enabled by the
+// pass-basic-auth, pass-user-headers, pass-access-token and
+// pass-authorization-header options
+func RequestHeaderPresets(opts *options.Options) []options.Header {
+	var headers []options.Header
+
+	if opts.PassBasicAuth {
+		headers = append(headers, newHeader("Authorization", basicAuthValue(opts)))
+	}
+
+	if opts.PassBasicAuth || opts.PassUserHeaders {
+		headers = append(headers,
+			newHeader("X-Forwarded-User", userValue(opts)),
+			claimHeader("X-Forwarded-Preferred-Username", "preferred_username"),
+			claimHeader("X-Forwarded-Groups", "groups"),
+		)
+		if opts.PreferEmailToUser {
+			// The email is already passed as the user
+			headers = append(headers, newHeader("X-Forwarded-Email"))
+		} else {
+			headers = append(headers, claimHeader("X-Forwarded-Email", "email"))
+		}
+	}
+
+	if opts.PassAccessToken {
+		headers = append(headers, claimHeader("X-Forwarded-Access-Token", "access_token"))
+	}
+
+	if opts.PassAuthorization {
+		headers = append(headers, newHeader("Authorization", options.HeaderValue{Claim: "id_token", Prefix: "Bearer "}))
+	}
+
+	return headers
+}
+
+// ResponseHeaderPresets returns the response headers enabled by the
+// set-xauthrequest, set-basic-auth and set-authorization-header options
+func ResponseHeaderPresets(opts *options.Options) []options.Header {
+	var headers []options.Header
+
+	if opts.SetXAuthRequest {
+		headers = append(headers,
+			claimHeader("X-Auth-Request-User", "user"),
+			claimHeader("X-Auth-Request-Email", "email"),
+			claimHeader("X-Auth-Request-Preferred-Username", "preferred_username"),
+			claimHeader("X-Auth-Request-Groups", "groups"),
+		)
+		if opts.PassAccessToken {
+			headers = append(headers, claimHeader("X-Auth-Request-Access-Token", "access_token"))
+		}
+	}
+
+	if opts.SetBasicAuth {
+		headers = append(headers, newHeader("Authorization", basicAuthValue(opts)))
+	}
+
+	if opts.SetAuthorization {
+		headers = append(headers, newHeader("Authorization", options.HeaderValue{Claim: "id_token", Prefix: "Bearer "}))
+	}
+
+	headers = append(headers, newHeader("GAP-Auth", options.HeaderValue{Claim: "email", FallbackClaim: "user"}))
+
+	return headers
+}
+
+// userValue is the user of the session, or their email when
+// prefer-email-to-user is set and the session has an email
+func userValue(opts *options.Options) options.HeaderValue {
+	if opts.PreferEmailToUser {
+		return options.HeaderValue{Claim: "email", FallbackClaim: "user"}
+	}
+	return options.HeaderValue{Claim: "user"}
+}
+
+func basicAuthValue(opts *options.Options) options.HeaderValue {
+	v := userValue(opts)
+	v.Encoding = options.BasicAuthHeaderEncoding
+	v.BasicAuthPassword = opts.BasicAuthPassword
+	v.Prefix = "Basic "
+	return v
+}
+
+func claimHeader(name, claim string) options.Header {
+	return newHeader(name, options.HeaderValue{Claim: claim})
+}
+
+func newHeader(name string, values ...options.HeaderValue) options.Header {
+	return options.Header{Name: name, Values: values}
+}
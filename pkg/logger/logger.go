@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 	"sync"
 	"text/template"
 	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/metrics"
 )
 
 // AuthStatus defines the different types of auth logging that occur
@@ -40,39 +43,50 @@ const (
 	LstdFlags = Lshortfile
 )
 
+// Event types identify the log stream of JSON log lines
+const (
+	standardEvent = "standard"
+	authEvent     = "auth"
+	requestEvent  = "request"
+)
+
 // These are the containers for all values that are available as variables in the logging formats.
 // All values are pre-formatted strings so it is easy to use them in the format string.
+// When JSON logging is enabled they are written as JSON objects using the json field names.
 type stdLogMessageData struct {
-	Timestamp,
-	File,
-	Message string
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	File      string `json:"file"`
+	Message   string `json:"message"`
 }
 
 type authLogMessageData struct {
-	Client,
-	Host,
-	Protocol,
-	RequestMethod,
-	Timestamp,
-	UserAgent,
-	Username,
-	Status,
-	Message string
+	Event         string `json:"event"`
+	Client        string `json:"client"`
+	Host          string `json:"host"`
+	Protocol      string `json:"protocol"`
+	RequestMethod string `json:"request_method"`
+	Timestamp     string `json:"timestamp"`
+	UserAgent     string `json:"user_agent"`
+	Username      string `json:"username"`
+	Status        string `json:"status"`
+	Message       string `json:"message"`
 }
 
 type reqLogMessageData struct {
-	Client,
-	Host,
-	Protocol,
-	RequestDuration,
-	RequestMethod,
-	RequestURI,
-	ResponseSize,
-	StatusCode,
-	Timestamp,
-	Upstream,
-	UserAgent,
-	Username string
+	Event           string `json:"event"`
+	Client          string `json:"client"`
+	Host            string `json:"host"`
+	Protocol        string `json:"protocol"`
+	RequestDuration string `json:"request_duration"`
+	RequestMethod   string `json:"request_method"`
+	RequestURI      string `json:"request_uri"`
+	ResponseSize    string `json:"response_size"`
+	StatusCode      string `json:"status_code"`
+	Timestamp       string `json:"timestamp"`
+	Upstream        string `json:"upstream"`
+	UserAgent       string `json:"user_agent"`
+	Username        string `json:"username"`
 }
 
 // Returns the apparent "real client IP" as a string.
@@ -90,6 +104,7 @@ type Logger struct {
 	stdEnabled     bool
 	authEnabled    bool
 	reqEnabled     bool
+	jsonEnabled    bool
 	getClientFunc  GetClientFunc
 	excludePaths   map[string]struct{}
 	stdLogTemplate *template.Template
@@ -132,19 +147,20 @@ func (l *Logger) Output(calldepth int, message string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.stdLogTemplate.Execute(l.writer, stdLogMessageData{
-		Timestamp: FormatTimestamp(now),
+	l.write(l.stdLogTemplate, stdLogMessageData{
+		Event:     standardEvent,
+		Timestamp: l.timestamp(now),
 		File:      file,
 		Message:   message,
 	})
-
-	l.writer.Write([]byte("\n"))
 }
 
 // PrintAuthf writes auth info to the logger. Requires an http.Request to
 // log request details. Remaining arguments are handled in the manner of
 // fmt.Sprintf. Writes a final newline to the end of every message.
 func (l *Logger) PrintAuthf(username string, req *http.Request, status AuthStatus, format string, a ...interface{}) {
+	metrics.IncAuth(string(status))
+
 	if !l.authEnabled {
 		return
 	}
@@ -160,19 +176,18 @@ func (l *Logger) PrintAuthf(username string, req *http.Request, status AuthStatu
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.authTemplate.Execute(l.writer, authLogMessageData{
+	l.write(l.authTemplate, authLogMessageData{
+		Event:         authEvent,
 		Client:        client,
 		Host:          req.Host,
 		Protocol:      req.Proto,
 		RequestMethod: req.Method,
-		Timestamp:     FormatTimestamp(now),
-		UserAgent:     fmt.Sprintf("%q", req.UserAgent()),
+		Timestamp:     l.timestamp(now),
+		UserAgent:     l.quote(req.UserAgent()),
 		Username:      username,
 		Status:        string(status),
 		Message:       fmt.Sprintf(format, a...),
 	})
-
-	l.writer.Write([]byte("\n"))
 }
 
 // PrintReq writes request details to the Logger using the http.Request,
@@ -208,24 +223,58 @@ func (l *Logger) PrintReq(username, upstream string, req *http.Request, url url.
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.reqTemplate.Execute(l.writer, reqLogMessageData{
+	l.write(l.reqTemplate, reqLogMessageData{
+		Event:           requestEvent,
 		Client:          client,
 		Host:            req.Host,
 		Protocol:        req.Proto,
 		RequestDuration: fmt.Sprintf("%0.3f", duration),
 		RequestMethod:   req.Method,
-		RequestURI:      fmt.Sprintf("%q", url.RequestURI()),
+		RequestURI:      l.quote(url.RequestURI()),
 		ResponseSize:    fmt.Sprintf("%d", size),
 		StatusCode:      fmt.Sprintf("%d", status),
-		Timestamp:       FormatTimestamp(ts),
+		Timestamp:       l.timestamp(ts),
 		Upstream:        upstream,
-		UserAgent:       fmt.Sprintf("%q", req.UserAgent()),
+		UserAgent:       l.quote(req.UserAgent()),
 		Username:        username,
 	})
+}
 
+// write outputs a single log line, either as a JSON object or using the
+// template. The caller must hold the lock.
+func (l *Logger) write(t *template.Template, data interface{}) {
+	if l.jsonEnabled {
+		enc := json.NewEncoder(l.writer)
+		enc.SetEscapeHTML(false)
+		enc.Encode(data)
+		return
+	}
+
+	t.Execute(l.writer, data)
 	l.writer.Write([]byte("\n"))
 }
 
+// quote quotes values that may contain spaces in the templated formats.
+// JSON values are already escaped so are left unquoted.
+func (l *Logger) quote(s string) string {
+	if l.jsonEnabled {
+		return s
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// timestamp formats the timestamp of a log line, JSON log lines use RFC 3339
+// timestamps. The caller must hold the lock.
+func (l *Logger) timestamp(ts time.Time) string {
+	if !l.jsonEnabled {
+		return FormatTimestamp(ts)
+	}
+	if l.flag&LUTC != 0 {
+		ts = ts.UTC()
+	}
+	return ts.Format(time.RFC3339)
+}
+
 // GetFileLineString will find the caller file and line number
 // taking in to account the calldepth to iterate up the stack
 // to find the non-logging call location.
@@ -298,6 +347,13 @@ func (l *Logger) SetReqEnabled(e bool) {
 	l.reqEnabled = e
 }
 
+// SetJSONEnabled enables or disables JSON output for all logging.
+func (l *Logger) SetJSONEnabled(e bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.jsonEnabled = e
+}
+
 // SetGetClientFunc sets the function which determines the apparent "real client IP".
 func (l *Logger) SetGetClientFunc(f GetClientFunc) {
 	l.mu.Lock()
@@ -378,6 +434,11 @@ func SetReqEnabled(e bool) {
 	std.SetReqEnabled(e)
 }
 
+// SetJSONEnabled enables or disables JSON output for the standard logger.
+func SetJSONEnabled(e bool) {
+	std.SetJSONEnabled(e)
+}
+
 // SetGetClientFunc sets the function which determines the apparent IP address
 // set by a reverse proxy for the standard logger.
 func SetGetClientFunc(f GetClientFunc) {
@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLogger(json bool) (*Logger, *bytes.Buffer) {
+	buf := &bytes.Buffer{}
+	l := New(Lshortfile | LUTC)
+	l.writer = buf
+	l.SetJSONEnabled(json)
+	return l, buf
+}
+
+func TestStandardLogTemplate(t *testing.T) {
+	l, buf := newTestLogger(false)
+	l.SetStandardTemplate("{{.File}} {{.Message}}")
+	l.Output(1, "hello \"world\"")
+
+	assert.Regexp(t, `^logger_test.go:\d+ hello "world"\n$`, buf.String())
+}
+
+func TestStandardLogJSON(t *testing.T) {
+	l, buf := newTestLogger(true)
+	l.Output(1, "hello \"world\" <br>")
+
+	var line map[string]string
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "standard", line["event"])
+	assert.Equal(t, "hello \"world\" <br>", line["message"])
+	assert.Regexp(t, `^logger_test.go:\d+$`, line["file"])
+	_, err := time.Parse(time.RFC3339, line["timestamp"])
+	assert.NoError(t, err)
+	assert.Equal(t, byte('\n'), buf.Bytes()[buf.Len()-1])
+}
+
+func TestAuthLogJSON(t *testing.T) {
+	l, buf := newTestLogger(true)
+	req := httptest.NewRequest("GET", "http://example.com/oauth2/callback", nil)
+	req.Header.Set("User-Agent", "test \"agent\"")
+	l.PrintAuthf("john.doe@example.com", req, AuthFailure, "Invalid authentication via %s", "OAuth2")
+
+	var line map[string]string
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, map[string]string{
+		"event":          "auth",
+		"client":         "192.0.2.1:1234",
+		"host":           "example.com",
+		"protocol":       "HTTP/1.1",
+		"request_method": "GET",
+		"timestamp":      line["timestamp"],
+		"user_agent":     "test \"agent\"",
+		"username":       "john.doe@example.com",
+		"status":         "AuthFailure",
+		"message":        "Invalid authentication via OAuth2",
+	}, line)
+}
+
+func TestRequestLogJSON(t *testing.T) {
+	l, buf := newTestLogger(true)
+	req := httptest.NewRequest("GET", "http://example.com/foo?bar=baz", nil)
+	req.Header.Set("User-Agent", "test-agent")
+	l.PrintReq("", "http://upstream", req, *req.URL, time.Now(), 200, 1024)
+
+	var line map[string]string
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, map[string]string{
+		"event":            "request",
+		"client":           "192.0.2.1:1234",
+		"host":             "example.com",
+		"protocol":         "HTTP/1.1",
+		"request_duration": line["request_duration"],
+		"request_method":   "GET",
+		"request_uri":      "/foo?bar=baz",
+		"response_size":    "1024",
+		"status_code":      "200",
+		"timestamp":        line["timestamp"],
+		"upstream":         "http://upstream",
+		"user_agent":       "test-agent",
+		"username":         "-",
+	}, line)
+}
+
+func TestRequestLogTemplateQuotesValues(t *testing.T) {
+	l, buf := newTestLogger(false)
+	l.SetReqTemplate("{{.RequestURI}} {{.UserAgent}}")
+	req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+	req.Header.Set("User-Agent", "test agent")
+	l.PrintReq("", "", req, *req.URL, time.Now(), 200, 0)
+
+	assert.Equal(t, "\"/foo\" \"test agent\"\n", buf.String())
+}
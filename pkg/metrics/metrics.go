@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "oauth2_proxy"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Total number of requests by status code, method and upstream.",
+	}, []string{"code", "method", "upstream"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Request latencies in seconds by status code and upstream.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code", "upstream"})
+
+	authTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_total",
+		Help:      "Total number of authentication attempts by outcome.",
+	}, []string{"status"})
+
+	sessionStoreDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "session_store_duration_seconds",
+		Help:      "Session store operation latencies in seconds.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	sessionStoreErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "session_store_errors_total",
+		Help:      "Total number of failed session store operations.",
+	}, []string{"operation"})
+
+	tokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Total number of session refreshes by result.",
+	}, []string{"result"})
+
+	redeemFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redeem_failures_total",
+		Help:      "Total number of failed authorization code redemptions by provider.",
+	}, []string{"provider"})
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		authTotal,
+		sessionStoreDuration,
+		sessionStoreErrors,
+		tokenRefreshes,
+		redeemFailures,
+	)
+}
+
+// Session store operations
+const (
+	SessionLoad  = "load"
+	SessionSave  = "save"
+	SessionClear = "clear"
+)
+
+// Token refresh results
+const (
+	RefreshSuccess = "success"
+	RefreshError   = "error"
+)
+
+// Handler serves the collected metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a completed request
+func ObserveRequest(code, method, upstream string, duration time.Duration) {
+	requestsTotal.WithLabelValues(code, method, upstream).Inc()
+	requestDuration.WithLabelValues(code, upstream).Observe(duration.Seconds())
+}
+
+// IncAuth counts an authentication attempt with the given outcome
+func IncAuth(status string) {
+	authTotal.WithLabelValues(status).Inc()
+}
+
+// ObserveSessionStore records a session store operation that started at start
+func ObserveSessionStore(operation string, start time.Time, err error) {
+	sessionStoreDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		sessionStoreErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// IncTokenRefresh counts a session refresh with the given result
+func IncTokenRefresh(result string) {
+	tokenRefreshes.WithLabelValues(result).Inc()
+}
+
+// IncRedeemFailure counts a failed code redemption for the given provider
+func IncRedeemFailure(provider string) {
+	redeemFailures.WithLabelValues(provider).Inc()
+}
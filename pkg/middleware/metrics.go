@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/metrics"
+)
+
+// upstreamHeader is set on the response by the upstream proxy to identify
+// the upstream that handled the request
+const upstreamHeader = "GAP-Upstream-Address"
+
+// NewRequestMetrics records the status, latency and upstream of each request.
+// It must be registered after the logging handler as the logging handler
+// removes the upstream header from the response.
+func NewRequestMetrics() alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return requestMetrics(next)
+	}
+}
+
+func requestMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: rw}
+		next.ServeHTTP(recorder, req)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.ObserveRequest(strconv.Itoa(status), req.Method, recorder.upstream, time.Since(start))
+	})
+}
+
+// statusRecorder records the status code and upstream of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	upstream string
+}
+
+// WriteHeader records the status code and upstream before writing the header
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		r.upstream = r.Header().Get(upstreamHeader)
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit status OK before writing the body
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Hijack supports websocket connections
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := r.ResponseWriter.(http.Hijacker); ok {
+		if r.status == 0 {
+			r.status = http.StatusSwitchingProtocols
+			r.upstream = r.Header().Get(upstreamHeader)
+		}
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker is not available on writer")
+}
+
+// Flush sends any buffered data to the client
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
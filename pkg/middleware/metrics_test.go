@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestMetrics suite", func() {
+	type requestTableInput struct {
+		method          string
+		handler         http.Handler
+		expectedMetrics []string
+	}
+
+	scrape := func() string {
+		rw := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+		return rw.Body.String()
+	}
+
+	DescribeTable("when serving a request",
+		func(in *requestTableInput) {
+			req := httptest.NewRequest(in.method, "http://example.com/", nil)
+			rw := httptest.NewRecorder()
+
+			handler := NewRequestMetrics()(in.handler)
+			handler.ServeHTTP(rw, req)
+
+			body := scrape()
+			for _, m := range in.expectedMetrics {
+				Expect(body).To(ContainSubstring(m))
+			}
+		},
+		Entry("with an implicit status", &requestTableInput{
+			method:  "PUT",
+			handler: testHandler(),
+			expectedMetrics: []string{
+				`oauth2_proxy_requests_total{code="200",method="PUT",upstream=""} 1`,
+				`oauth2_proxy_request_duration_seconds_count{code="200",upstream=""}`,
+			},
+		}),
+		Entry("with a request to an upstream", &requestTableInput{
+			method: "PATCH",
+			handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.Header().Set("GAP-Upstream-Address", "upstream.example.com")
+				rw.WriteHeader(http.StatusAccepted)
+			}),
+			expectedMetrics: []string{
+				`oauth2_proxy_requests_total{code="202",method="PATCH",upstream="upstream.example.com"} 1`,
+				`oauth2_proxy_request_duration_seconds_count{code="202",upstream="upstream.example.com"} 1`,
+			},
+		}),
+	)
+
+	It("flushes the response to the client", func() {
+		rw := httptest.NewRecorder()
+		handler := NewRequestMetrics()(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			flusher, ok := rw.(http.Flusher)
+			Expect(ok).To(BeTrue())
+			rw.Write([]byte("event"))
+			flusher.Flush()
+		}))
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "http://example.com/", nil))
+		Expect(rw.Flushed).To(BeTrue())
+	})
+})
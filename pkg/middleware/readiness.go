@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/justinas/alice"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+)
+
+// readinessCheckTimeout bounds how long the checks of a readiness request take
+const readinessCheckTimeout = 5 * time.Second
+
+// ReadinessCheck checks whether a dependency of the proxy is ready, eg that
+// the session store can be reached
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// readinessResponse is the JSON body of responses to readiness requests
+type readinessResponse struct {
+	Status string                `json:"status"`
+	Checks []readinessCheckState `json:"checks"`
+}
+
+type readinessCheckState struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	readinessOK    = "ok"
+	readinessError = "error"
+)
+
+// NewReadinessCheck creates a new readinessCheck middleware that runs the
+// checks on requests to the readiness paths. It responds with 200 if all of
+// them pass and 503 otherwise, with a JSON body describing each check.
+func NewReadinessCheck(paths []string, checks []ReadinessCheck) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return readinessCheck(paths, checks, next)
+	}
+}
+
+func readinessCheck(paths []string, checks []ReadinessCheck, next http.Handler) http.Handler {
+	// Use a map as a set to check readiness check paths
+	pathSet := make(map[string]struct{})
+	for _, path := range paths {
+		if path != "" {
+			pathSet[path] = struct{}{}
+		}
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if _, ok := pathSet[req.URL.EscapedPath()]; !ok {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		resp := runReadinessChecks(req.Context(), checks)
+		code := http.StatusOK
+		if resp.Status != readinessOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(code)
+		if err := json.NewEncoder(rw).Encode(resp); err != nil {
+			logger.Printf("Error writing readiness check response: %v", err)
+		}
+	})
+}
+
+// runReadinessChecks runs the checks concurrently
+func runReadinessChecks(ctx context.Context, checks []ReadinessCheck) readinessResponse {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	resp := readinessResponse{
+		Status: readinessOK,
+		Checks: make([]readinessCheckState, len(checks)),
+	}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(state *readinessCheckState, check ReadinessCheck) {
+			defer wg.Done()
+			state.Name = check.Name
+			state.Status = readinessOK
+			if err := check.Check(ctx); err != nil {
+				state.Status = readinessError
+				state.Error = err.Error()
+			}
+		}(&resp.Checks[i], check)
+	}
+	wg.Wait()
+
+	for _, state := range resp.Checks {
+		if state.Status != readinessOK {
+			resp.Status = readinessError
+		}
+	}
+	return resp
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReadinessCheck suite", func() {
+	passing := ReadinessCheck{
+		Name:  "passing",
+		Check: func(context.Context) error { return nil },
+	}
+	failing := ReadinessCheck{
+		Name:  "failing",
+		Check: func(context.Context) error { return errors.New("connection refused") },
+	}
+
+	type requestTableInput struct {
+		readinessCheckPaths []string
+		checks              []ReadinessCheck
+		requestString       string
+		expectedStatus      int
+		expectedBody        string
+	}
+
+	DescribeTable("when serving a request",
+		func(in *requestTableInput) {
+			req := httptest.NewRequest("", in.requestString, nil)
+			rw := httptest.NewRecorder()
+
+			handler := NewReadinessCheck(in.readinessCheckPaths, in.checks)(http.NotFoundHandler())
+			handler.ServeHTTP(rw, req)
+
+			Expect(rw.Code).To(Equal(in.expectedStatus))
+			if in.expectedStatus == 404 {
+				Expect(rw.Body.String()).To(Equal(in.expectedBody))
+			} else {
+				Expect(rw.Header().Get("Content-Type")).To(Equal("application/json"))
+				Expect(rw.Body.String()).To(MatchJSON(in.expectedBody))
+			}
+		},
+		Entry("when all checks pass", &requestTableInput{
+			readinessCheckPaths: []string{"/ready"},
+			checks:              []ReadinessCheck{passing, passing},
+			requestString:       "http://example.com/ready",
+			expectedStatus:      200,
+			expectedBody: `{"status": "ok", "checks": [
+				{"name": "passing", "status": "ok"},
+				{"name": "passing", "status": "ok"}
+			]}`,
+		}),
+		Entry("when a check fails", &requestTableInput{
+			readinessCheckPaths: []string{"/ready", "/readiness_check"},
+			checks:              []ReadinessCheck{passing, failing},
+			requestString:       "http://example.com/readiness_check",
+			expectedStatus:      503,
+			expectedBody: `{"status": "error", "checks": [
+				{"name": "passing", "status": "ok"},
+				{"name": "failing", "status": "error", "error": "connection refused"}
+			]}`,
+		}),
+		Entry("without checks", &requestTableInput{
+			readinessCheckPaths: []string{"/ready"},
+			checks:              []ReadinessCheck{},
+			requestString:       "http://example.com/ready",
+			expectedStatus:      200,
+			expectedBody:        `{"status": "ok", "checks": []}`,
+		}),
+		Entry("when requesting a different path", &requestTableInput{
+			readinessCheckPaths: []string{"/ready"},
+			checks:              []ReadinessCheck{failing},
+			requestString:       "http://example.com/different",
+			expectedStatus:      404,
+			expectedBody:        "404 page not found\n",
+		}),
+		Entry("when the readiness path is empty", &requestTableInput{
+			readinessCheckPaths: []string{""},
+			checks:              []ReadinessCheck{failing},
+			requestString:       "http://example.com",
+			expectedStatus:      404,
+			expectedBody:        "404 page not found\n",
+		}),
+	)
+
+	It("cancels checks that take too long", func() {
+		slow := ReadinessCheck{
+			Name: "slow",
+			Check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("", "http://example.com/ready", nil).WithContext(ctx)
+		rw := httptest.NewRecorder()
+
+		NewReadinessCheck([]string{"/ready"}, []ReadinessCheck{slow})(http.NotFoundHandler()).ServeHTTP(rw, req)
+		Expect(rw.Code).To(Equal(503))
+		Expect(rw.Body.String()).To(MatchJSON(`{"status": "error", "checks": [
+			{"name": "slow", "status": "error", "error": "context canceled"}
+		]}`))
+	})
+})
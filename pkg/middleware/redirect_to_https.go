@@ -37,6 +37,11 @@ func redirectToHTTPS(httpsPort string, next http.Handler) http.Handler {
 		targetURL, _ := url.Parse(req.URL.String())
 		// Set the scheme to HTTPS
 		targetURL.Scheme = httpsScheme
+		// The URL of requests received by a server only has a path, the host
+		// is taken from the Host header
+		if targetURL.Host == "" {
+			targetURL.Host = req.Host
+		}
 
 		// Overwrite the port if the original request was to a non-standard port
 		if targetURL.Port() != "" {
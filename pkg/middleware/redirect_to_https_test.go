@@ -154,5 +154,13 @@ var _ = Describe("RedirectToHTTPS suite", func() {
 			expectedStatus: 200,
 			expectedBody:   "test",
 		}),
+		Entry("without TLS and a request URL with only a path", &requestTableInput{
+			requestString:    "/foo",
+			useTLS:           false,
+			headers:          map[string]string{},
+			expectedStatus:   308,
+			expectedBody:     permanentRedirectBody("https://example.com/foo"),
+			expectedLocation: "https://example.com/foo",
+		}),
 	)
 })
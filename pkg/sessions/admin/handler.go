@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+)
+
+// handler serves the session admin API:
+//
+//	GET    <path>?email=<email>  lists the sessions of the user
+//	DELETE <path>?email=<email>  revokes all sessions of the user
+//	DELETE <path>/<id>           revokes a single session
+//
+// Every request must present the admin token as a bearer token.
+type handler struct {
+	path  string
+	token []byte
+	store sessionsapi.SessionAdmin
+}
+
+// NewHandler creates the session admin API handler for requests under path
+func NewHandler(path, token string, store sessionsapi.SessionAdmin) http.Handler {
+	return &handler{
+		path:  strings.TrimSuffix(path, "/"),
+		token: []byte(token),
+		store: store,
+	}
+}
+
+func (h *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Cache-Control", "no-store")
+	if !h.authorized(req) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(rw, http.StatusUnauthorized)
+		return
+	}
+
+	switch id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, h.path), "/"); {
+	case id == "" && req.Method == http.MethodGet:
+		h.listSessions(rw, req)
+	case id == "" && req.Method == http.MethodDelete:
+		h.revokeUserSessions(rw, req)
+	case id != "" && !strings.Contains(id, "/") && req.Method == http.MethodDelete:
+		h.revokeSession(rw, req, id)
+	case id == "" || !strings.Contains(id, "/"):
+		writeError(rw, http.StatusMethodNotAllowed)
+	default:
+		writeError(rw, http.StatusNotFound)
+	}
+}
+
+func (h *handler) authorized(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	return subtle.ConstantTimeCompare(token, h.token) == 1
+}
+
+func (h *handler) listSessions(rw http.ResponseWriter, req *http.Request) {
+	email := req.URL.Query().Get("email")
+	if email == "" {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{Error: "missing email"})
+		return
+	}
+
+	infos, err := h.store.ListSessions(req.Context(), email)
+	if err != nil {
+		logger.Printf("Error listing sessions for %s: %v", email, err)
+		writeError(rw, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, http.StatusOK, struct {
+		Sessions []*sessionsapi.SessionInfo `json:"sessions"`
+	}{Sessions: infos})
+}
+
+func (h *handler) revokeUserSessions(rw http.ResponseWriter, req *http.Request) {
+	email := req.URL.Query().Get("email")
+	if email == "" {
+		writeJSON(rw, http.StatusBadRequest, errorResponse{Error: "missing email"})
+		return
+	}
+
+	revoked, err := h.store.RevokeUserSessions(req.Context(), email)
+	if err != nil {
+		logger.Printf("Error revoking sessions for %s: %v", email, err)
+		writeError(rw, http.StatusInternalServerError)
+		return
+	}
+	logger.Printf("Revoked %d session(s) for %s via the admin API", revoked, email)
+	writeJSON(rw, http.StatusOK, struct {
+		Revoked int `json:"revoked"`
+	}{Revoked: revoked})
+}
+
+func (h *handler) revokeSession(rw http.ResponseWriter, req *http.Request, id string) {
+	revoked, err := h.store.RevokeSession(req.Context(), id)
+	if err != nil {
+		logger.Printf("Error revoking session %s: %v", id, err)
+		writeError(rw, http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		writeJSON(rw, http.StatusNotFound, errorResponse{Error: "session not found"})
+		return
+	}
+	logger.Printf("Revoked session %s via the admin API", id)
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(rw http.ResponseWriter, code int) {
+	writeJSON(rw, code, errorResponse{Error: http.StatusText(code)})
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		logger.Printf("Error encoding admin API response: %v", err)
+	}
+}
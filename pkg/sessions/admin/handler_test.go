@@ -0,0 +1,186 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	sessions map[string]*sessionsapi.SessionInfo
+	err      error
+}
+
+func (f *fakeStore) ListSessions(_ context.Context, email string) ([]*sessionsapi.SessionInfo, error) {
+	infos := []*sessionsapi.SessionInfo{}
+	for _, id := range []string{"01", "02", "03"} {
+		if info, ok := f.sessions[id]; ok && info.Email == email {
+			infos = append(infos, info)
+		}
+	}
+	return infos, f.err
+}
+
+func (f *fakeStore) RevokeSession(_ context.Context, id string) (bool, error) {
+	_, ok := f.sessions[id]
+	delete(f.sessions, id)
+	return ok, f.err
+}
+
+func (f *fakeStore) RevokeUserSessions(_ context.Context, email string) (int, error) {
+	revoked := 0
+	for id, info := range f.sessions {
+		if info.Email == email {
+			delete(f.sessions, id)
+			revoked++
+		}
+	}
+	return revoked, f.err
+}
+
+func newFakeStore() *fakeStore {
+	created := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	return &fakeStore{
+		sessions: map[string]*sessionsapi.SessionInfo{
+			"01": {ID: "01", Email: "alice@example.com", CreatedAt: &created},
+			"02": {ID: "02", Email: "alice@example.com", ProviderID: "contractors"},
+			"03": {ID: "03", Email: "bob@example.com"},
+		},
+	}
+}
+
+func TestHandler(t *testing.T) {
+	testCases := []struct {
+		name              string
+		method            string
+		path              string
+		token             string
+		storeErr          error
+		expectedCode      int
+		expectedBody      string
+		expectedRemaining []string
+	}{
+		{
+			name:              "list sessions",
+			method:            "GET",
+			path:              "/oauth2/admin/sessions?email=alice@example.com",
+			token:             "secret",
+			expectedCode:      http.StatusOK,
+			expectedBody:      `{"sessions":[{"id":"01","email":"alice@example.com","created_at":"2020-06-01T12:00:00Z"},{"id":"02","email":"alice@example.com","provider_id":"contractors"}]}` + "\n",
+			expectedRemaining: []string{"01", "02", "03"},
+		},
+		{
+			name:              "list sessions without an email",
+			method:            "GET",
+			path:              "/oauth2/admin/sessions",
+			token:             "secret",
+			expectedCode:      http.StatusBadRequest,
+			expectedBody:      `{"error":"missing email"}` + "\n",
+			expectedRemaining: []string{"01", "02", "03"},
+		},
+		{
+			name:              "revoke all sessions of a user",
+			method:            "DELETE",
+			path:              "/oauth2/admin/sessions?email=alice@example.com",
+			token:             "secret",
+			expectedCode:      http.StatusOK,
+			expectedBody:      `{"revoked":2}` + "\n",
+			expectedRemaining: []string{"03"},
+		},
+		{
+			name:              "revoke one session",
+			method:            "DELETE",
+			path:              "/oauth2/admin/sessions/02",
+			token:             "secret",
+			expectedCode:      http.StatusNoContent,
+			expectedRemaining: []string{"01", "03"},
+		},
+		{
+			name:              "revoke an unknown session",
+			method:            "DELETE",
+			path:              "/oauth2/admin/sessions/04",
+			token:             "secret",
+			expectedCode:      http.StatusNotFound,
+			expectedBody:      `{"error":"session not found"}` + "\n",
+			expectedRemaining: []string{"01", "02", "03"},
+		},
+		{
+			name:              "invalid token",
+			method:            "DELETE",
+			path:              "/oauth2/admin/sessions?email=alice@example.com",
+			token:             "wrong",
+			expectedCode:      http.StatusUnauthorized,
+			expectedBody:      `{"error":"Unauthorized"}` + "\n",
+			expectedRemaining: []string{"01", "02", "03"},
+		},
+		{
+			name:              "missing token",
+			method:            "GET",
+			path:              "/oauth2/admin/sessions?email=alice@example.com",
+			expectedCode:      http.StatusUnauthorized,
+			expectedBody:      `{"error":"Unauthorized"}` + "\n",
+			expectedRemaining: []string{"01", "02", "03"},
+		},
+		{
+			name:              "unsupported method",
+			method:            "POST",
+			path:              "/oauth2/admin/sessions/01",
+			token:             "secret",
+			expectedCode:      http.StatusMethodNotAllowed,
+			expectedBody:      `{"error":"Method Not Allowed"}` + "\n",
+			expectedRemaining: []string{"01", "02", "03"},
+		},
+		{
+			name:              "unknown path",
+			method:            "DELETE",
+			path:              "/oauth2/admin/sessions/01/other",
+			token:             "secret",
+			expectedCode:      http.StatusNotFound,
+			expectedBody:      `{"error":"Not Found"}` + "\n",
+			expectedRemaining: []string{"01", "02", "03"},
+		},
+		{
+			name:              "store error",
+			method:            "GET",
+			path:              "/oauth2/admin/sessions?email=alice@example.com",
+			token:             "secret",
+			storeErr:          errors.New("connection refused"),
+			expectedCode:      http.StatusInternalServerError,
+			expectedBody:      `{"error":"Internal Server Error"}` + "\n",
+			expectedRemaining: []string{"01", "02", "03"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := newFakeStore()
+			store.err = tc.storeErr
+			h := NewHandler("/oauth2/admin/sessions", "secret", store)
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, req)
+
+			assert.Equal(t, tc.expectedCode, rw.Code)
+			assert.Equal(t, tc.expectedBody, rw.Body.String())
+			assert.Equal(t, "no-store", rw.Header().Get("Cache-Control"))
+
+			var remaining []string
+			for _, id := range []string{"01", "02", "03"} {
+				if _, ok := store.sessions[id]; ok {
+					remaining = append(remaining, id)
+				}
+			}
+			assert.Equal(t, tc.expectedRemaining, remaining)
+		})
+	}
+}
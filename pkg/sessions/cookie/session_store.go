@@ -29,7 +29,9 @@ var _ sessions.SessionStore = &SessionStore{}
 // interface that stores sessions in client side cookies
 type SessionStore struct {
 	CookieOptions *options.CookieOptions
-	CookieCipher  encryption.Cipher
+	// CookieCiphers holds a Cipher for each of the cookie secrets. Sessions
+	// are encrypted with the first.
+	CookieCiphers []encryption.Cipher
 }
 
 // Save takes a sessions.SessionState and stores the information from it
@@ -39,7 +41,7 @@ func (s *SessionStore) Save(rw http.ResponseWriter, req *http.Request, ss *sessi
 		now := time.Now()
 		ss.CreatedAt = &now
 	}
-	value, err := cookieForSession(ss, s.CookieCipher)
+	value, err := cookieForSession(ss, s.CookieCiphers[0])
 	if err != nil {
 		return err
 	}
@@ -55,12 +57,14 @@ func (s *SessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
 		// always http.ErrNoCookie
 		return nil, fmt.Errorf("cookie %q not present", s.CookieOptions.Name)
 	}
-	val, _, ok := encryption.Validate(c, s.CookieOptions.Secret, s.CookieOptions.Expire)
+	// Cookies signed with a previous secret are decrypted with the same
+	// secret, and signed and encrypted with the current one when saved again
+	val, _, secret, ok := encryption.ValidateWithSecrets(c, s.CookieOptions.Secrets(), s.CookieOptions.Expire)
 	if !ok {
 		return nil, errors.New("cookie signature not valid")
 	}
 
-	session, err := sessionFromCookie(string(val), s.CookieCipher)
+	session, err := sessionFromCookie(string(val), s.CookieCiphers[secret])
 	if err != nil {
 		return nil, err
 	}
@@ -129,13 +133,13 @@ func (s *SessionStore) makeCookie(req *http.Request, name string, value string,
 // NewCookieSessionStore initialises a new instance of the SessionStore from
 // the configuration given
 func NewCookieSessionStore(opts *options.SessionOptions, cookieOpts *options.CookieOptions) (sessions.SessionStore, error) {
-	cipher, err := encryption.NewBase64Cipher(encryption.NewCFBCipher, encryption.SecretBytes(cookieOpts.Secret))
+	ciphers, err := encryption.NewBase64Ciphers(encryption.NewCFBCipher, cookieOpts.Secrets())
 	if err != nil {
 		return nil, fmt.Errorf("error initialising cipher: %v", err)
 	}
 
 	return &SessionStore{
-		CookieCipher:  cipher,
+		CookieCiphers: ciphers,
 		CookieOptions: cookieOpts,
 	}, nil
 }
@@ -0,0 +1,328 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
+)
+
+// reapInterval is how often expired sessions are removed from the cache
+const reapInterval = time.Minute
+
+var (
+	sharedCacheMutex sync.Mutex
+	// sharedCache is the cache of the session stores of the process. It is
+	// shared so that sessions are kept when the configuration is reloaded,
+	// as the session store of the new configuration is created before the
+	// previous one is closed.
+	sharedCache *cache
+)
+
+// SessionStore is an implementation of the sessions.SessionStore
+// interface that stores sessions in memory, with a ticket to them in the
+// session cookie as for the redis session store. It is meant for deployments
+// with a single instance of the proxy.
+type SessionStore struct {
+	*persistence.Manager
+
+	cache     *cache
+	closeOnce sync.Once
+}
+
+// NewMemorySessionStore initialises a new instance of the SessionStore from
+// the configuration given. The sessions of the snapshot file are loaded when
+// the first session store of the process is created.
+func NewMemorySessionStore(opts *options.SessionOptions, cookieOpts *options.CookieOptions) (sessions.SessionStore, error) {
+	c := acquireCache(opts.Memory)
+	manager, err := persistence.NewManager(c, cookieOpts)
+	if err != nil {
+		releaseCache(c)
+		return nil, err
+	}
+	return &SessionStore{
+		Manager: manager,
+		cache:   c,
+	}, nil
+}
+
+// Close releases the cache of the session store. Once the session stores of
+// the process are all closed, the sessions are written to the snapshot file.
+func (store *SessionStore) Close() error {
+	var err error
+	store.closeOnce.Do(func() {
+		err = releaseCache(store.cache)
+	})
+	return err
+}
+
+// acquireCache returns the shared cache, creating it if needed, and applies
+// the options to it
+func acquireCache(opts options.MemoryStoreOptions) *cache {
+	sharedCacheMutex.Lock()
+	defer sharedCacheMutex.Unlock()
+
+	if sharedCache == nil {
+		sharedCache = newCache(opts.MaxSessions, time.Now)
+		if opts.SnapshotPath != "" {
+			loaded, err := sharedCache.loadSnapshot(opts.SnapshotPath)
+			if err != nil {
+				logger.Printf("Error loading session snapshot, starting without sessions: %v", err)
+			} else if loaded > 0 {
+				logger.Printf("Loaded %d sessions from %s", loaded, opts.SnapshotPath)
+			}
+		}
+		go sharedCache.reapSessions(reapInterval)
+	}
+	sharedCache.configure(opts)
+	sharedCache.refs++
+	return sharedCache
+}
+
+// releaseCache releases a reference to the shared cache, and writes its
+// snapshot when it is no longer referenced
+func releaseCache(c *cache) error {
+	sharedCacheMutex.Lock()
+	defer sharedCacheMutex.Unlock()
+
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	close(c.stop)
+	sharedCache = nil
+
+	c.mu.Lock()
+	path := c.snapshotPath
+	c.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	saved, err := c.saveSnapshot(path)
+	if err != nil {
+		return fmt.Errorf("error saving session snapshot: %v", err)
+	}
+	logger.Printf("Saved %d sessions to %s", saved, path)
+	return nil
+}
+
+// cache is a persistence.Store that keeps values in memory until they
+// expire, evicting the least recently used values beyond maxSessions
+type cache struct {
+	mu           sync.Mutex
+	entries      map[string]*list.Element
+	order        *list.List // of *entry, most recently used first
+	maxSessions  int
+	snapshotPath string
+
+	// now returns the current time, to check the expiry of values
+	now func() time.Time
+
+	// refs counts the session stores using the cache. It is guarded by
+	// sharedCacheMutex.
+	refs int
+	stop chan struct{}
+}
+
+// entry is a value stored in the cache, and in its snapshot
+type entry struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// snapshot is the format of the snapshot file
+type snapshot struct {
+	// Sessions are ordered from most to least recently used
+	Sessions []*entry `json:"sessions"`
+}
+
+func newCache(maxSessions int, now func() time.Time) *cache {
+	return &cache{
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		maxSessions: maxSessions,
+		now:         now,
+		stop:        make(chan struct{}),
+	}
+}
+
+// configure applies the options of the session store created last
+func (c *cache) configure(opts options.MemoryStoreOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.maxSessions = opts.MaxSessions
+	c.snapshotPath = opts.SnapshotPath
+	c.evict()
+}
+
+// Save stores the value under the key, replacing any existing value
+func (c *cache) Save(_ context.Context, key string, value []byte, expiration time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &entry{Key: key, Value: value, ExpiresAt: c.now().Add(expiration)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = e
+		c.order.MoveToFront(elem)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(e)
+	c.evict()
+	return nil
+}
+
+// Load returns the value stored under the key if it hasn't expired
+func (c *cache) Load(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	e := elem.Value.(*entry)
+	if !c.now().Before(e.ExpiresAt) {
+		c.remove(elem)
+		return nil, errors.New("session not found")
+	}
+	c.order.MoveToFront(elem)
+	return e.Value, nil
+}
+
+// Clear removes the value stored under the key
+func (c *cache) Clear(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	return nil
+}
+
+// evict removes the least recently used values beyond maxSessions. The
+// cache must be locked.
+func (c *cache) evict() {
+	for c.maxSessions > 0 && c.order.Len() > c.maxSessions {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove removes the element from the cache, which must be locked
+func (c *cache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*entry).Key)
+}
+
+// reap removes the expired values and returns how many were removed
+func (c *cache) reap() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	reaped := 0
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if !now.Before(elem.Value.(*entry).ExpiresAt) {
+			c.remove(elem)
+			reaped++
+		}
+		elem = next
+	}
+	return reaped
+}
+
+// reapSessions removes expired values every interval until the cache is no
+// longer used
+func (c *cache) reapSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.reap()
+		}
+	}
+}
+
+// saveSnapshot writes the values that haven't expired to the file, replacing
+// it by renaming a temporary file so that a partially written snapshot is
+// never loaded. The values are encrypted with the secrets of their tickets,
+// which are only in the session cookies.
+func (c *cache) saveSnapshot(path string) (int, error) {
+	c.mu.Lock()
+	now := c.now()
+	s := snapshot{Sessions: make([]*entry, 0, c.order.Len())}
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		if e := elem.Value.(*entry); now.Before(e.ExpiresAt) {
+			s.Sessions = append(s.Sessions, e)
+		}
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(&s)
+	if err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return len(s.Sessions), nil
+}
+
+// loadSnapshot loads the values of the snapshot file that haven't expired,
+// up to maxSessions, and removes the file. The file is removed so that the
+// sessions cleared after it was loaded can't be loaded from it again if the
+// proxy stops without writing a new snapshot. A missing file is ignored.
+func (c *cache) loadSnapshot(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if err := os.Remove(path); err != nil {
+		return 0, err
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, e := range s.Sessions {
+		if c.maxSessions > 0 && c.order.Len() >= c.maxSessions {
+			break
+		}
+		if e == nil || !now.Before(e.ExpiresAt) {
+			continue
+		}
+		if _, ok := c.entries[e.Key]; ok {
+			continue
+		}
+		c.entries[e.Key] = c.order.PushBack(e)
+	}
+	return c.order.Len(), nil
+}
@@ -0,0 +1,282 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSessionStore(t *testing.T) {
+	logger.SetOutput(GinkgoWriter)
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memory SessionStore")
+}
+
+var _ = Describe("Memory SessionStore Tests", func() {
+	// offset is added to the time of the shared cache to fast forward the
+	// expiry of sessions
+	var offset *time.Duration
+	var stores []*SessionStore
+
+	BeforeEach(func() {
+		offset = new(time.Duration)
+		stores = nil
+	})
+
+	newStore := func(opts *options.SessionOptions, cookieOpts *options.CookieOptions) (sessionsapi.SessionStore, error) {
+		opts.Type = options.MemorySessionStoreType
+
+		ss, err := NewMemorySessionStore(opts, cookieOpts)
+		if err != nil {
+			return nil, err
+		}
+		store := ss.(*SessionStore)
+		stores = append(stores, store)
+		storeOffset := offset
+		store.cache.mu.Lock()
+		store.cache.now = func() time.Time {
+			return time.Now().Add(*storeOffset)
+		}
+		store.cache.mu.Unlock()
+		return ss, nil
+	}
+
+	JustAfterEach(func() {
+		// Release the shared cache so that the next test starts without
+		// sessions
+		for _, store := range stores {
+			Expect(store.Close()).To(Succeed())
+		}
+	})
+
+	tests.RunSessionStoreTests(
+		newStore,
+		func(d time.Duration) error {
+			*offset += d
+			return nil
+		},
+	)
+
+	save := func(c *cache, keys ...string) {
+		for _, key := range keys {
+			Expect(c.Save(context.Background(), key, []byte("value of "+key), time.Hour)).To(Succeed())
+		}
+	}
+
+	keysOf := func(c *cache) []string {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		keys := []string{}
+		for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+			keys = append(keys, elem.Value.(*entry).Key)
+		}
+		return keys
+	}
+
+	Context("with a maximum number of sessions", func() {
+		var c *cache
+
+		BeforeEach(func() {
+			c = newCache(3, time.Now)
+			save(c, "a", "b", "c")
+		})
+
+		It("evicts the least recently used session beyond it", func() {
+			save(c, "d")
+			Expect(keysOf(c)).To(Equal([]string{"d", "c", "b"}))
+
+			_, err := c.Load(context.Background(), "a")
+			Expect(err).To(MatchError("session not found"))
+		})
+
+		It("keeps the sessions that were loaded", func() {
+			_, err := c.Load(context.Background(), "a")
+			Expect(err).ToNot(HaveOccurred())
+
+			save(c, "d")
+			Expect(keysOf(c)).To(Equal([]string{"d", "a", "c"}))
+		})
+
+		It("keeps the sessions that were saved again", func() {
+			save(c, "a", "d")
+			Expect(keysOf(c)).To(Equal([]string{"d", "a", "c"}))
+		})
+
+		It("evicts the sessions beyond a lower maximum when configured", func() {
+			c.configure(options.MemoryStoreOptions{MaxSessions: 1})
+			Expect(keysOf(c)).To(Equal([]string{"c"}))
+		})
+	})
+
+	Context("when sessions expire", func() {
+		var c *cache
+		var now time.Time
+
+		BeforeEach(func() {
+			now = time.Now()
+			c = newCache(0, func() time.Time { return now })
+			save(c, "a")
+			Expect(c.Save(context.Background(), "b", []byte("value of b"), 2*time.Hour)).To(Succeed())
+		})
+
+		It("doesn't load them", func() {
+			now = now.Add(time.Hour)
+			_, err := c.Load(context.Background(), "a")
+			Expect(err).To(MatchError("session not found"))
+			Expect(keysOf(c)).To(Equal([]string{"b"}))
+
+			value, err := c.Load(context.Background(), "b")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal([]byte("value of b")))
+		})
+
+		It("reaps them", func() {
+			Expect(c.reap()).To(Equal(0))
+
+			now = now.Add(time.Hour)
+			Expect(c.reap()).To(Equal(1))
+			Expect(keysOf(c)).To(Equal([]string{"b"}))
+		})
+	})
+
+	Context("with a snapshot path", func() {
+		var opts *options.SessionOptions
+		var cookieOpts *options.CookieOptions
+		var path string
+
+		BeforeEach(func() {
+			dir, err := ioutil.TempDir("", "oauth2-proxy-memory-sessions")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "sessions.json")
+
+			opts = &options.SessionOptions{
+				Memory: options.MemoryStoreOptions{
+					MaxSessions:  10,
+					SnapshotPath: path,
+				},
+			}
+			cookieOpts = &options.CookieOptions{
+				Name:   "_oauth2_proxy",
+				Expire: time.Hour,
+				Secret: "0123456789abcdef0123456789abcdef",
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(filepath.Dir(path))).To(Succeed())
+		})
+
+		saveSession := func(ss sessionsapi.SessionStore, email string) *httptest.ResponseRecorder {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			Expect(ss.Save(rw, req, &sessionsapi.SessionState{Email: email})).To(Succeed())
+			return rw
+		}
+
+		loadSession := func(ss sessionsapi.SessionStore, rw *httptest.ResponseRecorder) (*sessionsapi.SessionState, error) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			for _, cookie := range rw.Result().Cookies() {
+				req.AddCookie(cookie)
+			}
+			return ss.Load(req)
+		}
+
+		It("keeps the sessions when the proxy restarts", func() {
+			ss, err := newStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			rw := saveSession(ss, "john.doe@example.com")
+
+			Expect(stores[0].Close()).To(Succeed())
+			Expect(path).To(BeAnExistingFile())
+
+			ss, err = newStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(path).ToNot(BeAnExistingFile())
+
+			session, err := loadSession(ss, rw)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.Email).To(Equal("john.doe@example.com"))
+		})
+
+		It("keeps the sessions when the configuration is reloaded", func() {
+			ss, err := newStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			rw := saveSession(ss, "john.doe@example.com")
+
+			reloaded, err := newStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stores[0].Close()).To(Succeed())
+			Expect(path).ToNot(BeAnExistingFile())
+
+			session, err := loadSession(reloaded, rw)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.Email).To(Equal("john.doe@example.com"))
+		})
+
+		It("doesn't load the sessions that have expired", func() {
+			ss, err := newStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			store := ss.(*SessionStore)
+			save(store.cache, "a", "b")
+			Expect(store.cache.Save(context.Background(), "c", []byte("value of c"), time.Minute)).To(Succeed())
+			Expect(store.Close()).To(Succeed())
+
+			data, err := ioutil.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			var s snapshot
+			Expect(json.Unmarshal(data, &s)).To(Succeed())
+			Expect(s.Sessions).To(HaveLen(3))
+
+			c := newCache(10, func() time.Time { return time.Now().Add(30 * time.Minute) })
+			loaded, err := c.loadSnapshot(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded).To(Equal(2))
+			Expect(keysOf(c)).To(Equal([]string{"b", "a"}))
+		})
+
+		It("loads the most recently used sessions up to the maximum", func() {
+			ss, err := newStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			store := ss.(*SessionStore)
+			save(store.cache, "a", "b", "c")
+			Expect(store.Close()).To(Succeed())
+
+			c := newCache(2, time.Now)
+			loaded, err := c.loadSnapshot(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded).To(Equal(2))
+			Expect(keysOf(c)).To(Equal([]string{"c", "b"}))
+		})
+
+		It("starts without sessions if the snapshot can't be parsed", func() {
+			Expect(ioutil.WriteFile(path, []byte("not json"), 0600)).To(Succeed())
+
+			ss, err := newStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(keysOf(ss.(*SessionStore).cache)).To(BeEmpty())
+		})
+
+		It("writes the snapshot readable only by its owner", func() {
+			_, err := newStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stores[0].Close()).To(Succeed())
+
+			info, err := os.Stat(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		})
+	})
+})
@@ -0,0 +1,264 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/cookies"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+)
+
+// Store is a key value store that a Manager keeps the encrypted sessions in
+type Store interface {
+	// Save stores the value under the key, replacing any existing value,
+	// for the given expiration
+	Save(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	// Load returns the value stored under the key, or an error if there is
+	// no value or it has expired
+	Load(ctx context.Context, key string) ([]byte, error)
+	// Clear removes the value stored under the key
+	Clear(ctx context.Context, key string) error
+}
+
+// Hooks let a session store extend how a Manager stores sessions. Each hook
+// is optional. The handle passed to the hooks is the key of the session in
+// the Store.
+type Hooks struct {
+	// CheckRevoked returns sessions.ErrSessionRevoked if the session was
+	// revoked. A revoked session isn't loaded, and a new ticket is used when
+	// it is saved again.
+	CheckRevoked func(ctx context.Context, handle string) error
+	// Expiration returns how long the session is stored for from now, rather
+	// than the cookie expiry
+	Expiration func(ctx context.Context, handle string) (time.Duration, error)
+	// Extend is called when the session is loaded, eg to extend its expiry
+	// while it is in use
+	Extend func(ctx context.Context, handle string) error
+	// Index is called once the session is stored under the ticket, eg so
+	// that it can be found without the ticket
+	Index func(ctx context.Context, s *sessions.SessionState, ticket *Ticket, expiration time.Duration) error
+	// Clear is called once the session is removed, to remove what the other
+	// hooks stored for it
+	Clear func(ctx context.Context, handle string) error
+}
+
+// Manager implements the sessions.SessionStore interface for a Store. It
+// stores sessions encrypted with the secret of a ticket, and sets the ticket
+// as the session cookie.
+type Manager struct {
+	// CookieCiphers holds a Cipher for each of the cookie secrets. Sessions
+	// are encrypted with the first.
+	CookieCiphers []encryption.Cipher
+	CookieOptions *options.CookieOptions
+	Store         Store
+	Hooks         Hooks
+}
+
+// NewManager creates a Manager for the store from the cookie configuration
+func NewManager(store Store, cookieOpts *options.CookieOptions) (*Manager, error) {
+	ciphers, err := encryption.NewBase64Ciphers(encryption.NewCFBCipher, cookieOpts.Secrets())
+	if err != nil {
+		return nil, fmt.Errorf("error initialising cipher: %v", err)
+	}
+	return &Manager{
+		CookieCiphers: ciphers,
+		CookieOptions: cookieOpts,
+		Store:         store,
+	}, nil
+}
+
+// Save encrypts the session and stores it under the ticket of the request
+// cookie, or a new ticket, and sets the ticket cookie on the response
+func (m *Manager) Save(rw http.ResponseWriter, req *http.Request, s *sessions.SessionState) error {
+	if s.CreatedAt == nil || s.CreatedAt.IsZero() {
+		now := time.Now()
+		s.CreatedAt = &now
+	}
+
+	// New sessions don't have a request cookie, so we ignore the error
+	requestCookie, _ := req.Cookie(m.CookieOptions.Name)
+	value, err := s.EncodeSessionState(m.CookieCiphers[0])
+	if err != nil {
+		return err
+	}
+
+	ctx := req.Context()
+	ticket, err := m.getTicket(ctx, requestCookie)
+	if err != nil {
+		return err
+	}
+	handle := ticket.AsHandle(m.CookieOptions.Name)
+	expiration := m.CookieOptions.Expire
+	if m.Hooks.Expiration != nil {
+		expiration, err = m.Hooks.Expiration(ctx, handle)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Sessions stored by previous versions are stored in the current format
+	// and their ticket cookie replaced
+	ticket.Version = TicketVersionGCM
+	ciphertext, err := ticket.Encrypt([]byte(value))
+	if err != nil {
+		return err
+	}
+	err = m.Store.Save(ctx, handle, ciphertext, expiration)
+	if err != nil {
+		return err
+	}
+	if m.Hooks.Index != nil {
+		if err := m.Hooks.Index(ctx, s, ticket, expiration); err != nil {
+			return fmt.Errorf("error indexing session: %v", err)
+		}
+	}
+
+	ticketCookie := m.makeCookie(
+		req,
+		ticket.EncodeTicket(m.CookieOptions.Name),
+		m.CookieOptions.Expire,
+		*s.CreatedAt,
+	)
+	http.SetCookie(rw, ticketCookie)
+	return nil
+}
+
+// getTicket returns the ticket of the request cookie, or a new ticket if there
+// is no request cookie or its session was revoked
+func (m *Manager) getTicket(ctx context.Context, requestCookie *http.Cookie) (*Ticket, error) {
+	ticket, err := GetTicket(requestCookie, m.CookieOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error getting ticket: %v", err)
+	}
+	if m.Hooks.CheckRevoked == nil {
+		return ticket, nil
+	}
+	// The ticket of a revoked session is never used again, so a user who
+	// still has its cookie gets a new ticket when they log in again
+	err = m.Hooks.CheckRevoked(ctx, ticket.AsHandle(m.CookieOptions.Name))
+	if errors.Is(err, sessions.ErrSessionRevoked) {
+		ticket, err = NewTicket()
+		if err != nil {
+			return nil, fmt.Errorf("error getting ticket: %v", err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+// Load reads the session stored under the ticket of the request cookie
+func (m *Manager) Load(req *http.Request) (*sessions.SessionState, error) {
+	requestCookie, err := req.Cookie(m.CookieOptions.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error loading session: %s", err)
+	}
+
+	// Tickets signed with a previous secret are signed with the current one,
+	// and the session encrypted with it, when the session is saved again
+	val, _, secret, ok := encryption.ValidateWithSecrets(requestCookie, m.CookieOptions.Secrets(), m.CookieOptions.Expire)
+	if !ok {
+		return nil, fmt.Errorf("cookie signature not valid")
+	}
+	session, err := m.loadSession(req.Context(), string(val), m.CookieCiphers[secret])
+	if err != nil {
+		return nil, fmt.Errorf("error loading session: %w", err)
+	}
+	return session, nil
+}
+
+// loadSession loads the session stored under the ticket, decrypting it with
+// the cipher of the secret that signed the ticket
+func (m *Manager) loadSession(ctx context.Context, value string, c encryption.Cipher) (*sessions.SessionState, error) {
+	ticket, err := DecodeTicket(m.CookieOptions.Name, value)
+	if err != nil {
+		return nil, err
+	}
+	handle := ticket.AsHandle(m.CookieOptions.Name)
+	if m.Hooks.CheckRevoked != nil {
+		if err := m.Hooks.CheckRevoked(ctx, handle); err != nil {
+			return nil, err
+		}
+	}
+	ciphertext, err := m.Store.Load(ctx, handle)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := ticket.Decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	session, err := sessions.DecodeSessionState(string(plaintext), c)
+	if err != nil {
+		return nil, err
+	}
+	if m.Hooks.Extend != nil {
+		if err := m.Hooks.Extend(ctx, handle); err != nil {
+			return nil, fmt.Errorf("error extending session: %v", err)
+		}
+	}
+	return session, nil
+}
+
+// Clear clears the session cookie and removes the session stored under its
+// ticket
+func (m *Manager) Clear(rw http.ResponseWriter, req *http.Request) error {
+	// We go ahead and clear the cookie first, always.
+	clearCookie := m.makeCookie(
+		req,
+		"",
+		time.Hour*-1,
+		time.Now(),
+	)
+	http.SetCookie(rw, clearCookie)
+
+	requestCookie, err := req.Cookie(m.CookieOptions.Name)
+	if err == http.ErrNoCookie {
+		// No existing cookie so there is no session to remove
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error retrieving cookie: %v", err)
+	}
+
+	val, _, _, ok := encryption.ValidateWithSecrets(requestCookie, m.CookieOptions.Secrets(), m.CookieOptions.Expire)
+	if !ok {
+		return fmt.Errorf("cookie signature not valid")
+	}
+
+	// We only return an error if we had an issue with the store
+	// If there's an issue decoding the ticket, ignore it
+	ticket, _ := DecodeTicket(m.CookieOptions.Name, string(val))
+	if ticket != nil {
+		handle := ticket.AsHandle(m.CookieOptions.Name)
+		err := m.Store.Clear(req.Context(), handle)
+		if err != nil {
+			return fmt.Errorf("error clearing session: %v", err)
+		}
+		if m.Hooks.Clear != nil {
+			if err := m.Hooks.Clear(req.Context(), handle); err != nil {
+				return fmt.Errorf("error clearing session: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// makeCookie makes a cookie, signing the value if present
+func (m *Manager) makeCookie(req *http.Request, value string, expires time.Duration, now time.Time) *http.Cookie {
+	if value != "" {
+		value = encryption.SignedValue(m.CookieOptions.Secret, m.CookieOptions.Name, []byte(value), now)
+	}
+	return cookies.MakeCookieFromOptions(
+		req,
+		m.CookieOptions.Name,
+		value,
+		m.CookieOptions,
+		expires,
+		now,
+	)
+}
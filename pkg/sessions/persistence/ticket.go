@@ -0,0 +1,185 @@
+package persistence
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+)
+
+// Ticket is the value of the session cookie of the session stores that keep
+// sessions on the server. It holds the ID the session is stored under and the
+// secret it is encrypted with, so that the stored session can only be read
+// with the cookie.
+type Ticket struct {
+	TicketID string
+	Secret   []byte
+	// Version is the format the session is stored in, see TicketVersionCFB
+	// and TicketVersionGCM
+	Version int
+}
+
+const (
+	// TicketVersionCFB tickets were issued by previous versions, which stored
+	// sessions encrypted with AES-CFB using the ticket secret as both the key
+	// and the IV, without an integrity check. They are still read, and are
+	// replaced by TicketVersionGCM tickets when the session is saved again.
+	TicketVersionCFB = 1
+	// TicketVersionGCM tickets store sessions encrypted with AES-GCM, using
+	// the ticket secret as the key and a random nonce
+	TicketVersionGCM = 2
+)
+
+// gcmOverhead is the length of the nonce and of the authentication tag that
+// AES-GCM adds to a value
+const gcmOverhead = 12 + 16
+
+// NewTicket creates a ticket with a random ID and secret
+func NewTicket() (*Ticket, error) {
+	rawID := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, rawID); err != nil {
+		return nil, fmt.Errorf("failed to create new ticket ID %s", err)
+	}
+	// ticketID is hex encoded
+	ticketID := hex.EncodeToString(rawID)
+
+	secret := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, fmt.Errorf("failed to create initialization vector %s", err)
+	}
+	ticket := &Ticket{
+		TicketID: ticketID,
+		Secret:   secret,
+		Version:  TicketVersionGCM,
+	}
+	return ticket, nil
+}
+
+// GetTicket retrieves an existing ticket from the request cookie if present,
+// or creates a new ticket
+func GetTicket(requestCookie *http.Cookie, cookieOpts *options.CookieOptions) (*Ticket, error) {
+	if requestCookie == nil {
+		return NewTicket()
+	}
+
+	// An existing cookie exists, try to retrieve the ticket
+	val, _, _, ok := encryption.ValidateWithSecrets(requestCookie, cookieOpts.Secrets(), cookieOpts.Expire)
+	if !ok {
+		// Cookie is invalid, create a new ticket
+		return NewTicket()
+	}
+
+	// Valid cookie, decode the ticket
+	ticket, err := DecodeTicket(cookieOpts.Name, string(val))
+	if err != nil {
+		// If we can't decode the ticket we have to create a new one
+		return NewTicket()
+	}
+	return ticket, nil
+}
+
+// DecodeTicket decodes a ticket encoded by EncodeTicket
+func DecodeTicket(cookieName string, ticketString string) (*Ticket, error) {
+	prefix := cookieName + "-"
+	if !strings.HasPrefix(ticketString, prefix) {
+		return nil, fmt.Errorf("failed to decode ticket handle")
+	}
+	trimmedTicket := strings.TrimPrefix(ticketString, prefix)
+
+	// Tickets are {ticketID}.v{version}.{secret}, or {ticketID}.{secret} for
+	// TicketVersionCFB tickets
+	ticketParts := strings.Split(trimmedTicket, ".")
+	var ticketID, secretBase64 string
+	version := TicketVersionCFB
+	switch {
+	case len(ticketParts) == 2:
+		ticketID, secretBase64 = ticketParts[0], ticketParts[1]
+	case len(ticketParts) == 3 && ticketParts[1] == fmt.Sprintf("v%d", TicketVersionGCM):
+		ticketID, secretBase64 = ticketParts[0], ticketParts[2]
+		version = TicketVersionGCM
+	default:
+		return nil, fmt.Errorf("failed to decode ticket")
+	}
+
+	// ticketID must be a hexadecimal string
+	_, err := hex.DecodeString(ticketID)
+	if err != nil {
+		return nil, fmt.Errorf("server ticket failed sanity checks")
+	}
+
+	secret, err := base64.RawURLEncoding.DecodeString(secretBase64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode initialization vector %s", err)
+	}
+	ticketData := &Ticket{
+		TicketID: ticketID,
+		Secret:   secret,
+		Version:  version,
+	}
+	return ticketData, nil
+}
+
+// EncodeTicket encodes the ticket as the value of the session cookie
+func (ticket *Ticket) EncodeTicket(prefix string) string {
+	handle := ticket.AsHandle(prefix)
+	ticketString := fmt.Sprintf("%s.v%d.%s", handle, ticket.Version, base64.RawURLEncoding.EncodeToString(ticket.Secret))
+	return ticketString
+}
+
+// AsHandle returns the key the session is stored under
+func (ticket *Ticket) AsHandle(prefix string) string {
+	return fmt.Sprintf("%s-%s", prefix, ticket.TicketID)
+}
+
+// Encrypt encrypts the session stored under the ticket with AES-GCM
+func (ticket *Ticket) Encrypt(value []byte) ([]byte, error) {
+	c, err := encryption.NewGCMCipher(ticket.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("error initiating cipher block %s", err)
+	}
+	return c.Encrypt(value)
+}
+
+// Decrypt decrypts the session stored under the ticket in the format of the
+// ticket version
+func (ticket *Ticket) Decrypt(ciphertext []byte) ([]byte, error) {
+	c, err := encryption.NewGCMCipher(ticket.Secret)
+	if err != nil {
+		return nil, err
+	}
+	validGCM := len(ciphertext) >= gcmOverhead
+	if ticket.Version == TicketVersionGCM {
+		if !validGCM {
+			return nil, errors.New("invalid session payload")
+		}
+		return c.Decrypt(ciphertext)
+	}
+
+	// A request with a CFB ticket may have been made while another request
+	// stored the session in the current format. A value authenticated by
+	// AES-GCM can only have been stored in it.
+	if validGCM {
+		if value, err := c.Decrypt(ciphertext); err == nil {
+			return value, nil
+		}
+	}
+
+	block, err := aes.NewCipher(ticket.Secret)
+	if err != nil {
+		return nil, err
+	}
+	// Use secret as the IV too, because each entry has it's own key
+	value := make([]byte, len(ciphertext))
+	stream := cipher.NewCFBDecrypter(block, ticket.Secret)
+	stream.XORKeyStream(value, ciphertext)
+	return value, nil
+}
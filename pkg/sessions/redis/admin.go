@@ -0,0 +1,161 @@
+package redis
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
+)
+
+var _ sessions.SessionAdmin = (*SessionStore)(nil)
+
+// ListSessions returns the sessions of the user with the given email, oldest
+// first
+func (store *SessionStore) ListSessions(ctx context.Context, email string) ([]*sessions.SessionInfo, error) {
+	index := store.emailIndex(email)
+	handles, err := store.Client.SMembers(ctx, index)
+	if err != nil {
+		return nil, fmt.Errorf("error loading sessions: %v", err)
+	}
+
+	infos := make([]*sessions.SessionInfo, 0, len(handles))
+	for _, handle := range handles {
+		data, err := store.Client.Get(ctx, infoKey(handle))
+		if err == redis.Nil {
+			// The session has expired, been cleared or been revoked
+			if err := store.Client.SRem(ctx, index, handle); err != nil {
+				return nil, fmt.Errorf("error updating session index: %v", err)
+			}
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error loading session info: %v", err)
+		}
+
+		info := &sessions.SessionInfo{}
+		if err := json.Unmarshal(data, info); err != nil {
+			return nil, fmt.Errorf("error decoding session info: %v", err)
+		}
+		infos = append(infos, info)
+	}
+
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].CreatedAt == nil || infos[j].CreatedAt == nil {
+			return infos[j].CreatedAt != nil
+		}
+		return infos[i].CreatedAt.Before(*infos[j].CreatedAt)
+	})
+	return infos, nil
+}
+
+// RevokeSession revokes the session with the given ID. It returns false if
+// there is no such session.
+func (store *SessionStore) RevokeSession(ctx context.Context, id string) (bool, error) {
+	// Session IDs are hex encoded ticket IDs
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return false, nil
+	}
+	handle := (&persistence.Ticket{TicketID: id}).AsHandle(store.CookieOptions.Name)
+
+	exists, err := store.Client.Exists(ctx, handle)
+	if err != nil {
+		return false, fmt.Errorf("error loading session: %v", err)
+	}
+	if !exists {
+		return false, nil
+	}
+	if err := store.revokeHandle(ctx, handle); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RevokeUserSessions revokes all sessions of the user with the given email
+// and returns the number of sessions revoked
+func (store *SessionStore) RevokeUserSessions(ctx context.Context, email string) (int, error) {
+	if email == "" {
+		return 0, errors.New("an email is required")
+	}
+	index := store.emailIndex(email)
+	handles, err := store.Client.SMembers(ctx, index)
+	if err != nil {
+		return 0, fmt.Errorf("error loading sessions: %v", err)
+	}
+	return store.revokeHandles(ctx, index, handles)
+}
+
+// revokeHandles revokes the sessions with the given handles that still exist
+// and then removes the index that listed them
+func (store *SessionStore) revokeHandles(ctx context.Context, index string, handles []string) (int, error) {
+	revoked := 0
+	for _, handle := range handles {
+		exists, err := store.Client.Exists(ctx, handle)
+		if err != nil {
+			return revoked, fmt.Errorf("error loading session: %v", err)
+		}
+		if !exists {
+			continue
+		}
+		if err := store.revokeHandle(ctx, handle); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	if err := store.Client.Del(ctx, index); err != nil {
+		return revoked, fmt.Errorf("error clearing session index: %v", err)
+	}
+	return revoked, nil
+}
+
+// revokeHandle deletes the session with the given handle and marks it as
+// revoked until its cookie would have expired, so that the session is
+// rejected by Load and isn't stored again under that ticket even if the
+// ticket cookie is presented again
+func (store *SessionStore) revokeHandle(ctx context.Context, handle string) error {
+	err := store.Client.Set(ctx, revokedKey(handle), []byte("1"), store.CookieOptions.Expire)
+	if err != nil {
+		return fmt.Errorf("error revoking session: %v", err)
+	}
+	if err := store.Client.Del(ctx, handle); err != nil {
+		return fmt.Errorf("error revoking session: %v", err)
+	}
+	if err := store.Client.Del(ctx, infoKey(handle)); err != nil {
+		return fmt.Errorf("error revoking session: %v", err)
+	}
+	return nil
+}
+
+// checkNotRevoked returns an error if the session with the given handle has
+// been revoked
+func (store *SessionStore) checkNotRevoked(ctx context.Context, handle string) error {
+	revoked, err := store.Client.Exists(ctx, revokedKey(handle))
+	if err != nil {
+		return fmt.Errorf("error checking session revocation: %v", err)
+	}
+	if revoked {
+		return sessions.ErrSessionRevoked
+	}
+	return nil
+}
+
+func (store *SessionStore) emailIndex(email string) string {
+	return fmt.Sprintf("%s-email-%s", store.CookieOptions.Name, strings.ToLower(email))
+}
+
+func infoKey(handle string) string {
+	return handle + "-info"
+}
+
+func revokedKey(handle string) string {
+	return handle + "-revoked"
+}
+
+func lifetimeKey(handle string) string {
+	return handle + "-lifetime"
+}
@@ -12,6 +12,17 @@ type Client interface {
 	Get(ctx context.Context, key string) ([]byte, error)
 	Set(ctx context.Context, key string, value []byte, expiration time.Duration) error
 	Del(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	SAdd(ctx context.Context, key string, member string, expiration time.Duration) error
+	SRem(ctx context.Context, key string, member string) error
+	SMembers(ctx context.Context, key string) ([]string, error)
+	Expire(ctx context.Context, key string, expiration time.Duration) error
+	// TTL returns the remaining time to live of the key, or a negative
+	// duration if it doesn't exist or doesn't expire
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	Ping(ctx context.Context) error
+	// Close closes the connections to redis
+	Close() error
 }
 
 var _ Client = (*client)(nil)
@@ -36,6 +47,39 @@ func (c *client) Del(ctx context.Context, key string) error {
 	return c.WithContext(ctx).Del(key).Err()
 }
 
+func (c *client) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := c.WithContext(ctx).Exists(key).Result()
+	return n > 0, err
+}
+
+func (c *client) SAdd(ctx context.Context, key string, member string, expiration time.Duration) error {
+	pipe := c.WithContext(ctx).TxPipeline()
+	pipe.SAdd(key, member)
+	pipe.Expire(key, expiration)
+	_, err := pipe.Exec()
+	return err
+}
+
+func (c *client) SRem(ctx context.Context, key string, member string) error {
+	return c.WithContext(ctx).SRem(key, member).Err()
+}
+
+func (c *client) SMembers(ctx context.Context, key string) ([]string, error) {
+	return c.WithContext(ctx).SMembers(key).Result()
+}
+
+func (c *client) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return c.WithContext(ctx).Expire(key, expiration).Err()
+}
+
+func (c *client) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.WithContext(ctx).PTTL(key).Result()
+}
+
+func (c *client) Ping(ctx context.Context) error {
+	return c.WithContext(ctx).Ping().Err()
+}
+
 var _ Client = (*clusterClient)(nil)
 
 type clusterClient struct {
@@ -57,3 +101,36 @@ func (c *clusterClient) Set(ctx context.Context, key string, value []byte, expir
 func (c *clusterClient) Del(ctx context.Context, key string) error {
 	return c.WithContext(ctx).Del(key).Err()
 }
+
+func (c *clusterClient) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := c.WithContext(ctx).Exists(key).Result()
+	return n > 0, err
+}
+
+func (c *clusterClient) SAdd(ctx context.Context, key string, member string, expiration time.Duration) error {
+	pipe := c.WithContext(ctx).TxPipeline()
+	pipe.SAdd(key, member)
+	pipe.Expire(key, expiration)
+	_, err := pipe.Exec()
+	return err
+}
+
+func (c *clusterClient) SRem(ctx context.Context, key string, member string) error {
+	return c.WithContext(ctx).SRem(key, member).Err()
+}
+
+func (c *clusterClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	return c.WithContext(ctx).SMembers(key).Result()
+}
+
+func (c *clusterClient) Expire(ctx context.Context, key string, expiration time.Duration) error {
+	return c.WithContext(ctx).Expire(key, expiration).Err()
+}
+
+func (c *clusterClient) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.WithContext(ctx).PTTL(key).Result()
+}
+
+func (c *clusterClient) Ping(ctx context.Context) error {
+	return c.WithContext(ctx).Ping().Err()
+}
@@ -2,61 +2,86 @@ package redis
 
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"net/http"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v7"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
-	"github.com/oauth2-proxy/oauth2-proxy/pkg/cookies"
-	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
 )
 
-// TicketData is a structure representing the ticket used in server session storage
-type TicketData struct {
-	TicketID string
-	Secret   []byte
-}
+var _ sessions.SessionRevoker = (*SessionStore)(nil)
 
 // SessionStore is an implementation of the sessions.SessionStore
-// interface that stores sessions in redis
+// interface that stores sessions in redis. Its hooks on the
+// persistence.Manager index the sessions for the admin API, reject revoked
+// sessions and bound how long sessions are stored.
 type SessionStore struct {
-	CookieCipher  encryption.Cipher
-	CookieOptions *options.CookieOptions
-	Client        Client
+	*persistence.Manager
+
+	Client Client
+
+	// IdleTimeout and MaxLifetime bound how long sessions are stored, see
+	// options.SessionOptions. They are disabled when 0.
+	IdleTimeout time.Duration
+	MaxLifetime time.Duration
 }
 
+// idleTimeoutExtensions limits how often the expiry of a session is extended
+// when it is used: at most once per this fraction of the idle timeout
+const idleTimeoutExtensions = 10
+
 // NewRedisSessionStore initialises a new instance of the SessionStore from
 // the configuration given
 func NewRedisSessionStore(opts *options.SessionOptions, cookieOpts *options.CookieOptions) (sessions.SessionStore, error) {
-	cipher, err := encryption.NewBase64Cipher(encryption.NewCFBCipher, encryption.SecretBytes(cookieOpts.Secret))
-	if err != nil {
-		return nil, fmt.Errorf("error initialising cipher: %v", err)
-	}
-
 	client, err := newRedisCmdable(opts.Redis)
 	if err != nil {
 		return nil, fmt.Errorf("error constructing redis client: %v", err)
 	}
 
+	manager, err := persistence.NewManager(&clientStore{client: client}, cookieOpts)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
 	rs := &SessionStore{
-		Client:        client,
-		CookieCipher:  cipher,
-		CookieOptions: cookieOpts,
+		Manager:     manager,
+		Client:      client,
+		IdleTimeout: opts.IdleTimeout,
+		MaxLifetime: opts.MaxLifetime,
+	}
+	manager.Hooks = persistence.Hooks{
+		CheckRevoked: rs.checkNotRevoked,
+		Expiration:   rs.expiration,
+		Extend:       rs.extendSession,
+		Index:        rs.indexSession,
+		Clear:        rs.clearSessionData,
 	}
 	return rs, nil
+}
+
+// clientStore stores the encrypted sessions of a persistence.Manager in redis
+type clientStore struct {
+	client Client
+}
+
+func (c *clientStore) Save(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	return c.client.Set(ctx, key, value, expiration)
+}
 
+func (c *clientStore) Load(ctx context.Context, key string) ([]byte, error) {
+	return c.client.Get(ctx, key)
+}
+
+func (c *clientStore) Clear(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key)
 }
 
 func newRedisCmdable(opts options.RedisStoreOptions) (Client, error) {
@@ -135,241 +160,173 @@ func parseRedisURLs(urls []string) ([]string, error) {
 	return addrs, nil
 }
 
-// Save takes a sessions.SessionState and stores the information from it
-// to redies, and adds a new ticket cookie on the HTTP response writer
-func (store *SessionStore) Save(rw http.ResponseWriter, req *http.Request, s *sessions.SessionState) error {
-	if s.CreatedAt == nil || s.CreatedAt.IsZero() {
-		now := time.Now()
-		s.CreatedAt = &now
+// extendSession extends the expiry of the session with the given handle by
+// the idle timeout when it is used. To limit the writes to redis the expiry
+// is only extended once a fraction of the idle timeout has passed since it
+// was last extended.
+func (store *SessionStore) extendSession(ctx context.Context, handle string) error {
+	if store.IdleTimeout <= 0 {
+		return nil
 	}
-
-	// Old sessions that we are refreshing would have a request cookie
-	// New sessions don't, so we ignore the error. storeValue will check requestCookie
-	requestCookie, _ := req.Cookie(store.CookieOptions.Name)
-	value, err := s.EncodeSessionState(store.CookieCipher)
+	ttl, err := store.Client.TTL(ctx, handle)
 	if err != nil {
 		return err
 	}
-	ctx := req.Context()
-	ticketString, err := store.storeValue(ctx, value, store.CookieOptions.Expire, requestCookie)
-	if err != nil {
-		return err
+	if ttl < 0 {
+		// The session has just expired or was stored without an expiry
+		return nil
 	}
-
-	ticketCookie := store.makeCookie(
-		req,
-		ticketString,
-		store.CookieOptions.Expire,
-		*s.CreatedAt,
-	)
-
-	http.SetCookie(rw, ticketCookie)
-	return nil
-}
-
-// Load reads sessions.SessionState information from a ticket
-// cookie within the HTTP request object
-func (store *SessionStore) Load(req *http.Request) (*sessions.SessionState, error) {
-	requestCookie, err := req.Cookie(store.CookieOptions.Name)
+	expiration, err := store.expiration(ctx, handle)
 	if err != nil {
-		return nil, fmt.Errorf("error loading session: %s", err)
+		return err
 	}
-
-	val, _, ok := encryption.Validate(requestCookie, store.CookieOptions.Secret, store.CookieOptions.Expire)
-	if !ok {
-		return nil, fmt.Errorf("cookie signature not valid")
+	if expiration-ttl < store.IdleTimeout/idleTimeoutExtensions {
+		return nil
 	}
-	ctx := req.Context()
-	session, err := store.loadSessionFromString(ctx, string(val))
-	if err != nil {
-		return nil, fmt.Errorf("error loading session: %s", err)
+	if err := store.Client.Expire(ctx, handle, expiration); err != nil {
+		return err
 	}
-	return session, nil
+	return store.Client.Expire(ctx, infoKey(handle), expiration)
 }
 
-// loadSessionFromString loads the session based on the ticket value
-func (store *SessionStore) loadSessionFromString(ctx context.Context, value string) (*sessions.SessionState, error) {
-	ticket, err := decodeTicket(store.CookieOptions.Name, value)
-	if err != nil {
-		return nil, err
-	}
-
-	resultBytes, err := store.Client.Get(ctx, ticket.asHandle(store.CookieOptions.Name))
-	if err != nil {
-		return nil, err
+// expiration returns how long the session with the given handle is stored
+// for from now: the idle timeout, or the cookie expiry if there is no idle
+// timeout or it is longer, bounded by the remainder of the session lifetime.
+// The lifetime of a session starts when it is first stored.
+func (store *SessionStore) expiration(ctx context.Context, handle string) (time.Duration, error) {
+	expiration := store.CookieOptions.Expire
+	if store.IdleTimeout > 0 && store.IdleTimeout < expiration {
+		expiration = store.IdleTimeout
 	}
-
-	block, err := aes.NewCipher(ticket.Secret)
-	if err != nil {
-		return nil, err
+	if store.MaxLifetime <= 0 {
+		return expiration, nil
 	}
-	// Use secret as the IV too, because each entry has it's own key
-	stream := cipher.NewCFBDecrypter(block, ticket.Secret)
-	stream.XORKeyStream(resultBytes, resultBytes)
 
-	session, err := sessions.DecodeSessionState(string(resultBytes), store.CookieCipher)
+	// The lifetime key expires at the end of the session lifetime
+	remaining, err := store.Client.TTL(ctx, lifetimeKey(handle))
 	if err != nil {
-		return nil, err
-	}
-	return session, nil
-}
-
-// Clear clears any saved session information for a given ticket cookie
-// from redis, and then clears the session
-func (store *SessionStore) Clear(rw http.ResponseWriter, req *http.Request) error {
-	// We go ahead and clear the cookie first, always.
-	clearCookie := store.makeCookie(
-		req,
-		"",
-		time.Hour*-1,
-		time.Now(),
-	)
-	http.SetCookie(rw, clearCookie)
-
-	// If there was an existing cookie we should clear the session in redis
-	requestCookie, err := req.Cookie(store.CookieOptions.Name)
-	if err != nil && err == http.ErrNoCookie {
-		// No existing cookie so can't clear redis
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("error retrieving cookie: %v", err)
-	}
-
-	val, _, ok := encryption.Validate(requestCookie, store.CookieOptions.Secret, store.CookieOptions.Expire)
-	if !ok {
-		return fmt.Errorf("cookie signature not valid")
+		return 0, fmt.Errorf("error loading session lifetime: %v", err)
 	}
-
-	// We only return an error if we had an issue with redis
-	// If there's an issue decoding the ticket, ignore it
-	ticket, _ := decodeTicket(store.CookieOptions.Name, string(val))
-	if ticket != nil {
-		ctx := req.Context()
-		err := store.Client.Del(ctx, ticket.asHandle(store.CookieOptions.Name))
+	if remaining <= 0 {
+		remaining = store.MaxLifetime
+		err := store.Client.Set(ctx, lifetimeKey(handle), []byte("1"), remaining)
 		if err != nil {
-			return fmt.Errorf("error clearing cookie from redis: %s", err)
+			return 0, fmt.Errorf("error storing session lifetime: %v", err)
 		}
 	}
-	return nil
+	if remaining < expiration {
+		expiration = remaining
+	}
+	return expiration, nil
 }
 
-// makeCookie makes a cookie, signing the value if present
-func (store *SessionStore) makeCookie(req *http.Request, value string, expires time.Duration, now time.Time) *http.Cookie {
-	if value != "" {
-		value = encryption.SignedValue(store.CookieOptions.Secret, store.CookieOptions.Name, []byte(value), now)
-	}
-	return cookies.MakeCookieFromOptions(
-		req,
-		store.CookieOptions.Name,
-		value,
-		store.CookieOptions,
-		expires,
-		now,
-	)
+// clearSessionData removes the session info and lifetime stored with the
+// session with the given handle when the session is cleared
+func (store *SessionStore) clearSessionData(ctx context.Context, handle string) error {
+	if err := store.Client.Del(ctx, infoKey(handle)); err != nil {
+		return err
+	}
+	return store.Client.Del(ctx, lifetimeKey(handle))
 }
 
-func (store *SessionStore) storeValue(ctx context.Context, value string, expiration time.Duration, requestCookie *http.Cookie) (string, error) {
-	ticket, err := store.getTicket(requestCookie)
+// indexSession records the ticket handle against the email of the session
+// and the subject and session ID of its ID token, so that the session can be
+// listed and revoked without the ticket cookie. The session info is stored
+// for as long as the session.
+func (store *SessionStore) indexSession(ctx context.Context, s *sessions.SessionState, ticket *persistence.Ticket, expiration time.Duration) error {
+	handle := ticket.AsHandle(store.CookieOptions.Name)
+	info, err := json.Marshal(&sessions.SessionInfo{
+		ID:         ticket.TicketID,
+		Email:      s.Email,
+		User:       s.User,
+		ProviderID: s.ProviderID,
+		CreatedAt:  s.CreatedAt,
+		ExpiresOn:  s.ExpiresOn,
+	})
 	if err != nil {
-		return "", fmt.Errorf("error getting ticket: %v", err)
+		return err
 	}
-
-	ciphertext := make([]byte, len(value))
-	block, err := aes.NewCipher(ticket.Secret)
+	err = store.Client.Set(ctx, infoKey(handle), info, expiration)
 	if err != nil {
-		return "", fmt.Errorf("error initiating cipher block %s", err)
+		return err
 	}
-
-	// Use secret as the Initialization Vector too, because each entry has it's own key
-	stream := cipher.NewCFBEncrypter(block, ticket.Secret)
-	stream.XORKeyStream(ciphertext, []byte(value))
-
-	handle := ticket.asHandle(store.CookieOptions.Name)
-	err = store.Client.Set(ctx, handle, ciphertext, expiration)
-	if err != nil {
-		return "", err
+	if s.Email != "" {
+		err := store.Client.SAdd(ctx, store.emailIndex(s.Email), handle, store.CookieOptions.Expire)
+		if err != nil {
+			return err
+		}
 	}
-	return ticket.encodeTicket(store.CookieOptions.Name), nil
-}
 
-// getTicket retrieves an existing ticket from the cookie if present,
-// or creates a new ticket
-func (store *SessionStore) getTicket(requestCookie *http.Cookie) (*TicketData, error) {
-	if requestCookie == nil {
-		return newTicket()
+	subject, sessionID := idTokenSubjectAndSessionID(s.IDToken)
+	if subject != "" {
+		err := store.Client.SAdd(ctx, store.subjectIndex(subject), handle, store.CookieOptions.Expire)
+		if err != nil {
+			return err
+		}
+	}
+	if sessionID != "" {
+		err := store.Client.SAdd(ctx, store.sessionIDIndex(sessionID), handle, store.CookieOptions.Expire)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	// An existing cookie exists, try to retrieve the ticket
-	val, _, ok := encryption.Validate(requestCookie, store.CookieOptions.Secret, store.CookieOptions.Expire)
-	if !ok {
-		// Cookie is invalid, create a new ticket
-		return newTicket()
+// RevokeSessions removes the sessions created from ID tokens with the given
+// session ID or, when the session ID is empty, the given subject
+func (store *SessionStore) RevokeSessions(ctx context.Context, subject, sessionID string) (int, error) {
+	index := store.subjectIndex(subject)
+	if sessionID != "" {
+		index = store.sessionIDIndex(sessionID)
+	} else if subject == "" {
+		return 0, fmt.Errorf("a subject or session ID is required")
 	}
 
-	// Valid cookie, decode the ticket
-	ticket, err := decodeTicket(store.CookieOptions.Name, string(val))
+	handles, err := store.Client.SMembers(ctx, index)
 	if err != nil {
-		// If we can't decode the ticket we have to create a new one
-		return newTicket()
+		return 0, fmt.Errorf("error loading sessions: %v", err)
 	}
-	return ticket, nil
+	return store.revokeHandles(ctx, index, handles)
 }
 
-func newTicket() (*TicketData, error) {
-	rawID := make([]byte, 16)
-	if _, err := io.ReadFull(rand.Reader, rawID); err != nil {
-		return nil, fmt.Errorf("failed to create new ticket ID %s", err)
-	}
-	// ticketID is hex encoded
-	ticketID := hex.EncodeToString(rawID)
+// CheckHealth checks that redis can be reached, for the readiness checks
+func (store *SessionStore) CheckHealth(ctx context.Context) error {
+	return store.Client.Ping(ctx)
+}
 
-	secret := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
-		return nil, fmt.Errorf("failed to create initialization vector %s", err)
-	}
-	ticket := &TicketData{
-		TicketID: ticketID,
-		Secret:   secret,
-	}
-	return ticket, nil
+// Close closes the redis client once the session store is no longer used,
+// eg after the configuration was reloaded
+func (store *SessionStore) Close() error {
+	return store.Client.Close()
 }
 
-func (ticket *TicketData) asHandle(prefix string) string {
-	return fmt.Sprintf("%s-%s", prefix, ticket.TicketID)
+func (store *SessionStore) subjectIndex(subject string) string {
+	return fmt.Sprintf("%s-sub-%s", store.CookieOptions.Name, subject)
 }
 
-func decodeTicket(cookieName string, ticketString string) (*TicketData, error) {
-	prefix := cookieName + "-"
-	if !strings.HasPrefix(ticketString, prefix) {
-		return nil, fmt.Errorf("failed to decode ticket handle")
-	}
-	trimmedTicket := strings.TrimPrefix(ticketString, prefix)
+func (store *SessionStore) sessionIDIndex(sessionID string) string {
+	return fmt.Sprintf("%s-sid-%s", store.CookieOptions.Name, sessionID)
+}
 
-	ticketParts := strings.Split(trimmedTicket, ".")
-	if len(ticketParts) != 2 {
-		return nil, fmt.Errorf("failed to decode ticket")
+// idTokenSubjectAndSessionID reads the sub and sid claims from the ID token.
+// The ID token was verified when the session was created so the signature is
+// not checked again.
+func idTokenSubjectAndSessionID(idToken string) (string, string) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return "", ""
 	}
-	ticketID, secretBase64 := ticketParts[0], ticketParts[1]
-
-	// ticketID must be a hexadecimal string
-	_, err := hex.DecodeString(ticketID)
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
 	if err != nil {
-		return nil, fmt.Errorf("server ticket failed sanity checks")
+		return "", ""
 	}
-
-	secret, err := base64.RawURLEncoding.DecodeString(secretBase64)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode initialization vector %s", err)
+	var claims struct {
+		Subject   string `json:"sub"`
+		SessionID string `json:"sid"`
 	}
-	ticketData := &TicketData{
-		TicketID: ticketID,
-		Secret:   secret,
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", ""
 	}
-	return ticketData, nil
-}
-
-func (ticket *TicketData) encodeTicket(prefix string) string {
-	handle := ticket.asHandle(prefix)
-	ticketString := handle + "." + base64.RawURLEncoding.EncodeToString(ticket.Secret)
-	return ticketString
+	return claims.Subject, claims.SessionID
 }
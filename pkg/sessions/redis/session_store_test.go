@@ -1,8 +1,16 @@
 package redis
 
 import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,7 +19,10 @@ import (
 	"github.com/go-redis/redis/v7"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/cookies"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -74,6 +85,422 @@ var _ = Describe("Redis SessionStore Tests", func() {
 		},
 	)
 
+	Context("when RevokeSessions is called", func() {
+		var store *SessionStore
+		var cookies []*http.Cookie
+
+		idToken := func(claims string) string {
+			return "header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+		}
+
+		BeforeEach(func() {
+			opts := &options.SessionOptions{
+				Type:  options.RedisSessionStoreType,
+				Redis: options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()},
+			}
+			cookieOpts := &options.CookieOptions{
+				Name:   "_oauth2_proxy",
+				Expire: time.Hour,
+				Secret: "0123456789abcdef0123456789abcdef",
+			}
+			var err error
+			ss, err = NewRedisSessionStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			store = ss.(*SessionStore)
+
+			cookies = nil
+			for _, claims := range []string{
+				`{"sub":"user1","sid":"session1"}`,
+				`{"sub":"user1","sid":"session2"}`,
+				`{"sub":"user2","sid":"session3"}`,
+			} {
+				rw := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				err := ss.Save(rw, req, &sessionsapi.SessionState{Email: "john.doe@example.com", IDToken: idToken(claims)})
+				Expect(err).ToNot(HaveOccurred())
+				cookies = append(cookies, rw.Result().Cookies()[0])
+			}
+		})
+
+		loaded := func() []bool {
+			var result []bool
+			for _, c := range cookies {
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				req.AddCookie(c)
+				_, err := ss.Load(req)
+				result = append(result, err == nil)
+			}
+			return result
+		}
+
+		It("revokes the sessions with the session ID", func() {
+			revoked, err := store.RevokeSessions(context.Background(), "user1", "session2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revoked).To(Equal(1))
+			Expect(loaded()).To(Equal([]bool{true, false, true}))
+		})
+
+		It("revokes the sessions of the subject without a session ID", func() {
+			revoked, err := store.RevokeSessions(context.Background(), "user1", "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revoked).To(Equal(2))
+			Expect(loaded()).To(Equal([]bool{false, false, true}))
+		})
+
+		It("returns an error without a subject or session ID", func() {
+			_, err := store.RevokeSessions(context.Background(), "", "")
+			Expect(err).To(MatchError("a subject or session ID is required"))
+			Expect(loaded()).To(Equal([]bool{true, true, true}))
+		})
+	})
+
+	Context("with an idle timeout and a maximum lifetime", func() {
+		var req *http.Request
+
+		load := func() error {
+			_, err := ss.Load(req)
+			return err
+		}
+
+		BeforeEach(func() {
+			opts := &options.SessionOptions{
+				Type:        options.RedisSessionStoreType,
+				Redis:       options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()},
+				IdleTimeout: time.Hour,
+				MaxLifetime: 3 * time.Hour,
+			}
+			cookieOpts := &options.CookieOptions{
+				Name:   "_oauth2_proxy",
+				Expire: 24 * time.Hour,
+				Secret: "0123456789abcdef0123456789abcdef",
+			}
+			var err error
+			ss, err = NewRedisSessionStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+
+			rw := httptest.NewRecorder()
+			req = httptest.NewRequest("GET", "http://example.com/", nil)
+			Expect(ss.Save(rw, req, &sessionsapi.SessionState{Email: "john.doe@example.com"})).To(Succeed())
+			req.AddCookie(rw.Result().Cookies()[0])
+		})
+
+		It("removes sessions that are not used for the idle timeout", func() {
+			mr.FastForward(61 * time.Minute)
+			Expect(load()).ToNot(Succeed())
+		})
+
+		It("extends the expiry of sessions when they are used", func() {
+			mr.FastForward(50 * time.Minute)
+			Expect(load()).To(Succeed())
+			mr.FastForward(50 * time.Minute)
+			Expect(load()).To(Succeed())
+		})
+
+		It("only extends the expiry once a tenth of the idle timeout has passed", func() {
+			handle := ss.(*SessionStore).CookieOptions.Name + "-"
+			var sessionKey string
+			for _, key := range mr.Keys() {
+				if strings.HasPrefix(key, handle) && !strings.Contains(strings.TrimPrefix(key, handle), "-") {
+					sessionKey = key
+				}
+			}
+			Expect(sessionKey).ToNot(BeEmpty())
+
+			mr.FastForward(3 * time.Minute)
+			Expect(load()).To(Succeed())
+			Expect(mr.TTL(sessionKey)).To(Equal(57 * time.Minute))
+
+			mr.FastForward(3 * time.Minute)
+			Expect(load()).To(Succeed())
+			Expect(mr.TTL(sessionKey)).To(Equal(time.Hour))
+		})
+
+		It("removes sessions after the maximum lifetime even if they are used", func() {
+			for i := 0; i < 3; i++ {
+				mr.FastForward(50 * time.Minute)
+				Expect(load()).To(Succeed())
+			}
+			mr.FastForward(31 * time.Minute)
+			Expect(load()).ToNot(Succeed())
+		})
+
+		It("does not extend the maximum lifetime when the session is saved again", func() {
+			for i := 0; i < 3; i++ {
+				mr.FastForward(50 * time.Minute)
+				Expect(load()).To(Succeed())
+			}
+			rw := httptest.NewRecorder()
+			Expect(ss.Save(rw, req, &sessionsapi.SessionState{Email: "john.doe@example.com"})).To(Succeed())
+
+			mr.FastForward(31 * time.Minute)
+			Expect(load()).ToNot(Succeed())
+		})
+	})
+
+	Context("with sessions stored by previous versions", func() {
+		var store *SessionStore
+		var legacyCookie *http.Cookie
+
+		const secret = "0123456789abcdef0123456789abcdef"
+
+		ticketOf := func(c *http.Cookie) *persistence.Ticket {
+			val, _, ok := encryption.Validate(c, secret, time.Hour)
+			Expect(ok).To(BeTrue())
+			ticket, err := persistence.DecodeTicket("_oauth2_proxy", string(val))
+			Expect(err).ToNot(HaveOccurred())
+			return ticket
+		}
+
+		load := func(c *http.Cookie) (*sessionsapi.SessionState, error) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.AddCookie(c)
+			return ss.Load(req)
+		}
+
+		BeforeEach(func() {
+			opts := &options.SessionOptions{
+				Type:  options.RedisSessionStoreType,
+				Redis: options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()},
+			}
+			cookieOpts := &options.CookieOptions{
+				Name:   "_oauth2_proxy",
+				Expire: time.Hour,
+				Secret: secret,
+			}
+			var err error
+			ss, err = NewRedisSessionStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			store = ss.(*SessionStore)
+
+			// Previous versions encrypted sessions with AES-CFB, using the
+			// ticket secret as the IV, and didn't version their tickets
+			value, err := (&sessionsapi.SessionState{Email: "john.doe@example.com"}).EncodeSessionState(store.CookieCiphers[0])
+			Expect(err).ToNot(HaveOccurred())
+			ticket, err := persistence.NewTicket()
+			Expect(err).ToNot(HaveOccurred())
+			block, err := aes.NewCipher(ticket.Secret)
+			Expect(err).ToNot(HaveOccurred())
+			ciphertext := make([]byte, len(value))
+			cipher.NewCFBEncrypter(block, ticket.Secret).XORKeyStream(ciphertext, []byte(value))
+
+			handle := ticket.AsHandle("_oauth2_proxy")
+			Expect(store.Client.Set(context.Background(), handle, ciphertext, time.Hour)).To(Succeed())
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			now := time.Now()
+			value = encryption.SignedValue(secret, "_oauth2_proxy", []byte(handle+"."+base64.RawURLEncoding.EncodeToString(ticket.Secret)), now)
+			legacyCookie = cookies.MakeCookieFromOptions(req, "_oauth2_proxy", value, cookieOpts, time.Hour, now)
+		})
+
+		It("loads them", func() {
+			Expect(ticketOf(legacyCookie).Version).To(Equal(persistence.TicketVersionCFB))
+			session, err := load(legacyCookie)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.Email).To(Equal("john.doe@example.com"))
+		})
+
+		It("stores them with AES-GCM when they are saved again", func() {
+			session, err := load(legacyCookie)
+			Expect(err).ToNot(HaveOccurred())
+
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.AddCookie(legacyCookie)
+			rw := httptest.NewRecorder()
+			Expect(ss.Save(rw, req, session)).To(Succeed())
+			cookie := rw.Result().Cookies()[0]
+
+			ticket := ticketOf(cookie)
+			Expect(ticket.Version).To(Equal(persistence.TicketVersionGCM))
+			Expect(ticket.TicketID).To(Equal(ticketOf(legacyCookie).TicketID))
+
+			payload, err := store.Client.Get(context.Background(), ticket.AsHandle("_oauth2_proxy"))
+			Expect(err).ToNot(HaveOccurred())
+			c, err := encryption.NewGCMCipher(ticket.Secret)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = c.Decrypt(payload)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = load(cookie)
+			Expect(err).ToNot(HaveOccurred())
+			// Requests made with the previous cookie meanwhile still load it
+			_, err = load(legacyCookie)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("does not load sessions stored with AES-GCM that have been modified", func() {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			Expect(ss.Save(rw, req, &sessionsapi.SessionState{Email: "john.doe@example.com"})).To(Succeed())
+			cookie := rw.Result().Cookies()[0]
+
+			handle := ticketOf(cookie).AsHandle("_oauth2_proxy")
+			payload, err := store.Client.Get(context.Background(), handle)
+			Expect(err).ToNot(HaveOccurred())
+			payload[len(payload)-1] ^= 1
+			Expect(store.Client.Set(context.Background(), handle, payload, time.Hour)).To(Succeed())
+
+			_, err = load(cookie)
+			Expect(err).To(MatchError("error loading session: cipher: message authentication failed"))
+		})
+	})
+
+	Context("when CheckHealth is called", func() {
+		var store *SessionStore
+
+		BeforeEach(func() {
+			opts := &options.SessionOptions{
+				Type:  options.RedisSessionStoreType,
+				Redis: options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()},
+			}
+			cookieOpts := &options.CookieOptions{
+				Name:   "_oauth2_proxy",
+				Expire: time.Hour,
+				Secret: "0123456789abcdef0123456789abcdef",
+			}
+			var err error
+			ss, err = NewRedisSessionStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			store = ss.(*SessionStore)
+		})
+
+		It("succeeds when redis can be reached", func() {
+			Expect(store.CheckHealth(context.Background())).To(Succeed())
+		})
+
+		It("fails when redis can't be reached", func() {
+			mr.Close()
+			Expect(store.CheckHealth(context.Background())).ToNot(Succeed())
+		})
+
+		It("fails once the session store is closed", func() {
+			Expect(store.Close()).To(Succeed())
+			Expect(store.CheckHealth(context.Background())).To(MatchError("redis: client is closed"))
+			// The client is already closed
+			ss = nil
+		})
+	})
+
+	Context("when the session admin API is used", func() {
+		var store *SessionStore
+		var cookies []*http.Cookie
+
+		BeforeEach(func() {
+			opts := &options.SessionOptions{
+				Type:  options.RedisSessionStoreType,
+				Redis: options.RedisStoreOptions{ConnectionURL: "redis://" + mr.Addr()},
+			}
+			cookieOpts := &options.CookieOptions{
+				Name:   "_oauth2_proxy",
+				Expire: time.Hour,
+				Secret: "0123456789abcdef0123456789abcdef",
+			}
+			var err error
+			ss, err = NewRedisSessionStore(opts, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			store = ss.(*SessionStore)
+
+			cookies = nil
+			for _, email := range []string{"alice@example.com", "Alice@example.com", "bob@example.com"} {
+				rw := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				err := ss.Save(rw, req, &sessionsapi.SessionState{Email: email, ProviderID: "github"})
+				Expect(err).ToNot(HaveOccurred())
+				cookies = append(cookies, rw.Result().Cookies()[0])
+			}
+		})
+
+		loaded := func() []bool {
+			var result []bool
+			for _, c := range cookies {
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				req.AddCookie(c)
+				_, err := ss.Load(req)
+				result = append(result, err == nil)
+			}
+			return result
+		}
+
+		It("lists the sessions of a user", func() {
+			infos, err := store.ListSessions(context.Background(), "alice@example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos).To(HaveLen(2))
+			for _, info := range infos {
+				Expect(info.ID).ToNot(BeEmpty())
+				Expect(info.ProviderID).To(Equal("github"))
+				Expect(info.CreatedAt).ToNot(BeNil())
+			}
+		})
+
+		It("does not list cleared sessions", func() {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.AddCookie(cookies[0])
+			Expect(ss.Clear(httptest.NewRecorder(), req)).To(Succeed())
+
+			infos, err := store.ListSessions(context.Background(), "alice@example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos).To(HaveLen(1))
+		})
+
+		It("revokes a single session", func() {
+			infos, err := store.ListSessions(context.Background(), "bob@example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos).To(HaveLen(1))
+
+			revoked, err := store.RevokeSession(context.Background(), infos[0].ID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revoked).To(BeTrue())
+			Expect(loaded()).To(Equal([]bool{true, true, false}))
+
+			revoked, err = store.RevokeSession(context.Background(), infos[0].ID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revoked).To(BeFalse())
+		})
+
+		It("revokes all sessions of a user", func() {
+			revoked, err := store.RevokeUserSessions(context.Background(), "alice@example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revoked).To(Equal(2))
+			Expect(loaded()).To(Equal([]bool{false, false, true}))
+
+			infos, err := store.ListSessions(context.Background(), "alice@example.com")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(infos).To(BeEmpty())
+		})
+
+		It("saves a new session under a new ticket after revocation", func() {
+			_, err := store.RevokeUserSessions(context.Background(), "bob@example.com")
+			Expect(err).ToNot(HaveOccurred())
+
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.AddCookie(cookies[2])
+			_, err = ss.Load(req)
+			Expect(errors.Is(err, sessionsapi.ErrSessionRevoked)).To(BeTrue())
+
+			rw := httptest.NewRecorder()
+			err = ss.Save(rw, req, &sessionsapi.SessionState{Email: "bob@example.com"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded()).To(Equal([]bool{true, true, false}))
+
+			newCookie := rw.Result().Cookies()[0]
+			Expect(newCookie.Value).ToNot(Equal(cookies[2].Value))
+			req = httptest.NewRequest("GET", "http://example.com/", nil)
+			req.AddCookie(newCookie)
+			session, err := ss.Load(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(session.Email).To(Equal("bob@example.com"))
+		})
+
+		It("does not revoke unknown sessions", func() {
+			revoked, err := store.RevokeSession(context.Background(), "0123456789abcdef")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revoked).To(BeFalse())
+
+			revoked, err = store.RevokeSession(context.Background(), "not-a-ticket")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(revoked).To(BeFalse())
+			Expect(loaded()).To(Equal([]bool{true, true, true}))
+		})
+	})
+
 	Context("with sentinel", func() {
 		var ms *minisentinel.Sentinel
 
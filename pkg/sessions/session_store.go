@@ -2,21 +2,69 @@ package sessions
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/cookie"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/memory"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/redis"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/sql"
 )
 
+// NewSessionStoreFunc creates a SessionStore of a registered type from the
+// provided configuration
+type NewSessionStoreFunc func(opts *options.SessionOptions, cookieOpts *options.CookieOptions) (sessions.SessionStore, error)
+
+var (
+	storeTypesMutex sync.RWMutex
+	storeTypes      = make(map[string]NewSessionStoreFunc)
+)
+
+func init() {
+	Register(options.CookieSessionStoreType, cookie.NewCookieSessionStore)
+	Register(options.RedisSessionStoreType, redis.NewRedisSessionStore)
+	Register(options.SQLSessionStoreType, sql.NewSQLSessionStore)
+	Register(options.MemorySessionStoreType, memory.NewMemorySessionStore)
+}
+
+// Register makes a session store type available to NewSessionStore, for the
+// session-store-type option. It panics if the type is registered twice.
+func Register(storeType string, newStore NewSessionStoreFunc) {
+	storeTypesMutex.Lock()
+	defer storeTypesMutex.Unlock()
+
+	if newStore == nil {
+		panic("sessions: Register of a nil session store for type " + storeType)
+	}
+	if _, ok := storeTypes[storeType]; ok {
+		panic("sessions: Register called twice for session store type " + storeType)
+	}
+	storeTypes[storeType] = newStore
+}
+
+// Types returns the registered session store types, sorted
+func Types() []string {
+	storeTypesMutex.RLock()
+	defer storeTypesMutex.RUnlock()
+
+	types := make([]string, 0, len(storeTypes))
+	for storeType := range storeTypes {
+		types = append(types, storeType)
+	}
+	sort.Strings(types)
+	return types
+}
+
 // NewSessionStore creates a SessionStore from the provided configuration
 func NewSessionStore(opts *options.SessionOptions, cookieOpts *options.CookieOptions) (sessions.SessionStore, error) {
-	switch opts.Type {
-	case options.CookieSessionStoreType:
-		return cookie.NewCookieSessionStore(opts, cookieOpts)
-	case options.RedisSessionStoreType:
-		return redis.NewRedisSessionStore(opts, cookieOpts)
-	default:
+	storeTypesMutex.RLock()
+	newStore, ok := storeTypes[opts.Type]
+	storeTypesMutex.RUnlock()
+
+	if !ok {
 		return nil, fmt.Errorf("unknown session store type '%s'", opts.Type)
 	}
+	return newStore(opts, cookieOpts)
 }
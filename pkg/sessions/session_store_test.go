@@ -7,10 +7,13 @@ import (
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions"
 	sessionscookie "github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/cookie"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/memory"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/redis"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/sql"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -73,6 +76,62 @@ var _ = Describe("NewSessionStore", func() {
 		})
 	})
 
+	Context("with type 'sql'", func() {
+		BeforeEach(func() {
+			opts.Type = options.SQLSessionStoreType
+			opts.SQL.Driver = "sqlite3"
+			opts.SQL.DSN = ":memory:"
+		})
+
+		It("creates a sql.SessionStore", func() {
+			if !sql.SQLiteSupported {
+				Skip("the sqlite3 driver requires cgo")
+			}
+			ss, err := sessions.NewSessionStore(opts, cookieOpts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ss).To(BeAssignableToTypeOf(&sql.SessionStore{}))
+			Expect(ss.(*sql.SessionStore).Close()).To(Succeed())
+		})
+	})
+
+	Context("with type 'memory'", func() {
+		BeforeEach(func() {
+			opts.Type = options.MemorySessionStoreType
+			opts.Memory.MaxSessions = 100
+		})
+
+		It("creates a memory.SessionStore", func() {
+			ss, err := sessions.NewSessionStore(opts, cookieOpts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ss).To(BeAssignableToTypeOf(&memory.SessionStore{}))
+			Expect(ss.(*memory.SessionStore).Close()).To(Succeed())
+		})
+	})
+
+	Context("with a registered type", func() {
+		BeforeEach(func() {
+			opts.Type = "registered"
+		})
+
+		It("creates a session store with the registered function", func() {
+			store := &sessionscookie.SessionStore{}
+			sessions.Register("registered", func(*options.SessionOptions, *options.CookieOptions) (sessionsapi.SessionStore, error) {
+				return store, nil
+			})
+			Expect(sessions.Types()).To(Equal([]string{"cookie", "memory", "redis", "registered", "sql"}))
+
+			ss, err := sessions.NewSessionStore(opts, cookieOpts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ss).To(BeIdenticalTo(store))
+		})
+
+		It("does not register a type twice", func() {
+			Expect(func() {
+				sessions.Register(options.CookieSessionStoreType, sessionscookie.NewCookieSessionStore)
+			}).To(Panic())
+		})
+	})
+
 	Context("with an invalid type", func() {
 		BeforeEach(func() {
 			opts.Type = "invalid-type"
@@ -0,0 +1,158 @@
+//go:build cgo
+// +build cgo
+
+package sql
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/tests"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSessionStore(t *testing.T) {
+	logger.SetOutput(GinkgoWriter)
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SQL SessionStore")
+}
+
+var _ = Describe("SQL SessionStore Tests", func() {
+	var ss sessionsapi.SessionStore
+	// offset is added to the time of the store to fast forward the expiry
+	// of sessions. Each store has its own, as a store may still be reaping
+	// sessions when the next test starts.
+	var offset *time.Duration
+	// The stores of a test share an in-memory database, which is removed
+	// once they are closed
+	var dsn string
+	var stores []*SessionStore
+	var databases int
+
+	BeforeEach(func() {
+		databases++
+		dsn = fmt.Sprintf("file:sessions-%d?mode=memory&cache=shared", databases)
+		stores = nil
+	})
+
+	newStore := func(opts *options.SessionOptions, cookieOpts *options.CookieOptions) (sessionsapi.SessionStore, error) {
+		opts.Type = options.SQLSessionStoreType
+		opts.SQL.Driver = "sqlite3"
+		opts.SQL.DSN = dsn
+
+		var err error
+		ss, err = NewSQLSessionStore(opts, cookieOpts)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, ss.(*SessionStore))
+		storeOffset := new(time.Duration)
+		offset = storeOffset
+		ss.(*SessionStore).store.now = func() time.Time {
+			return time.Now().Add(*storeOffset)
+		}
+		return ss, nil
+	}
+
+	JustAfterEach(func() {
+		// Close the databases immediately after the test ends
+		for _, store := range stores {
+			Expect(store.Close()).To(Succeed())
+		}
+	})
+
+	tests.RunSessionStoreTests(
+		newStore,
+		func(d time.Duration) error {
+			*offset += d
+			return nil
+		},
+	)
+
+	Context("when expired sessions are reaped", func() {
+		var store *SessionStore
+
+		countSessions := func() int {
+			var count int
+			err := store.store.db.QueryRow("SELECT COUNT(*) FROM " + tableName).Scan(&count)
+			Expect(err).ToNot(HaveOccurred())
+			return count
+		}
+
+		BeforeEach(func() {
+			cookieOpts := &options.CookieOptions{
+				Name:   "_oauth2_proxy",
+				Expire: time.Hour,
+				Secret: "0123456789abcdef0123456789abcdef",
+			}
+			_, err := newStore(&options.SessionOptions{}, cookieOpts)
+			Expect(err).ToNot(HaveOccurred())
+			store = ss.(*SessionStore)
+
+			for _, email := range []string{"john.doe@example.com", "jane.doe@example.com"} {
+				rw := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				Expect(ss.Save(rw, req, &sessionsapi.SessionState{Email: email})).To(Succeed())
+			}
+			Expect(countSessions()).To(Equal(2))
+		})
+
+		It("keeps the sessions that have not expired", func() {
+			*offset = 59 * time.Minute
+			reaped, err := store.store.reap(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reaped).To(BeEquivalentTo(0))
+			Expect(countSessions()).To(Equal(2))
+		})
+
+		It("deletes the sessions that have expired", func() {
+			*offset = 61 * time.Minute
+			reaped, err := store.store.reap(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reaped).To(BeEquivalentTo(2))
+			Expect(countSessions()).To(Equal(0))
+		})
+
+		It("deletes them every reap interval", func() {
+			*offset = 61 * time.Minute
+			go store.reapSessions(10 * time.Millisecond)
+			Eventually(countSessions).Should(Equal(0))
+		})
+	})
+
+	It("checks that the database can be reached", func() {
+		_, err := newStore(&options.SessionOptions{}, &options.CookieOptions{
+			Name:   "_oauth2_proxy",
+			Secret: "0123456789abcdef0123456789abcdef",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		store := ss.(*SessionStore)
+		Expect(store.CheckHealth(context.Background())).To(Succeed())
+
+		Expect(store.Close()).To(Succeed())
+		Expect(store.CheckHealth(context.Background())).To(MatchError("sql: database is closed"))
+	})
+
+	It("numbers the query parameters for postgres", func() {
+		query := "DELETE FROM sessions WHERE handle = ? AND expires_at <= ?"
+		Expect(dialects["postgres"].rebind(query)).To(Equal("DELETE FROM sessions WHERE handle = $1 AND expires_at <= $2"))
+		Expect(dialects["sqlite3"].rebind(query)).To(Equal(query))
+	})
+
+	It("returns an error for an unsupported driver", func() {
+		opts := &options.SessionOptions{
+			Type: options.SQLSessionStoreType,
+			SQL:  options.SQLStoreOptions{Driver: "mysql"},
+		}
+		_, err := NewSQLSessionStore(opts, &options.CookieOptions{})
+		Expect(err).To(MatchError(`unsupported sql driver "mysql"`))
+	})
+})
@@ -0,0 +1,213 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Register the PostgreSQL driver. The SQLite driver is registered in
+	// sqlite.go, as it requires cgo.
+	_ "github.com/lib/pq"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/persistence"
+)
+
+// tableName is the table sessions are stored in. It is created when the
+// session store is initialised.
+const tableName = "oauth2_proxy_sessions"
+
+// dialect holds the differences between the supported databases
+type dialect struct {
+	// blobType is the column type of binary values
+	blobType string
+	// numberedParams is set if query parameters are numbered ($1) rather
+	// than positional (?)
+	numberedParams bool
+	// maxOpenConns limits the connections to the database, 0 for no limit
+	maxOpenConns int
+}
+
+// dialects are the supported databases by the name of their database/sql
+// driver
+var dialects = map[string]dialect{
+	"postgres": {blobType: "BYTEA", numberedParams: true},
+	// SQLite allows a single writer, so concurrent writes from several
+	// connections would fail with "database is locked"
+	"sqlite3": {blobType: "BLOB", maxOpenConns: 1},
+}
+
+// rebind rewrites the positional parameters of the query for the dialect
+func (d dialect) rebind(query string) string {
+	if !d.numberedParams {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var _ sessions.HealthChecker = (*SessionStore)(nil)
+
+// SessionStore is an implementation of the sessions.SessionStore
+// interface that stores sessions in a SQL database, with a ticket to them
+// in the session cookie as for the redis session store
+type SessionStore struct {
+	*persistence.Manager
+
+	store    *sqlStore
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewSQLSessionStore initialises a new instance of the SessionStore from
+// the configuration given. It creates the sessions table if it doesn't exist
+// and starts deleting expired sessions every reap interval.
+func NewSQLSessionStore(opts *options.SessionOptions, cookieOpts *options.CookieOptions) (sessions.SessionStore, error) {
+	d, ok := dialects[opts.SQL.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sql driver %q", opts.SQL.Driver)
+	}
+	if opts.SQL.Driver == "sqlite3" && !SQLiteSupported {
+		return nil, errors.New("the sqlite3 sql driver requires oauth2-proxy to be built with cgo")
+	}
+	db, err := sql.Open(opts.SQL.Driver, opts.SQL.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	db.SetMaxOpenConns(d.maxOpenConns)
+
+	store := &sqlStore{
+		db:      db,
+		dialect: d,
+		now:     time.Now,
+	}
+	if err := store.createTable(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating sessions table: %v", err)
+	}
+
+	manager, err := persistence.NewManager(store, cookieOpts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	ss := &SessionStore{
+		Manager: manager,
+		store:   store,
+		stop:    make(chan struct{}),
+	}
+	if opts.SQL.ReapInterval > 0 {
+		go ss.reapSessions(opts.SQL.ReapInterval)
+	}
+	return ss, nil
+}
+
+// reapSessions deletes expired sessions every interval until the session
+// store is closed
+func (ss *SessionStore) reapSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ss.stop:
+			return
+		case <-ticker.C:
+			if _, err := ss.store.reap(context.Background()); err != nil {
+				logger.Printf("error deleting expired sessions: %v", err)
+			}
+		}
+	}
+}
+
+// CheckHealth checks that the database can be reached, for the readiness
+// checks
+func (ss *SessionStore) CheckHealth(ctx context.Context) error {
+	return ss.store.db.PingContext(ctx)
+}
+
+// Close stops deleting expired sessions and closes the database
+func (ss *SessionStore) Close() error {
+	ss.stopOnce.Do(func() {
+		close(ss.stop)
+	})
+	return ss.store.db.Close()
+}
+
+// sqlStore is the persistence.Store of the SessionStore. Sessions are
+// stored with the unix time they expire at, after which they aren't loaded.
+type sqlStore struct {
+	db      *sql.DB
+	dialect dialect
+
+	// now returns the current time, to check the expiry of sessions
+	now func() time.Time
+}
+
+func (s *sqlStore) createTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (handle VARCHAR(255) PRIMARY KEY, value %s NOT NULL, expires_at BIGINT NOT NULL)",
+		tableName, s.dialect.blobType))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS %s_expires_at ON %s (expires_at)",
+		tableName, tableName))
+	return err
+}
+
+// Save stores the value under the key, replacing any existing value
+func (s *sqlStore) Save(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	query := s.dialect.rebind(fmt.Sprintf(
+		"INSERT INTO %s (handle, value, expires_at) VALUES (?, ?, ?) "+
+			"ON CONFLICT (handle) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at",
+		tableName))
+	_, err := s.db.ExecContext(ctx, query, key, value, s.now().Add(expiration).Unix())
+	return err
+}
+
+// Load returns the value stored under the key if it hasn't expired
+func (s *sqlStore) Load(ctx context.Context, key string) ([]byte, error) {
+	query := s.dialect.rebind(fmt.Sprintf(
+		"SELECT value FROM %s WHERE handle = ? AND expires_at > ?", tableName))
+	var value []byte
+	err := s.db.QueryRowContext(ctx, query, key, s.now().Unix()).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("session not found")
+	}
+	return value, err
+}
+
+// Clear removes the value stored under the key
+func (s *sqlStore) Clear(ctx context.Context, key string) error {
+	query := s.dialect.rebind(fmt.Sprintf("DELETE FROM %s WHERE handle = ?", tableName))
+	_, err := s.db.ExecContext(ctx, query, key)
+	return err
+}
+
+// reap deletes the expired sessions and returns how many were deleted
+func (s *sqlStore) reap(ctx context.Context) (int64, error) {
+	query := s.dialect.rebind(fmt.Sprintf("DELETE FROM %s WHERE expires_at <= ?", tableName))
+	result, err := s.db.ExecContext(ctx, query, s.now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
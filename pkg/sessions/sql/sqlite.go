@@ -0,0 +1,13 @@
+//go:build cgo
+// +build cgo
+
+package sql
+
+import (
+	// Register the SQLite driver, which requires cgo
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteSupported is set if the sqlite3 driver is available, which it is in
+// binaries built with cgo
+const SQLiteSupported = true
@@ -0,0 +1,8 @@
+//go:build !cgo
+// +build !cgo
+
+package sql
+
+// SQLiteSupported is set if the sqlite3 driver is available, which it is in
+// binaries built with cgo
+const SQLiteSupported = false
@@ -134,6 +134,69 @@ func RunSessionStoreTests(newSS NewSessionStoreFunc, persistentFastForward Persi
 			}
 		})
 
+		Context("with a rotated cookie secret", func() {
+			var previousCookieOpts options.CookieOptions
+
+			BeforeEach(func() {
+				// Save the session with the previous secret
+				previousCookieOpts = *input.cookieOpts
+				previousSS, err := newSS(opts, &previousCookieOpts)
+				Expect(err).ToNot(HaveOccurred())
+
+				resp := httptest.NewRecorder()
+				Expect(previousSS.Save(resp, input.request, input.session)).To(Succeed())
+				for _, cookie := range resp.Result().Cookies() {
+					input.request.AddCookie(cookie)
+				}
+
+				newSecret := make([]byte, 32)
+				_, err = rand.Read(newSecret)
+				Expect(err).ToNot(HaveOccurred())
+
+				rotatedCookieOpts := *input.cookieOpts
+				rotatedCookieOpts.Secret = string(newSecret)
+				rotatedCookieOpts.PreviousSecrets = []string{previousCookieOpts.Secret}
+				input.cookieOpts = &rotatedCookieOpts
+
+				ss, err = newSS(opts, input.cookieOpts)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			LoadSessionTests(&input)
+
+			It("re-issues the cookie with the new secret when the session is saved", func() {
+				session, err := ss.Load(input.request)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(ss.Save(input.response, input.request, session)).To(Succeed())
+
+				req := httptest.NewRequest("GET", "http://example.com/", nil)
+				for _, cookie := range input.response.Result().Cookies() {
+					req.AddCookie(cookie)
+				}
+
+				currentCookieOpts := *input.cookieOpts
+				currentCookieOpts.PreviousSecrets = nil
+				currentSS, err := newSS(opts, &currentCookieOpts)
+				Expect(err).ToNot(HaveOccurred())
+				loadedSession, err := currentSS.Load(req)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(loadedSession.Email).To(Equal(input.session.Email))
+
+				_, err = ss.Load(req)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("does not load the session once the previous secret is removed", func() {
+				currentCookieOpts := *input.cookieOpts
+				currentCookieOpts.PreviousSecrets = nil
+				currentSS, err := newSS(opts, &currentCookieOpts)
+				Expect(err).ToNot(HaveOccurred())
+
+				_, err = currentSS.Load(input.request)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
 		Context("with an invalid cookie secret", func() {
 			BeforeEach(func() {
 				input.cookieOpts.Secret = "invalid"
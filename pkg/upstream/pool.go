@@ -0,0 +1,283 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+)
+
+// Balancing strategies of a Pool
+const (
+	RoundRobin       = "round-robin"
+	LeastConnections = "least-connections"
+	ConsistentHash   = "consistent-hash"
+)
+
+// userHeader is set on the response by the proxy before routing the request
+// and identifies the authenticated user
+const userHeader = "GAP-Auth"
+
+type failureKey struct{}
+
+// MarkFailed records that proxying the request to its backend failed, so
+// that the pool that selected the backend counts the failure towards
+// ejecting the backend. It does nothing for requests not served by a pool.
+func MarkFailed(req *http.Request) {
+	if failed, ok := req.Context().Value(failureKey{}).(*bool); ok {
+		*failed = true
+	}
+}
+
+// Pool balances requests between backends that serve the same upstream.
+// Backends that fail too many requests in a row, or that fail their health
+// check, are not selected while other backends are available. If no backend
+// is available requests are balanced between all of them.
+type Pool struct {
+	backends []*backend
+	opts     options.UpstreamPool
+	next     uint32
+	client   *http.Client
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// backend is a single server of a pool
+type backend struct {
+	url     *url.URL
+	handler http.Handler
+	active  int64
+
+	mutex        sync.Mutex
+	fails        int
+	ejectedUntil time.Time
+	unhealthy    bool
+}
+
+// NewPool creates a Pool of the backends at urls. newHandler creates the
+// handler that proxies requests to a single backend, and transport is used
+// for health checks (http.DefaultTransport if nil). If health checks are
+// configured they run until Stop is called.
+func NewPool(urls []*url.URL, opts options.UpstreamPool, transport http.RoundTripper, newHandler func(*url.URL) http.Handler) (*Pool, error) {
+	p, err := newPool(urls, opts, transport, newHandler)
+	if err != nil {
+		return nil, err
+	}
+	if opts.HealthCheckPath != "" {
+		go p.runHealthChecks()
+	}
+	return p, nil
+}
+
+func newPool(urls []*url.URL, opts options.UpstreamPool, transport http.RoundTripper, newHandler func(*url.URL) http.Handler) (*Pool, error) {
+	switch opts.Strategy {
+	case RoundRobin, LeastConnections, ConsistentHash:
+	default:
+		return nil, fmt.Errorf("unknown balancing strategy %q", opts.Strategy)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("a pool requires at least one backend")
+	}
+
+	p := &Pool{
+		opts: opts,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.HealthCheckTimeout,
+			// A redirect is a healthy response, so don't follow it
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		stop: make(chan struct{}),
+		now:  time.Now,
+	}
+	for _, u := range urls {
+		// Copy the URL as the handler may modify it
+		backendURL := *u
+		p.backends = append(p.backends, &backend{
+			url:     &backendURL,
+			handler: newHandler(u),
+		})
+	}
+	return p, nil
+}
+
+// Stop stops the health checks of the pool
+func (p *Pool) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
+
+// ServeHTTP proxies the request to a backend selected by the pool's strategy
+func (p *Pool) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	b := p.selectBackend(rw, req)
+
+	atomic.AddInt64(&b.active, 1)
+	defer atomic.AddInt64(&b.active, -1)
+
+	failed := false
+	b.handler.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), failureKey{}, &failed)))
+	p.observe(b, failed)
+}
+
+func (p *Pool) selectBackend(rw http.ResponseWriter, req *http.Request) *backend {
+	now := p.now()
+	candidates := make([]*backend, 0, len(p.backends))
+	for _, b := range p.backends {
+		if b.available(now) {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.backends
+	}
+
+	switch p.opts.Strategy {
+	case LeastConnections:
+		// Start from the next backend in turn so that ties are spread evenly
+		start := int(atomic.AddUint32(&p.next, 1)-1) % len(candidates)
+		selected := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			b := candidates[(start+i)%len(candidates)]
+			if atomic.LoadInt64(&b.active) < atomic.LoadInt64(&selected.active) {
+				selected = b
+			}
+		}
+		return selected
+	case ConsistentHash:
+		return selectByHash(candidates, hashKey(rw, req))
+	default:
+		return candidates[int(atomic.AddUint32(&p.next, 1)-1)%len(candidates)]
+	}
+}
+
+// hashKey is the authenticated user or, if there is none, the client address
+func hashKey(rw http.ResponseWriter, req *http.Request) string {
+	if user := rw.Header().Get(userHeader); user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// selectByHash uses rendezvous hashing so that only the keys of a backend
+// that becomes unavailable move to other backends
+func selectByHash(candidates []*backend, key string) *backend {
+	var selected *backend
+	var highest uint64
+	for _, b := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(b.url.String()))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); selected == nil || score > highest {
+			selected, highest = b, score
+		}
+	}
+	return selected
+}
+
+// observe counts the consecutive failures of the backend and ejects it once
+// they reach MaxFails
+func (p *Pool) observe(b *backend, failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !failed {
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if p.opts.MaxFails > 0 && b.fails >= p.opts.MaxFails {
+		logger.Printf("Ejecting upstream %s for %s after %d failed requests", b.url.Host, p.opts.FailTimeout, b.fails)
+		b.ejectedUntil = p.now().Add(p.opts.FailTimeout)
+		b.fails = 0
+	}
+}
+
+// CheckHealth returns an error if none of the backends of the pool is
+// available, for the readiness checks
+func (p *Pool) CheckHealth(ctx context.Context) error {
+	now := p.now()
+	hosts := make([]string, 0, len(p.backends))
+	for _, b := range p.backends {
+		if b.available(now) {
+			return nil
+		}
+		hosts = append(hosts, b.url.Host)
+	}
+	return fmt.Errorf("none of the upstreams %s is available", strings.Join(hosts, ", "))
+}
+
+func (b *backend) available(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return !b.unhealthy && !now.Before(b.ejectedUntil)
+}
+
+func (p *Pool) runHealthChecks() {
+	ticker := time.NewTicker(p.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		p.checkHealth()
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// checkHealth probes every backend of the pool concurrently
+func (p *Pool) checkHealth() {
+	var wg sync.WaitGroup
+	for _, b := range p.backends {
+		wg.Add(1)
+		go func(b *backend) {
+			defer wg.Done()
+			healthy := p.probe(b)
+
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			if healthy == b.unhealthy {
+				if healthy {
+					logger.Printf("Upstream %s passed its health check", b.url.Host)
+				} else {
+					logger.Printf("Upstream %s failed its health check", b.url.Host)
+				}
+			}
+			b.unhealthy = !healthy
+		}(b)
+	}
+	wg.Wait()
+}
+
+// probe returns whether the health check path of the backend responds with a
+// 2xx or 3xx status
+func (p *Pool) probe(b *backend) bool {
+	// Backends receive the request path unchanged, so the health check path
+	// is relative to the root of the backend rather than to the upstream path
+	target := fmt.Sprintf("%s://%s/%s", b.url.Scheme, b.url.Host, strings.TrimPrefix(p.opts.HealthCheckPath, "/"))
+	resp, err := p.client.Get(target)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
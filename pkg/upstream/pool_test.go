@@ -0,0 +1,232 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPoolOptions(strategy string) options.UpstreamPool {
+	return options.UpstreamPool{
+		Strategy:    strategy,
+		MaxFails:    2,
+		FailTimeout: time.Minute,
+	}
+}
+
+// newTestPool creates a pool of backends that respond with their host,
+// marking the request as failed if the backend is in failing. The pool
+// doesn't run health checks in the background.
+func newTestPool(t *testing.T, opts options.UpstreamPool, hosts []string, failing map[string]bool) *Pool {
+	urls := make([]*url.URL, 0, len(hosts))
+	for _, host := range hosts {
+		urls = append(urls, &url.URL{Scheme: "http", Host: host, Path: "/"})
+	}
+	pool, err := newPool(urls, opts, nil, func(u *url.URL) http.Handler {
+		host := u.Host
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if failing[host] {
+				MarkFailed(req)
+				rw.WriteHeader(http.StatusBadGateway)
+			}
+			rw.Write([]byte(host))
+		})
+	})
+	require.NoError(t, err)
+	return pool
+}
+
+func serve(pool *Pool, user string) string {
+	rw := httptest.NewRecorder()
+	if user != "" {
+		rw.Header().Set("GAP-Auth", user)
+	}
+	pool.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	return rw.Body.String()
+}
+
+func TestNewPoolErrors(t *testing.T) {
+	newHandler := func(*url.URL) http.Handler { return http.NotFoundHandler() }
+	_, err := NewPool([]*url.URL{{Scheme: "http", Host: "a"}}, testPoolOptions("random"), nil, newHandler)
+	assert.EqualError(t, err, "unknown balancing strategy \"random\"")
+
+	_, err = NewPool(nil, testPoolOptions(RoundRobin), nil, newHandler)
+	assert.EqualError(t, err, "a pool requires at least one backend")
+}
+
+func TestRoundRobin(t *testing.T) {
+	pool := newTestPool(t, testPoolOptions(RoundRobin), []string{"a", "b", "c"}, nil)
+
+	var served []string
+	for i := 0; i < 6; i++ {
+		served = append(served, serve(pool, ""))
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, served)
+}
+
+func TestLeastConnections(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	urls := []*url.URL{{Scheme: "http", Host: "slow"}, {Scheme: "http", Host: "fast"}}
+	pool, err := NewPool(urls, testPoolOptions(LeastConnections), nil, func(u *url.URL) http.Handler {
+		host := u.Host
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if host == "slow" {
+				started.Done()
+				<-release
+			}
+			rw.Write([]byte(host))
+		})
+	})
+	require.NoError(t, err)
+
+	// Hold a connection open to the first backend
+	started.Add(1)
+	done := make(chan string)
+	go func() { done <- serve(pool, "") }()
+	started.Wait()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "fast", serve(pool, ""))
+	}
+	close(release)
+	assert.Equal(t, "slow", <-done)
+}
+
+func TestConsistentHash(t *testing.T) {
+	failing := map[string]bool{}
+	pool := newTestPool(t, testPoolOptions(ConsistentHash), []string{"a", "b", "c"}, failing)
+
+	users := []string{"alice@example.com", "bob@example.com", "carol@example.com", "dave@example.com"}
+	assigned := make(map[string]string)
+	for _, user := range users {
+		assigned[user] = serve(pool, user)
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, assigned[user], serve(pool, user))
+		}
+	}
+
+	// Eject the backend of the first user
+	ejected := assigned[users[0]]
+	failing[ejected] = true
+	serve(pool, users[0])
+	serve(pool, users[0])
+	delete(failing, ejected)
+
+	for _, user := range users {
+		served := serve(pool, user)
+		if assigned[user] == ejected {
+			assert.NotEqual(t, ejected, served)
+		} else {
+			// Users of other backends are not moved
+			assert.Equal(t, assigned[user], served)
+		}
+	}
+}
+
+func TestPassiveEjection(t *testing.T) {
+	failing := map[string]bool{"b": true}
+	pool := newTestPool(t, testPoolOptions(RoundRobin), []string{"a", "b"}, failing)
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+
+	// b fails twice and is ejected
+	var served []string
+	for i := 0; i < 6; i++ {
+		served = append(served, serve(pool, ""))
+	}
+	assert.Equal(t, []string{"a", "b", "a", "b", "a", "a"}, served)
+
+	// b is selected again after the fail timeout
+	delete(failing, "b")
+	now = now.Add(time.Minute)
+	served = nil
+	for i := 0; i < 4; i++ {
+		served = append(served, serve(pool, ""))
+	}
+	assert.ElementsMatch(t, []string{"a", "b", "a", "b"}, served)
+}
+
+func TestAllBackendsEjected(t *testing.T) {
+	failing := map[string]bool{"a": true, "b": true}
+	pool := newTestPool(t, testPoolOptions(RoundRobin), []string{"a", "b"}, failing)
+
+	for i := 0; i < 4; i++ {
+		serve(pool, "")
+	}
+	// Requests are still balanced between the backends
+	assert.Equal(t, "a", serve(pool, ""))
+	assert.Equal(t, "b", serve(pool, ""))
+}
+
+func TestPoolCheckHealth(t *testing.T) {
+	failing := map[string]bool{"a": true}
+	pool := newTestPool(t, testPoolOptions(RoundRobin), []string{"a", "b"}, failing)
+	assert.NoError(t, pool.CheckHealth(context.Background()))
+
+	// a fails twice and is ejected
+	for i := 0; i < 4; i++ {
+		serve(pool, "")
+	}
+	assert.NoError(t, pool.CheckHealth(context.Background()))
+
+	// b fails twice and is ejected too
+	failing["b"] = true
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "b", serve(pool, ""))
+	}
+	assert.EqualError(t, pool.CheckHealth(context.Background()), "none of the upstreams a, b is available")
+}
+
+func TestHealthChecks(t *testing.T) {
+	healthy := true
+	var mutex sync.Mutex
+	newBackend := func(alwaysHealthy bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			assert.Equal(t, "/healthz", req.URL.Path)
+			if !alwaysHealthy && !healthy {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+	}
+	stable := newBackend(true)
+	defer stable.Close()
+	flaky := newBackend(false)
+	defer flaky.Close()
+
+	opts := testPoolOptions(RoundRobin)
+	opts.HealthCheckPath = "healthz"
+	opts.HealthCheckInterval = time.Hour
+	opts.HealthCheckTimeout = time.Second
+
+	stableURL, err := url.Parse(stable.URL)
+	require.NoError(t, err)
+	flakyURL, err := url.Parse(flaky.URL)
+	require.NoError(t, err)
+	pool := newTestPool(t, opts, []string{stableURL.Host, flakyURL.Host}, nil)
+
+	mutex.Lock()
+	healthy = false
+	mutex.Unlock()
+	pool.checkHealth()
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, stableURL.Host, serve(pool, ""))
+	}
+
+	mutex.Lock()
+	healthy = true
+	mutex.Unlock()
+	pool.checkHealth()
+	served := []string{serve(pool, ""), serve(pool, "")}
+	assert.ElementsMatch(t, []string{stableURL.Host, flakyURL.Host}, served)
+}
@@ -0,0 +1,122 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// hostPatternRegex matches a host name, optionally with a leading wildcard
+// label
+var hostPatternRegex = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// ParseURL parses an upstream URL. Upstreams without a path serve all paths.
+func ParseURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u, nil
+}
+
+// HostRouter routes requests to the handler of the virtual host matching
+// the request host. Exact hosts take precedence over wildcard hosts, and
+// longer wildcard hosts over shorter ones. Requests that match no virtual
+// host are routed to the default handler.
+type HostRouter struct {
+	defaultHandler http.Handler
+	hosts          map[string]http.Handler
+	wildcards      []wildcardHost
+}
+
+// wildcardHost matches the subdomains of suffix, at any depth
+type wildcardHost struct {
+	suffix  string
+	handler http.Handler
+}
+
+// NewHostRouter creates a HostRouter without virtual hosts
+func NewHostRouter(defaultHandler http.Handler) *HostRouter {
+	return &HostRouter{
+		defaultHandler: defaultHandler,
+		hosts:          make(map[string]http.Handler),
+	}
+}
+
+// ValidateHost returns an error if host is not a valid virtual host: a host
+// name without a port, optionally starting with a "*." wildcard label
+func ValidateHost(host string) error {
+	if host == "" {
+		return errors.New("host is empty")
+	}
+	if !hostPatternRegex.MatchString(strings.ToLower(host)) {
+		return fmt.Errorf("invalid host %q: must be a host name without a port, optionally starting with \"*.\"", host)
+	}
+	return nil
+}
+
+// Handle routes requests for host to handler. The host may start with a
+// "*." wildcard label to match all of its subdomains.
+func (r *HostRouter) Handle(host string, handler http.Handler) error {
+	if err := ValidateHost(host); err != nil {
+		return err
+	}
+	host = strings.ToLower(host)
+
+	if !strings.HasPrefix(host, "*.") {
+		if _, ok := r.hosts[host]; ok {
+			return errors.New("host is configured more than once")
+		}
+		r.hosts[host] = handler
+		return nil
+	}
+
+	suffix := strings.TrimPrefix(host, "*")
+	for _, w := range r.wildcards {
+		if w.suffix == suffix {
+			return errors.New("host is configured more than once")
+		}
+	}
+	r.wildcards = append(r.wildcards, wildcardHost{suffix: suffix, handler: handler})
+	sort.SliceStable(r.wildcards, func(i, j int) bool {
+		return len(r.wildcards[i].suffix) > len(r.wildcards[j].suffix)
+	})
+	return nil
+}
+
+// ServeHTTP routes the request by its host
+func (r *HostRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.handler(req.Host).ServeHTTP(rw, req)
+}
+
+func (r *HostRouter) handler(host string) http.Handler {
+	host = strings.ToLower(stripPort(host))
+	if handler, ok := r.hosts[host]; ok {
+		return handler
+	}
+	for _, w := range r.wildcards {
+		if strings.HasSuffix(host, w.suffix) {
+			return w.handler
+		}
+	}
+	return r.defaultHandler
+}
+
+func stripPort(host string) string {
+	if !strings.Contains(host, ":") {
+		return host
+	}
+	h, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	return h
+}
@@ -0,0 +1,85 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func namedHandler(name string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(name))
+	})
+}
+
+func TestHostRouter(t *testing.T) {
+	router := NewHostRouter(namedHandler("default"))
+	require.NoError(t, router.Handle("app1.example.com", namedHandler("app1")))
+	require.NoError(t, router.Handle("*.example.com", namedHandler("wildcard")))
+	require.NoError(t, router.Handle("*.internal.example.com", namedHandler("internal")))
+
+	testCases := []struct {
+		host     string
+		expected string
+	}{
+		{host: "app1.example.com", expected: "app1"},
+		{host: "APP1.Example.com:8443", expected: "app1"},
+		{host: "app2.example.com", expected: "wildcard"},
+		{host: "a.b.example.com", expected: "wildcard"},
+		{host: "db.internal.example.com", expected: "internal"},
+		{host: "example.com", expected: "default"},
+		{host: "badexample.com", expected: "default"},
+		{host: "127.0.0.1:4180", expected: "default"},
+		{host: "", expected: "default"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = tc.host
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, req)
+			assert.Equal(t, tc.expected, rw.Body.String())
+		})
+	}
+}
+
+func TestHostRouterHandleErrors(t *testing.T) {
+	router := NewHostRouter(namedHandler("default"))
+	require.NoError(t, router.Handle("app1.example.com", namedHandler("app1")))
+	require.NoError(t, router.Handle("*.example.com", namedHandler("wildcard")))
+
+	testCases := []struct {
+		host          string
+		expectedError string
+	}{
+		{host: "App1.example.com", expectedError: "host is configured more than once"},
+		{host: "*.example.com", expectedError: "host is configured more than once"},
+		{host: "", expectedError: "host is empty"},
+		{host: "app1.example.com:443", expectedError: "invalid host \"app1.example.com:443\": must be a host name without a port, optionally starting with \"*.\""},
+		{host: "app.*.example.com", expectedError: "invalid host \"app.*.example.com\": must be a host name without a port, optionally starting with \"*.\""},
+		{host: "https://app1.example.com", expectedError: "invalid host \"https://app1.example.com\": must be a host name without a port, optionally starting with \"*.\""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			assert.EqualError(t, router.Handle(tc.host, namedHandler("other")), tc.expectedError)
+		})
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	u, err := ParseURL("http://127.0.0.1:8080")
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8080/", u.String())
+
+	u, err = ParseURL("http://127.0.0.1:8080/app/")
+	require.NoError(t, err)
+	assert.Equal(t, "/app/", u.Path)
+
+	_, err = ParseURL("http://[::1")
+	assert.Error(t, err)
+}
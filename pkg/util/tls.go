@@ -0,0 +1,60 @@
+package util
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"TLS1.0": tls.VersionTLS10,
+	"TLS1.1": tls.VersionTLS11,
+	"TLS1.2": tls.VersionTLS12,
+	"TLS1.3": tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a TLS version of the form "TLS1.2". The empty
+// version is parsed as 0, which leaves the choice of version to crypto/tls.
+func ParseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("%q must be one of ['TLS1.0', 'TLS1.1', 'TLS1.2', 'TLS1.3']", version)
+	}
+	return v, nil
+}
+
+// ParseCipherSuites parses the names of TLS 1.0 - 1.2 cipher suites, eg
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". TLS 1.3 cipher suites are not
+// configurable in crypto/tls, so they are rejected.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]*tls.CipherSuite)
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[s.Name] = s
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		s, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		if len(s.SupportedVersions) == 1 && s.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("cipher suite %q is a TLS 1.3 cipher suite, which are not configurable", name)
+		}
+		ids = append(ids, s.ID)
+	}
+	return ids, nil
+}
+
+// ParseCertKeyPair parses a certificate and key file pair of the form
+// "<cert-file>:<key-file>"
+func ParseCertKeyPair(pair string) (certFile string, keyFile string, err error) {
+	parts := strings.SplitN(pair, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q must be of the form <cert-file>:<key-file>", pair)
+	}
+	return parts[0], parts[1], nil
+}
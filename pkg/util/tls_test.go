@@ -0,0 +1,51 @@
+package util
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	v, err := ParseTLSVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0), v)
+
+	v, err = ParseTLSVersion("TLS1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+
+	_, err = ParseTLSVersion("SSL3.0")
+	assert.EqualError(t, err, `"SSL3.0" must be one of ['TLS1.0', 'TLS1.1', 'TLS1.2', 'TLS1.3']`)
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := ParseCipherSuites([]string{
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_RSA_WITH_AES_128_CBC_SHA",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	}, ids)
+
+	_, err = ParseCipherSuites([]string{"TLS_NULL_WITH_NULL_NULL"})
+	assert.EqualError(t, err, `unknown cipher suite "TLS_NULL_WITH_NULL_NULL"`)
+
+	_, err = ParseCipherSuites([]string{"TLS_AES_128_GCM_SHA256"})
+	assert.EqualError(t, err, `cipher suite "TLS_AES_128_GCM_SHA256" is a TLS 1.3 cipher suite, which are not configurable`)
+}
+
+func TestParseCertKeyPair(t *testing.T) {
+	certFile, keyFile, err := ParseCertKeyPair("/etc/tls/cert.pem:/etc/tls/key.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/tls/cert.pem", certFile)
+	assert.Equal(t, "/etc/tls/key.pem", keyFile)
+
+	for _, pair := range []string{"/etc/tls/cert.pem", ":/etc/tls/key.pem", "/etc/tls/cert.pem:"} {
+		_, _, err = ParseCertKeyPair(pair)
+		assert.Error(t, err, pair)
+	}
+}
@@ -47,6 +47,7 @@ func configureLogger(o options.Logging, msgs []string) []string {
 	logger.SetStandardTemplate(o.StandardFormat)
 	logger.SetAuthTemplate(o.AuthFormat)
 	logger.SetReqTemplate(o.RequestFormat)
+	logger.SetJSONEnabled(o.JSONEnabled)
 
 	logger.SetExcludePaths(o.ExcludePaths)
 
@@ -17,10 +17,14 @@ import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/mbland/hmacauth"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/authorization"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/header"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/ip"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/sql"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/upstream"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/util"
 	"github.com/oauth2-proxy/oauth2-proxy/providers"
 )
@@ -52,32 +56,18 @@ func Validate(o *options.Options) error {
 	if o.Cookie.Secret == "" {
 		msgs = append(msgs, "missing setting: cookie-secret")
 	} else {
-		validCookieSecretSize := false
-		for _, i := range []int{16, 24, 32} {
-			if len(encryption.SecretBytes(o.Cookie.Secret)) == i {
-				validCookieSecretSize = true
-			}
-		}
-		var decoded bool
-		if string(encryption.SecretBytes(o.Cookie.Secret)) != o.Cookie.Secret {
-			decoded = true
-		}
-		if !validCookieSecretSize {
-			var suffix string
-			if decoded {
-				suffix = " note: cookie secret was base64 decoded"
-			}
-			msgs = append(msgs,
-				fmt.Sprintf("Cookie secret must be 16, 24, or 32 bytes to create an AES cipher. Got %d bytes.%s",
-					len(encryption.SecretBytes(o.Cookie.Secret)), suffix))
-		}
+		msgs = validateCookieSecret("Cookie secret", o.Cookie.Secret, msgs)
+	}
+	for i, secret := range o.Cookie.PreviousSecrets {
+		msgs = validateCookieSecret(fmt.Sprintf("Previous cookie secret %d", i+1), secret, msgs)
 	}
 
 	if o.ClientID == "" {
 		msgs = append(msgs, "missing setting: client-id")
 	}
 	// login.gov uses a signed JWT to authenticate, not a client-secret
-	if o.ProviderType != "login.gov" {
+	// public clients using PKCE do not have a client-secret
+	if o.ProviderType != "login.gov" && o.CodeChallengeMethod == "" {
 		if o.ClientSecret == "" && o.ClientSecretFile == "" {
 			msgs = append(msgs, "missing setting: client-secret or client-secret-file")
 		}
@@ -130,6 +120,14 @@ func Validate(o *options.Options) error {
 						o.ProfileURL = body.Get("userinfo_endpoint").MustString()
 					}
 
+					if o.LogoutURL == "" {
+						o.LogoutURL = body.Get("end_session_endpoint").MustString()
+					}
+
+					if o.RevokeURL == "" {
+						o.RevokeURL = body.Get("revocation_endpoint").MustString()
+					}
+
 					o.SkipOIDCDiscovery = true
 				} else {
 					logger.Printf("error: failed to discover OIDC configuration: %v", err)
@@ -171,12 +169,48 @@ func Validate(o *options.Options) error {
 
 			o.LoginURL = provider.Endpoint().AuthURL
 			o.RedeemURL = provider.Endpoint().TokenURL
+
+			var claims struct {
+				EndSessionEndpoint string `json:"end_session_endpoint"`
+				RevocationEndpoint string `json:"revocation_endpoint"`
+			}
+			if err := provider.Claims(&claims); err != nil {
+				msgs = append(msgs, fmt.Sprintf("error parsing OIDC discovery document: %v", err))
+			}
+			if o.LogoutURL == "" {
+				o.LogoutURL = claims.EndSessionEndpoint
+			}
+			if o.RevokeURL == "" {
+				o.RevokeURL = claims.RevocationEndpoint
+			}
 		}
 		if o.Scope == "" {
 			o.Scope = "openid email profile"
 		}
 	}
 
+	if o.OIDCRPInitiatedLogout && o.LogoutURL == "" {
+		msgs = append(msgs, "missing setting: logout-url")
+	}
+
+	if o.OIDCBackChannelLogout {
+		if o.OIDCIssuerURL == "" {
+			msgs = append(msgs, "oidc-back-channel-logout requires oidc-issuer-url")
+		}
+		if o.Session.Type != options.RedisSessionStoreType {
+			msgs = append(msgs, "oidc-back-channel-logout requires the redis session store")
+		}
+	}
+
+	if o.Session.AdminToken != "" && o.Session.Type != options.RedisSessionStoreType {
+		msgs = append(msgs, "session-admin-token requires the redis session store")
+	}
+	msgs = validateSessionTimeouts(o, msgs)
+	msgs = validateSQLSessionStore(o, msgs)
+	if o.Session.Type == options.MemorySessionStoreType && o.Session.Memory.MaxSessions <= 0 {
+		msgs = append(msgs, "memory-max-sessions must be positive")
+	}
+
 	if o.PreferEmailToUser && !o.PassBasicAuth && !o.PassUserHeaders {
 		msgs = append(msgs, "PreferEmailToUser should only be used with PassBasicAuth or PassUserHeaders")
 	}
@@ -201,16 +235,14 @@ func Validate(o *options.Options) error {
 	o.SetRedirectURL(redirectURL)
 
 	for _, u := range o.Upstreams {
-		upstreamURL, err := url.Parse(u)
+		upstreamURL, err := upstream.ParseURL(u)
 		if err != nil {
 			msgs = append(msgs, fmt.Sprintf("error parsing upstream: %s", err))
 		} else {
-			if upstreamURL.Path == "" {
-				upstreamURL.Path = "/"
-			}
 			o.SetProxyURLs(append(o.GetProxyURLs(), upstreamURL))
 		}
 	}
+	msgs = validateUpstreams(o, msgs)
 
 	for _, u := range o.SkipAuthRegex {
 		compiledRegex, err := regexp.Compile(u)
@@ -220,7 +252,17 @@ func Validate(o *options.Options) error {
 		}
 		o.SetCompiledRegex(append(o.GetCompiledRegex(), compiledRegex))
 	}
+	if _, err := authorization.NewRuleSet(o.AuthorizationRules); err != nil {
+		msgs = append(msgs, err.Error())
+	}
+	if _, err := header.NewInjector(o.InjectRequestHeaders); err != nil {
+		msgs = append(msgs, fmt.Sprintf("inject_request_headers: %v", err))
+	}
+	if _, err := header.NewInjector(o.InjectResponseHeaders); err != nil {
+		msgs = append(msgs, fmt.Sprintf("inject_response_headers: %v", err))
+	}
 	msgs = parseProviderInfo(o, msgs)
+	msgs = validateProviders(o, msgs)
 
 	if o.Cookie.Refresh >= o.Cookie.Expire {
 		msgs = append(msgs, fmt.Sprintf(
@@ -242,6 +284,12 @@ func Validate(o *options.Options) error {
 		}
 	}
 
+	switch o.CodeChallengeMethod {
+	case "", encryption.CodeChallengeMethodPlain, encryption.CodeChallengeMethodS256:
+	default:
+		msgs = append(msgs, fmt.Sprintf("code-challenge-method (%s) must be one of ['', 'plain', 'S256']", o.CodeChallengeMethod))
+	}
+
 	switch o.Cookie.SameSite {
 	case "", "none", "lax", "strict":
 	default:
@@ -257,6 +305,9 @@ func Validate(o *options.Options) error {
 	msgs = parseSignatureKey(o, msgs)
 	msgs = validateCookieName(o, msgs)
 	msgs = configureLogger(o.Logging, msgs)
+	msgs = validateTLSClientAuth(o, msgs)
+	msgs = validateTLS(o, msgs)
+	msgs = validateShutdown(o, msgs)
 
 	if o.ReverseProxy {
 		parser, err := ip.GetRealClientIPParser(o.RealClientIPHeader)
@@ -287,11 +338,17 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 		Prompt:           o.Prompt,
 		ApprovalPrompt:   o.ApprovalPrompt,
 		AcrValues:        o.AcrValues,
+
+		CodeChallengeMethod: o.CodeChallengeMethod,
 	}
 	p.LoginURL, msgs = parseURL(o.LoginURL, "login", msgs)
 	p.RedeemURL, msgs = parseURL(o.RedeemURL, "redeem", msgs)
 	p.ProfileURL, msgs = parseURL(o.ProfileURL, "profile", msgs)
 	p.ValidateURL, msgs = parseURL(o.ValidateURL, "validate", msgs)
+	p.RevokeURL, msgs = parseURL(o.RevokeURL, "revoke", msgs)
+	if o.OIDCRPInitiatedLogout {
+		p.LogoutURL, msgs = parseURL(o.LogoutURL, "logout", msgs)
+	}
 	p.ProtectedResource, msgs = parseURL(o.ProtectedResource, "resource", msgs)
 
 	o.SetProvider(providers.New(o.ProviderType, p))
@@ -345,6 +402,16 @@ func parseProviderInfo(o *options.Options, msgs []string) []string {
 
 				p.LoginURL, msgs = parseURL(provider.Endpoint().AuthURL, "login", msgs)
 				p.RedeemURL, msgs = parseURL(provider.Endpoint().TokenURL, "redeem", msgs)
+
+				if p.RevokeURL != nil && p.RevokeURL.String() == "" {
+					var claims struct {
+						RevocationEndpoint string `json:"revocation_endpoint"`
+					}
+					if err := provider.Claims(&claims); err != nil {
+						msgs = append(msgs, fmt.Sprintf("error parsing OIDC discovery document: %v", err))
+					}
+					p.RevokeURL, msgs = parseURL(claims.RevocationEndpoint, "revoke", msgs)
+				}
 			}
 		}
 	case *providers.LoginGovProvider:
@@ -442,6 +509,128 @@ func newVerifierFromJwtIssuer(jwtIssuer jwtIssuer) (*oidc.IDTokenVerifier, error
 	return verifier, nil
 }
 
+func validateTLSClientAuth(o *options.Options, msgs []string) []string {
+	switch o.TLSClientAuth {
+	case "":
+		if o.TLSClientCAFile != "" {
+			msgs = append(msgs, "tls-client-ca-file requires tls-client-auth")
+		}
+		return msgs
+	case options.TLSClientAuthOptional, options.TLSClientAuthRequire:
+	default:
+		return append(msgs, fmt.Sprintf("tls-client-auth (%s) must be one of ['', 'optional', 'require']", o.TLSClientAuth))
+	}
+
+	if o.TLSCertFile == "" || o.TLSKeyFile == "" {
+		msgs = append(msgs, "tls-client-auth requires tls-cert-file and tls-key-file")
+	}
+	// Clients without a certificate could sign in over HTTP instead
+	if o.TLSClientAuth == options.TLSClientAuthRequire && o.ServeHTTPWithHTTPS {
+		msgs = append(msgs, "tls-client-auth=require can't be used with serve-http-with-https")
+	}
+	if o.TLSClientCAFile == "" {
+		msgs = append(msgs, "missing setting: tls-client-ca-file")
+	} else if _, err := util.GetCertPool([]string{o.TLSClientCAFile}); err != nil {
+		msgs = append(msgs, fmt.Sprintf("unable to load tls-client-ca-file: %v", err))
+	}
+	return msgs
+}
+
+func validateTLS(o *options.Options, msgs []string) []string {
+	// HTTP is only optional when HTTPS is served
+	if o.HTTPAddress == "" && o.TLSCertFile == "" && o.TLSKeyFile == "" {
+		msgs = append(msgs, "missing setting: http-address")
+	}
+	if o.ServeHTTPWithHTTPS {
+		if o.TLSCertFile == "" || o.TLSKeyFile == "" {
+			msgs = append(msgs, "serve-http-with-https requires tls-cert-file and tls-key-file")
+		}
+		if o.HTTPAddress == "" {
+			msgs = append(msgs, "serve-http-with-https requires http-address")
+		}
+	}
+	if len(o.TLSSNICerts) > 0 && (o.TLSCertFile == "" || o.TLSKeyFile == "") {
+		msgs = append(msgs, "tls-sni-cert requires tls-cert-file and tls-key-file")
+	}
+	for _, pair := range o.TLSSNICerts {
+		if _, _, err := util.ParseCertKeyPair(pair); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid tls-sni-cert: %v", err))
+		}
+	}
+
+	minVersion, err := util.ParseTLSVersion(o.TLSMinVersion)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("tls-min-version %v", err))
+	}
+	maxVersion, err := util.ParseTLSVersion(o.TLSMaxVersion)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("tls-max-version %v", err))
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		msgs = append(msgs, fmt.Sprintf("tls-min-version (%s) must not be greater than tls-max-version (%s)", o.TLSMinVersion, o.TLSMaxVersion))
+	}
+
+	if _, err := util.ParseCipherSuites(o.TLSCipherSuites); err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid tls-cipher-suite: %v", err))
+	}
+	return msgs
+}
+
+func validateShutdown(o *options.Options, msgs []string) []string {
+	if o.ShutdownDrainPeriod < 0 {
+		msgs = append(msgs, "shutdown-drain-period must not be negative")
+	}
+	// Only the readiness endpoint reports the drain, so without one the load
+	// balancer keeps sending requests until the listeners are closed
+	if o.ShutdownDrainPeriod > 0 && o.ReadyPath == "" && !o.GCPHealthChecks {
+		msgs = append(msgs, "shutdown-drain-period requires ready-path or gcp-healthchecks")
+	}
+	if o.ShutdownTimeout <= 0 {
+		msgs = append(msgs, "shutdown-timeout must be positive")
+	}
+	return msgs
+}
+
+func validateSessionTimeouts(o *options.Options, msgs []string) []string {
+	if o.Session.IdleTimeout < 0 {
+		msgs = append(msgs, "session-idle-timeout must not be negative")
+	}
+	if o.Session.MaxLifetime < 0 {
+		msgs = append(msgs, "session-max-lifetime must not be negative")
+	}
+	if o.Session.Type != options.RedisSessionStoreType {
+		if o.Session.IdleTimeout != 0 {
+			msgs = append(msgs, "session-idle-timeout requires the redis session store")
+		}
+		if o.Session.MaxLifetime != 0 {
+			msgs = append(msgs, "session-max-lifetime requires the redis session store")
+		}
+	}
+	return msgs
+}
+
+func validateSQLSessionStore(o *options.Options, msgs []string) []string {
+	if o.Session.Type != options.SQLSessionStoreType {
+		return msgs
+	}
+	switch o.Session.SQL.Driver {
+	case "postgres":
+	case "sqlite3":
+		if !sql.SQLiteSupported {
+			msgs = append(msgs, "sql-driver \"sqlite3\" requires oauth2-proxy to be built with cgo (CGO_ENABLED=1)")
+		}
+	default:
+		msgs = append(msgs, fmt.Sprintf("sql-driver %q must be one of ['postgres', 'sqlite3']", o.Session.SQL.Driver))
+	}
+	if o.Session.SQL.DSN == "" {
+		msgs = append(msgs, "missing setting: sql-dsn")
+	}
+	if o.Session.SQL.ReapInterval < 0 {
+		msgs = append(msgs, "sql-reap-interval must not be negative")
+	}
+	return msgs
+}
+
 func validateCookieName(o *options.Options, msgs []string) []string {
 	cookie := &http.Cookie{Name: o.Cookie.Name}
 	if cookie.String() == "" {
@@ -464,3 +653,27 @@ func parseURL(toParse string, urltype string, msgs []string) (*url.URL, []string
 	}
 	return parsed, msgs
 }
+
+// validateCookieSecret checks that the secret is the size of an AES key
+func validateCookieSecret(name, secret string, msgs []string) []string {
+	validCookieSecretSize := false
+	for _, i := range []int{16, 24, 32} {
+		if len(encryption.SecretBytes(secret)) == i {
+			validCookieSecretSize = true
+		}
+	}
+	var decoded bool
+	if string(encryption.SecretBytes(secret)) != secret {
+		decoded = true
+	}
+	if !validCookieSecretSize {
+		var suffix string
+		if decoded {
+			suffix = " note: cookie secret was base64 decoded"
+		}
+		msgs = append(msgs,
+			fmt.Sprintf("%s must be 16, 24, or 32 bytes to create an AES cipher. Got %d bytes.%s",
+				name, len(encryption.SecretBytes(secret)), suffix))
+	}
+	return msgs
+}
@@ -2,8 +2,15 @@ package validation
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/url"
 	"os"
 	"strings"
@@ -11,6 +18,7 @@ import (
 	"time"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions/sql"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -160,6 +168,103 @@ func TestProxyURLsError(t *testing.T) {
 	assert.Contains(t, err.Error(), "error parsing upstream")
 }
 
+func TestUpstreams(t *testing.T) {
+	testCases := []struct {
+		name          string
+		upstreams     []string
+		configure     func(*options.Options)
+		expectedError string
+	}{
+		{
+			name:      "http upstreams sharing a path",
+			upstreams: []string{"http://127.0.0.1:8081/", "https://127.0.0.1:8082/"},
+		},
+		{
+			name:          "file upstream sharing a path",
+			upstreams:     []string{"file:///var/www/static/#/"},
+			expectedError: "upstream path \"/\" is used by more than one upstream; only http(s) upstreams can share a path",
+		},
+		{
+			name: "virtual hosts",
+			configure: func(o *options.Options) {
+				o.VirtualHosts = []options.VirtualHost{
+					{Hosts: []string{"app1.example.com"}, Upstreams: []string{"http://127.0.0.1:8081/"}},
+					{Hosts: []string{"*.example.com"}, Upstreams: []string{"http://127.0.0.1:8082/", "file:///var/www/static/#/static/"}},
+				}
+			},
+		},
+		{
+			name: "virtual host without hosts",
+			configure: func(o *options.Options) {
+				o.VirtualHosts = []options.VirtualHost{{Upstreams: []string{"http://127.0.0.1:8081/"}}}
+			},
+			expectedError: "virtual_hosts[0]: missing setting: hosts",
+		},
+		{
+			name: "virtual host with an invalid host",
+			configure: func(o *options.Options) {
+				o.VirtualHosts = []options.VirtualHost{{Hosts: []string{"app1.example.com:443"}, Upstreams: []string{"http://127.0.0.1:8081/"}}}
+			},
+			expectedError: "virtual_hosts[0]: invalid host \"app1.example.com:443\": must be a host name without a port, optionally starting with \"*.\"",
+		},
+		{
+			name: "host in more than one virtual host",
+			configure: func(o *options.Options) {
+				o.VirtualHosts = []options.VirtualHost{
+					{Hosts: []string{"app1.example.com"}, Upstreams: []string{"http://127.0.0.1:8081/"}},
+					{Hosts: []string{"APP1.example.com"}, Upstreams: []string{"http://127.0.0.1:8082/"}},
+				}
+			},
+			expectedError: "virtual_hosts[1]: host \"APP1.example.com\" is configured more than once",
+		},
+		{
+			name: "virtual host without upstreams",
+			configure: func(o *options.Options) {
+				o.VirtualHosts = []options.VirtualHost{{Hosts: []string{"app1.example.com"}}}
+			},
+			expectedError: "virtual_hosts[0]: missing setting: upstreams",
+		},
+		{
+			name: "unknown balancing strategy",
+			configure: func(o *options.Options) {
+				o.UpstreamPool.Strategy = "random"
+			},
+			expectedError: "upstream-balancing (random) must be one of ['round-robin', 'least-connections', 'consistent-hash']",
+		},
+		{
+			name: "max fails without a fail timeout",
+			configure: func(o *options.Options) {
+				o.UpstreamPool.FailTimeout = 0
+			},
+			expectedError: "upstream-fail-timeout must be positive when upstream-max-fails is set",
+		},
+		{
+			name: "health check without an interval",
+			configure: func(o *options.Options) {
+				o.UpstreamPool.HealthCheckPath = "/healthz"
+				o.UpstreamPool.HealthCheckInterval = 0
+			},
+			expectedError: "upstream-health-check-interval must be positive",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.Upstreams = append(o.Upstreams, tc.upstreams...)
+			if tc.configure != nil {
+				tc.configure(o)
+			}
+			err := Validate(o)
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, "invalid configuration:\n  "+tc.expectedError, err.Error())
+			}
+		})
+	}
+}
+
 func TestCompiledRegex(t *testing.T) {
 	o := testOptions()
 	regexps := []string{"/foo/.*", "/ba[rz]/quux"}
@@ -267,6 +372,17 @@ func TestBase64CookieSecret(t *testing.T) {
 	assert.Equal(t, nil, Validate(o))
 }
 
+func TestPreviousCookieSecrets(t *testing.T) {
+	o := testOptions()
+	o.Cookie.PreviousSecrets = []string{"16 bytes AES-128", "yHBw2lh2Cvo6aI_jn_qMTr-pRAjtq0nzVgDJNb36jgQ"}
+	assert.Equal(t, nil, Validate(o))
+
+	o.Cookie.PreviousSecrets = []string{"16 bytes AES-128", "cookie of invalid length-"}
+	assert.Equal(t, errorMsg([]string{
+		"Previous cookie secret 2 must be 16, 24, or 32 bytes to create an AES cipher. Got 25 bytes."}),
+		Validate(o).Error())
+}
+
 func TestValidateSignatureKey(t *testing.T) {
 	o := testOptions()
 	o.SignatureKey = "sha1:secret"
@@ -305,6 +421,195 @@ func TestValidateCookieBadName(t *testing.T) {
 		fmt.Sprintf("  invalid cookie name: %q", o.Cookie.Name))
 }
 
+func TestCodeChallengeMethod(t *testing.T) {
+	o := testOptions()
+	o.CodeChallengeMethod = "S256"
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, "S256", o.GetProvider().Data().CodeChallengeMethod)
+
+	o = testOptions()
+	o.CodeChallengeMethod = "S512"
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  code-challenge-method (S512) must be one of ['', 'plain', 'S256']", err.Error())
+}
+
+func TestCodeChallengeMethodWithoutClientSecret(t *testing.T) {
+	o := testOptions()
+	o.ClientSecret = ""
+	o.CodeChallengeMethod = "S256"
+	assert.Equal(t, nil, Validate(o))
+}
+
+func TestAuthorizationRules(t *testing.T) {
+	o := testOptions()
+	o.AuthorizationRules = []options.AuthorizationRule{
+		{Policy: "skip", Path: "^/public"},
+		{Policy: "allow", Path: "^/admin", Groups: []string{"admins"}},
+	}
+	assert.Equal(t, nil, Validate(o))
+
+	o = testOptions()
+	o.AuthorizationRules = []options.AuthorizationRule{
+		{Policy: "block", Path: "^/admin"},
+	}
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  invalid authorization rule 0: policy (block) must be one of ['allow', 'deny', 'skip']", err.Error())
+}
+
+func TestInjectHeaders(t *testing.T) {
+	o := testOptions()
+	o.InjectRequestHeaders = []options.Header{
+		{Name: "X-Forwarded-Roles", Values: []options.HeaderValue{{Claim: "roles"}}},
+	}
+	o.InjectResponseHeaders = []options.Header{
+		{Name: "X-Auth-Request-Tenant", Values: []options.HeaderValue{{Value: "example"}}},
+	}
+	assert.Equal(t, nil, Validate(o))
+
+	o = testOptions()
+	o.InjectRequestHeaders = []options.Header{
+		{Name: "X-Forwarded-Roles", Values: []options.HeaderValue{{Claim: "roles", Encoding: "hex"}}},
+	}
+	o.InjectResponseHeaders = []options.Header{
+		{Values: []options.HeaderValue{{Value: "example"}}},
+	}
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  inject_request_headers: invalid header 0: encoding (hex) for X-Forwarded-Roles must be one of ['', 'base64', 'basic_auth']\n"+
+		"  inject_response_headers: invalid header 0: name is required", err.Error())
+}
+
+func TestProviders(t *testing.T) {
+	o := testOptions()
+	o.Providers = []options.Provider{
+		{
+			ID:           "contractors",
+			Type:         "github",
+			Name:         "GitHub (contractors)",
+			ClientID:     "github-client",
+			ClientSecret: "github-secret",
+			GitHubOrg:    "example",
+		},
+		{
+			ID:                  "partners",
+			Type:                "azure",
+			ClientID:            "azure-client",
+			AzureTenant:         "partners-tenant",
+			CodeChallengeMethod: "S256",
+			LogoutURL:           "https://login.microsoftonline.com/partners-tenant/oauth2/logout",
+			EmailDomains:        []string{"partners.example.com"},
+		},
+	}
+	o.OIDCRPInitiatedLogout = true
+	o.LogoutURL = "https://provider.example.com/logout"
+	assert.Equal(t, nil, Validate(o))
+
+	extraProviders := o.GetExtraProviders()
+	assert.Equal(t, 2, len(extraProviders))
+	assert.Equal(t, "contractors", extraProviders[0].Data().ID)
+	assert.Equal(t, "GitHub (contractors)", extraProviders[0].Data().ProviderName)
+	assert.Equal(t, "github-client", extraProviders[0].Data().ClientID)
+	assert.Equal(t, "partners", extraProviders[1].Data().ID)
+	assert.Equal(t, "Azure", extraProviders[1].Data().ProviderName)
+	assert.Equal(t, "https://login.microsoftonline.com/partners-tenant/oauth2/authorize",
+		extraProviders[1].Data().LoginURL.String())
+	assert.Equal(t, "S256", extraProviders[1].Data().CodeChallengeMethod)
+	assert.Equal(t, "https://login.microsoftonline.com/partners-tenant/oauth2/logout",
+		extraProviders[1].Data().LogoutURL.String())
+	assert.Equal(t, []string{"partners.example.com"}, extraProviders[1].Data().EmailDomains)
+	assert.Nil(t, extraProviders[0].Data().LogoutURL)
+	assert.Equal(t, "", o.GetProvider().Data().ID)
+
+	o = testOptions()
+	o.Providers = []options.Provider{
+		{Type: "github", ClientID: "client", ClientSecret: "secret"},
+		{ID: "github", Type: "github", ClientSecret: "secret"},
+		{ID: "github", Type: "github", ClientID: "client", ClientSecret: "secret"},
+		{ID: "git:hub", Type: "github", ClientID: "client"},
+		{ID: "pkce", Type: "github", ClientID: "client", CodeChallengeMethod: "S512"},
+	}
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  providers[0] (): missing setting: id\n"+
+		"  providers[1] (github): missing setting: client_id\n"+
+		"  providers[2] (github): id is not unique\n"+
+		"  providers[3] (git:hub): id may only contain letters, digits, '-' and '_'\n"+
+		"  providers[3] (git:hub): missing setting: client_secret or client_secret_file\n"+
+		"  providers[4] (pkce): code_challenge_method (S512) must be one of ['', 'plain', 'S256']", err.Error())
+}
+
+func TestSessionAdminToken(t *testing.T) {
+	o := testOptions()
+	o.Session.AdminToken = "admin-token"
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  session-admin-token requires the redis session store", err.Error())
+
+	o.Session.Type = options.RedisSessionStoreType
+	o.Session.Redis.ConnectionURL = "redis://127.0.0.1:6379"
+	assert.Equal(t, nil, Validate(o))
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	o := testOptions()
+	o.Session.IdleTimeout = -time.Minute
+	o.Session.MaxLifetime = 12 * time.Hour
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{
+		"session-idle-timeout must not be negative",
+		"session-idle-timeout requires the redis session store",
+		"session-max-lifetime requires the redis session store",
+	}), err.Error())
+
+	o.Session.Type = options.RedisSessionStoreType
+	o.Session.Redis.ConnectionURL = "redis://127.0.0.1:6379"
+	o.Session.IdleTimeout = 30 * time.Minute
+	assert.Equal(t, nil, Validate(o))
+}
+
+func TestSQLSessionStore(t *testing.T) {
+	o := testOptions()
+	o.Session.Type = options.SQLSessionStoreType
+	o.Session.SQL.ReapInterval = -time.Minute
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{
+		"sql-driver \"\" must be one of ['postgres', 'sqlite3']",
+		"missing setting: sql-dsn",
+		"sql-reap-interval must not be negative",
+	}), err.Error())
+
+	o.Session.SQL.Driver = "postgres"
+	o.Session.SQL.DSN = "postgres://oauth2-proxy@localhost/sessions"
+	o.Session.SQL.ReapInterval = 5 * time.Minute
+	assert.Equal(t, nil, Validate(o))
+
+	o.Session.SQL.Driver = "sqlite3"
+	o.Session.SQL.DSN = "file:/var/lib/oauth2-proxy/sessions.db"
+	err = Validate(o)
+	if sql.SQLiteSupported {
+		assert.Equal(t, nil, err)
+	} else {
+		assert.Equal(t, errorMsg([]string{
+			"sql-driver \"sqlite3\" requires oauth2-proxy to be built with cgo (CGO_ENABLED=1)",
+		}), err.Error())
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	o := testOptions()
+	o.Session.Type = options.MemorySessionStoreType
+	o.Session.Memory.MaxSessions = 0
+	err := Validate(o)
+	assert.Equal(t, errorMsg([]string{
+		"memory-max-sessions must be positive",
+	}), err.Error())
+
+	o.Session.Memory.MaxSessions = 1000
+	assert.Equal(t, nil, Validate(o))
+}
+
 func TestSkipOIDCDiscovery(t *testing.T) {
 	o := testOptions()
 	o.ProviderType = "oidc"
@@ -322,6 +627,30 @@ func TestSkipOIDCDiscovery(t *testing.T) {
 	assert.Equal(t, nil, Validate(o))
 }
 
+func TestOIDCLogout(t *testing.T) {
+	o := testOptions()
+	o.ProviderType = "oidc"
+	o.OIDCIssuerURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/v2.0/"
+	o.SkipOIDCDiscovery = true
+	o.LoginURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/oauth2/v2.0/authorize?p=b2c_1_sign_in"
+	o.RedeemURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/oauth2/v2.0/token?p=b2c_1_sign_in"
+	o.OIDCJwksURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/discovery/v2.0/keys"
+	o.OIDCRPInitiatedLogout = true
+	o.OIDCBackChannelLogout = true
+
+	err := Validate(o)
+	assert.Equal(t, "invalid configuration:\n"+
+		"  missing setting: logout-url\n"+
+		"  oidc-back-channel-logout requires the redis session store", err.Error())
+
+	o.LogoutURL = "https://login.microsoftonline.com/fabrikamb2c.onmicrosoft.com/oauth2/v2.0/logout?p=b2c_1_sign_in"
+	o.Session.Type = options.RedisSessionStoreType
+	o.Session.Redis.ConnectionURL = "redis://127.0.0.1:6379"
+
+	assert.Equal(t, nil, Validate(o))
+	assert.Equal(t, o.LogoutURL, o.GetProvider().Data().LogoutURL.String())
+}
+
 func TestGCPHealthcheck(t *testing.T) {
 	o := testOptions()
 	o.GCPHealthChecks = true
@@ -379,3 +708,250 @@ func TestProviderCAFilesError(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unable to load provider CA file(s)")
 }
+
+func TestTLSClientAuth(t *testing.T) {
+	caFile := writeTestCAFile(t)
+	defer os.Remove(caFile)
+
+	testCases := []struct {
+		name         string
+		clientAuth   string
+		clientCAFile string
+		certFile     string
+		serveHTTP    bool
+		expectedMsgs []string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:         "optional",
+			clientAuth:   options.TLSClientAuthOptional,
+			clientCAFile: caFile,
+			certFile:     "cert.pem",
+		},
+		{
+			name:         "require",
+			clientAuth:   options.TLSClientAuthRequire,
+			clientCAFile: caFile,
+			certFile:     "cert.pem",
+		},
+		{
+			name:         "optional with HTTP",
+			clientAuth:   options.TLSClientAuthOptional,
+			clientCAFile: caFile,
+			certFile:     "cert.pem",
+			serveHTTP:    true,
+		},
+		{
+			name:         "require with HTTP",
+			clientAuth:   options.TLSClientAuthRequire,
+			clientCAFile: caFile,
+			certFile:     "cert.pem",
+			serveHTTP:    true,
+			expectedMsgs: []string{"tls-client-auth=require can't be used with serve-http-with-https"},
+		},
+		{
+			name:         "unknown mode",
+			clientAuth:   "always",
+			clientCAFile: caFile,
+			certFile:     "cert.pem",
+			expectedMsgs: []string{"tls-client-auth (always) must be one of ['', 'optional', 'require']"},
+		},
+		{
+			name:         "CA file without client auth",
+			clientCAFile: caFile,
+			expectedMsgs: []string{"tls-client-ca-file requires tls-client-auth"},
+		},
+		{
+			name:       "missing CA file and TLS certificate",
+			clientAuth: options.TLSClientAuthRequire,
+			expectedMsgs: []string{
+				"tls-client-auth requires tls-cert-file and tls-key-file",
+				"missing setting: tls-client-ca-file",
+			},
+		},
+		{
+			name:         "invalid CA file",
+			clientAuth:   options.TLSClientAuthRequire,
+			clientCAFile: "/dev/null",
+			certFile:     "cert.pem",
+			expectedMsgs: []string{"unable to load tls-client-ca-file: loading certificate authority (/dev/null) failed"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.TLSClientAuth = tc.clientAuth
+			o.TLSClientCAFile = tc.clientCAFile
+			o.TLSCertFile = tc.certFile
+			o.TLSKeyFile = tc.certFile
+			o.ServeHTTPWithHTTPS = tc.serveHTTP
+
+			err := Validate(o)
+			if len(tc.expectedMsgs) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, errorMsg(tc.expectedMsgs))
+			}
+		})
+	}
+}
+
+func TestTLSOptions(t *testing.T) {
+	testCases := []struct {
+		name         string
+		modify       func(*options.Options)
+		expectedMsgs []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(*options.Options) {},
+		},
+		{
+			name: "valid options",
+			modify: func(o *options.Options) {
+				o.TLSCertFile = "cert.pem"
+				o.TLSKeyFile = "key.pem"
+				o.TLSSNICerts = []string{"other.crt:other.key"}
+				o.TLSMinVersion = "TLS1.2"
+				o.TLSMaxVersion = "TLS1.3"
+				o.TLSCipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}
+			},
+		},
+		{
+			name: "SNI certificates without a default certificate",
+			modify: func(o *options.Options) {
+				o.TLSSNICerts = []string{"other.crt:other.key", "other.pem"}
+			},
+			expectedMsgs: []string{
+				"tls-sni-cert requires tls-cert-file and tls-key-file",
+				`invalid tls-sni-cert: "other.pem" must be of the form <cert-file>:<key-file>`,
+			},
+		},
+		{
+			name: "HTTPS only",
+			modify: func(o *options.Options) {
+				o.HTTPAddress = ""
+				o.TLSCertFile = "cert.pem"
+				o.TLSKeyFile = "key.pem"
+			},
+		},
+		{
+			name: "HTTP and HTTPS",
+			modify: func(o *options.Options) {
+				o.ServeHTTPWithHTTPS = true
+				o.TLSCertFile = "cert.pem"
+				o.TLSKeyFile = "key.pem"
+			},
+		},
+		{
+			name: "no listener",
+			modify: func(o *options.Options) {
+				o.HTTPAddress = ""
+			},
+			expectedMsgs: []string{"missing setting: http-address"},
+		},
+		{
+			name: "HTTP with HTTPS without TLS or an HTTP address",
+			modify: func(o *options.Options) {
+				o.HTTPAddress = ""
+				o.ServeHTTPWithHTTPS = true
+			},
+			expectedMsgs: []string{
+				"missing setting: http-address",
+				"serve-http-with-https requires tls-cert-file and tls-key-file",
+				"serve-http-with-https requires http-address",
+			},
+		},
+		{
+			name: "invalid versions",
+			modify: func(o *options.Options) {
+				o.TLSMinVersion = "SSL3.0"
+				o.TLSMaxVersion = "TLS1.4"
+			},
+			expectedMsgs: []string{
+				`tls-min-version "SSL3.0" must be one of ['TLS1.0', 'TLS1.1', 'TLS1.2', 'TLS1.3']`,
+				`tls-max-version "TLS1.4" must be one of ['TLS1.0', 'TLS1.1', 'TLS1.2', 'TLS1.3']`,
+			},
+		},
+		{
+			name: "minimum version greater than maximum version",
+			modify: func(o *options.Options) {
+				o.TLSMinVersion = "TLS1.3"
+				o.TLSMaxVersion = "TLS1.2"
+			},
+			expectedMsgs: []string{"tls-min-version (TLS1.3) must not be greater than tls-max-version (TLS1.2)"},
+		},
+		{
+			name: "unknown cipher suite",
+			modify: func(o *options.Options) {
+				o.TLSCipherSuites = []string{"TLS_FOO"}
+			},
+			expectedMsgs: []string{`invalid tls-cipher-suite: unknown cipher suite "TLS_FOO"`},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			tc.modify(o)
+
+			err := Validate(o)
+			if len(tc.expectedMsgs) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, errorMsg(tc.expectedMsgs))
+			}
+		})
+	}
+}
+
+func TestShutdownOptions(t *testing.T) {
+	o := testOptions()
+	o.ShutdownDrainPeriod = 10 * time.Second
+	o.ReadyPath = "/ready"
+	assert.NoError(t, Validate(o))
+
+	o = testOptions()
+	o.ShutdownDrainPeriod = 10 * time.Second
+	o.GCPHealthChecks = true
+	assert.NoError(t, Validate(o))
+
+	o = testOptions()
+	o.ShutdownDrainPeriod = 10 * time.Second
+	assert.EqualError(t, Validate(o), errorMsg([]string{
+		"shutdown-drain-period requires ready-path or gcp-healthchecks",
+	}))
+
+	o = testOptions()
+	o.ShutdownDrainPeriod = -time.Second
+	o.ShutdownTimeout = 0
+	assert.EqualError(t, Validate(o), errorMsg([]string{
+		"shutdown-drain-period must not be negative",
+		"shutdown-timeout must be positive",
+	}))
+}
+
+// writeTestCAFile writes a self signed CA certificate to a temporary file and
+// returns its name
+func writeTestCAFile(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	file, err := ioutil.TempFile("", "ca.*.crt")
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.NoError(t, pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return file.Name()
+}
@@ -0,0 +1,205 @@
+package validation
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"github.com/coreos/go-oidc"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/encryption"
+	"github.com/oauth2-proxy/oauth2-proxy/providers"
+)
+
+var providerIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// validateProviders builds the providers configured in addition to the
+// provider configured by the top level options
+func validateProviders(o *options.Options, msgs []string) []string {
+	ids := make(map[string]struct{})
+	extraProviders := make([]providers.Provider, 0, len(o.Providers))
+	for i, providerOpts := range o.Providers {
+		var providerMsgs []string
+		switch {
+		case providerOpts.ID == "":
+			providerMsgs = append(providerMsgs, "missing setting: id")
+		case !providerIDRegex.MatchString(providerOpts.ID):
+			providerMsgs = append(providerMsgs, "id may only contain letters, digits, '-' and '_'")
+		default:
+			if _, ok := ids[providerOpts.ID]; ok {
+				providerMsgs = append(providerMsgs, "id is not unique")
+			}
+			ids[providerOpts.ID] = struct{}{}
+		}
+
+		var provider providers.Provider
+		provider, providerMsgs = newProvider(o, providerOpts, providerMsgs)
+		extraProviders = append(extraProviders, provider)
+
+		for _, msg := range providerMsgs {
+			msgs = append(msgs, fmt.Sprintf("providers[%d] (%s): %s", i, providerOpts.ID, msg))
+		}
+	}
+	o.SetExtraProviders(extraProviders)
+	return msgs
+}
+
+func newProvider(o *options.Options, providerOpts options.Provider, msgs []string) (providers.Provider, []string) {
+	providerType := providerOpts.Type
+	if providerType == "" {
+		providerType = "google"
+	}
+	if providerType == "login.gov" {
+		msgs = append(msgs, "login.gov can only be configured by the top level options")
+	}
+
+	if providerOpts.ClientID == "" {
+		msgs = append(msgs, "missing setting: client_id")
+	}
+	switch providerOpts.CodeChallengeMethod {
+	case "", encryption.CodeChallengeMethodPlain, encryption.CodeChallengeMethodS256:
+	default:
+		msgs = append(msgs, fmt.Sprintf("code_challenge_method (%s) must be one of ['', 'plain', 'S256']", providerOpts.CodeChallengeMethod))
+	}
+	// Public clients using PKCE do not have a client secret
+	if providerOpts.ClientSecret == "" && providerOpts.CodeChallengeMethod == "" {
+		if providerOpts.ClientSecretFile == "" {
+			msgs = append(msgs, "missing setting: client_secret or client_secret_file")
+		} else if _, err := ioutil.ReadFile(providerOpts.ClientSecretFile); err != nil {
+			msgs = append(msgs, "could not read client secret file: "+providerOpts.ClientSecretFile)
+		}
+	}
+
+	issuerURL := providerOpts.OIDCIssuerURL
+	if issuerURL == "" && providerType == "gitlab" {
+		issuerURL = "https://gitlab.com"
+	}
+	var verifier *oidc.IDTokenVerifier
+	if issuerURL != "" {
+		verifier, msgs = newProviderVerifier(&providerOpts, issuerURL, msgs)
+		if providerOpts.Scope == "" {
+			providerOpts.Scope = "openid email profile"
+		}
+	}
+
+	p := &providers.ProviderData{
+		ID:               providerOpts.ID,
+		Scope:            providerOpts.Scope,
+		ClientID:         providerOpts.ClientID,
+		ClientSecret:     providerOpts.ClientSecret,
+		ClientSecretFile: providerOpts.ClientSecretFile,
+		Prompt:           providerOpts.Prompt,
+		ApprovalPrompt:   providerOpts.ApprovalPrompt,
+		EmailDomains:     providerOpts.EmailDomains,
+
+		CodeChallengeMethod: providerOpts.CodeChallengeMethod,
+	}
+	p.LoginURL, msgs = parseURL(providerOpts.LoginURL, "login", msgs)
+	p.RedeemURL, msgs = parseURL(providerOpts.RedeemURL, "redeem", msgs)
+	p.ProfileURL, msgs = parseURL(providerOpts.ProfileURL, "profile", msgs)
+	p.ValidateURL, msgs = parseURL(providerOpts.ValidateURL, "validate", msgs)
+	p.RevokeURL, msgs = parseURL(providerOpts.RevokeURL, "revoke", msgs)
+	if o.OIDCRPInitiatedLogout && providerOpts.LogoutURL != "" {
+		p.LogoutURL, msgs = parseURL(providerOpts.LogoutURL, "logout", msgs)
+	}
+
+	provider := providers.New(providerType, p)
+	if providerOpts.Name != "" {
+		provider.Data().ProviderName = providerOpts.Name
+	}
+
+	switch p := provider.(type) {
+	case *providers.AzureProvider:
+		p.Configure(providerOpts.AzureTenant)
+	case *providers.GitHubProvider:
+		p.SetOrgTeam(providerOpts.GitHubOrg, providerOpts.GitHubTeam)
+		p.SetRepo(providerOpts.GitHubRepo, providerOpts.GitHubToken)
+		p.SetUsers(providerOpts.GitHubUsers)
+	case *providers.KeycloakProvider:
+		p.SetGroup(providerOpts.KeycloakGroup)
+	case *providers.GoogleProvider:
+		if len(providerOpts.GoogleGroups) > 0 || providerOpts.GoogleAdminEmail != "" || providerOpts.GoogleServiceAccountJSON != "" {
+			if len(providerOpts.GoogleGroups) < 1 {
+				msgs = append(msgs, "missing setting: google_group")
+			}
+			if providerOpts.GoogleAdminEmail == "" {
+				msgs = append(msgs, "missing setting: google_admin_email")
+			}
+			if providerOpts.GoogleServiceAccountJSON == "" {
+				msgs = append(msgs, "missing setting: google_service_account_json")
+			} else if file, err := os.Open(providerOpts.GoogleServiceAccountJSON); err != nil {
+				msgs = append(msgs, "invalid Google credentials file: "+providerOpts.GoogleServiceAccountJSON)
+			} else {
+				p.SetGroupRestriction(providerOpts.GoogleGroups, providerOpts.GoogleAdminEmail, file)
+			}
+		}
+	case *providers.BitbucketProvider:
+		p.SetTeam(providerOpts.BitbucketTeam)
+		p.SetRepository(providerOpts.BitbucketRepository)
+	case *providers.OIDCProvider:
+		p.AllowUnverifiedEmail = providerOpts.InsecureOIDCAllowUnverifiedEmail
+		p.UserIDClaim = providerOpts.UserIDClaim
+		if verifier == nil {
+			msgs = append(msgs, "oidc provider requires an oidc issuer URL")
+		} else {
+			p.Verifier = verifier
+		}
+	case *providers.GitLabProvider:
+		p.AllowUnverifiedEmail = providerOpts.InsecureOIDCAllowUnverifiedEmail
+		p.Groups = providerOpts.GitLabGroup
+		p.EmailDomains = o.EmailDomains
+		if len(providerOpts.EmailDomains) > 0 {
+			p.EmailDomains = providerOpts.EmailDomains
+		}
+		p.Verifier = verifier
+	}
+	return provider, msgs
+}
+
+// newProviderVerifier builds the ID token verifier for the provider, using
+// OIDC discovery to fill in the login, redeem, revoke and logout URLs unless
+// a JWKS URL is configured
+func newProviderVerifier(providerOpts *options.Provider, issuerURL string, msgs []string) (*oidc.IDTokenVerifier, []string) {
+	ctx := context.Background()
+	config := &oidc.Config{ClientID: providerOpts.ClientID}
+
+	if providerOpts.OIDCJwksURL != "" {
+		if providerOpts.LoginURL == "" {
+			msgs = append(msgs, "missing setting: login_url")
+		}
+		if providerOpts.RedeemURL == "" {
+			msgs = append(msgs, "missing setting: redeem_url")
+		}
+		keySet := oidc.NewRemoteKeySet(ctx, providerOpts.OIDCJwksURL)
+		return oidc.NewVerifier(issuerURL, keySet, config), msgs
+	}
+
+	provider, err := oidc.NewProvider(ctx, issuerURL)
+	if err != nil {
+		return nil, append(msgs, fmt.Sprintf("failed to discover OIDC configuration for %s: %v", issuerURL, err))
+	}
+	if providerOpts.LoginURL == "" {
+		providerOpts.LoginURL = provider.Endpoint().AuthURL
+	}
+	if providerOpts.RedeemURL == "" {
+		providerOpts.RedeemURL = provider.Endpoint().TokenURL
+	}
+	if providerOpts.RevokeURL == "" || providerOpts.LogoutURL == "" {
+		var claims struct {
+			RevocationEndpoint string `json:"revocation_endpoint"`
+			EndSessionEndpoint string `json:"end_session_endpoint"`
+		}
+		if err := provider.Claims(&claims); err != nil {
+			msgs = append(msgs, fmt.Sprintf("error parsing OIDC discovery document for %s: %v", issuerURL, err))
+		}
+		if providerOpts.RevokeURL == "" {
+			providerOpts.RevokeURL = claims.RevocationEndpoint
+		}
+		if providerOpts.LogoutURL == "" {
+			providerOpts.LogoutURL = claims.EndSessionEndpoint
+		}
+	}
+	return provider.Verifier(config), msgs
+}
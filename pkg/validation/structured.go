@@ -0,0 +1,246 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/upstream"
+)
+
+// ValidateStructured checks the structured config. Errors are reported with
+// the path to the offending key, eg "upstreams.virtualHosts[0].hosts[1]".
+// The options are validated by Validate once the structured config has been
+// merged into them.
+func ValidateStructured(s *options.StructuredOptions) error {
+	msgs := make([]string, 0)
+	msgs = validateStructuredProviders(s.Providers, msgs)
+	msgs = validateStructuredUpstreams(s.Upstreams, msgs)
+	msgs = validateStructuredHeaders("injectRequestHeaders", s.InjectRequestHeaders, msgs)
+	msgs = validateStructuredHeaders("injectResponseHeaders", s.InjectResponseHeaders, msgs)
+	msgs = validateStructuredSession(s.Session, msgs)
+	msgs = validateStructuredCookie(s.Cookie, msgs)
+
+	if len(msgs) != 0 {
+		return fmt.Errorf("invalid configuration:\n  %s",
+			strings.Join(msgs, "\n  "))
+	}
+	return nil
+}
+
+func validateStructuredProviders(providers []options.Provider, msgs []string) []string {
+	ids := make(map[string]struct{})
+	for i, p := range providers {
+		path := fmt.Sprintf("providers[%d]", i)
+		switch {
+		case i == 0 && p.ID != "":
+			msgs = append(msgs, path+".id: must not be set for the default provider")
+		case i == 0:
+		case p.ID == "":
+			msgs = append(msgs, path+".id: missing setting")
+		case !providerIDRegex.MatchString(p.ID):
+			msgs = append(msgs, path+".id: may only contain letters, digits, '-' and '_'")
+		default:
+			if _, ok := ids[p.ID]; ok {
+				msgs = append(msgs, fmt.Sprintf("%s.id: %q is not unique", path, p.ID))
+			}
+			ids[p.ID] = struct{}{}
+		}
+
+		if p.ClientID == "" {
+			msgs = append(msgs, path+".clientID: missing setting")
+		}
+		if i > 0 && p.Type == "login.gov" {
+			msgs = append(msgs, path+".type: login.gov can only be the default provider")
+		}
+		for _, u := range []struct{ key, raw string }{
+			{"loginURL", p.LoginURL},
+			{"redeemURL", p.RedeemURL},
+			{"profileURL", p.ProfileURL},
+			{"validateURL", p.ValidateURL},
+			{"revokeURL", p.RevokeURL},
+			{"oidcIssuerURL", p.OIDCIssuerURL},
+			{"oidcJwksURL", p.OIDCJwksURL},
+		} {
+			if _, err := url.Parse(u.raw); err != nil {
+				msgs = append(msgs, fmt.Sprintf("%s.%s: %v", path, u.key, err))
+			}
+		}
+	}
+	return msgs
+}
+
+func validateStructuredUpstreams(upstreams options.StructuredUpstreams, msgs []string) []string {
+	var urls []*url.URL
+	urls, msgs = parseStructuredUpstreams("upstreams.urls", upstreams.URLs, msgs)
+	msgs = validateUpstreamPaths(urls, "upstreams.urls: ", msgs)
+
+	hosts := make(map[string]struct{})
+	for i, virtualHost := range upstreams.VirtualHosts {
+		path := fmt.Sprintf("upstreams.virtualHosts[%d]", i)
+		if len(virtualHost.Hosts) == 0 {
+			msgs = append(msgs, path+".hosts: missing setting")
+		}
+		for j, host := range virtualHost.Hosts {
+			if err := upstream.ValidateHost(host); err != nil {
+				msgs = append(msgs, fmt.Sprintf("%s.hosts[%d]: %v", path, j, err))
+				continue
+			}
+			key := strings.ToLower(host)
+			if _, ok := hosts[key]; ok {
+				msgs = append(msgs, fmt.Sprintf("%s.hosts[%d]: host %q is configured more than once", path, j, host))
+			}
+			hosts[key] = struct{}{}
+		}
+
+		if len(virtualHost.Upstreams) == 0 {
+			msgs = append(msgs, path+".upstreams: missing setting")
+		}
+		urls, msgs = parseStructuredUpstreams(path+".upstreams", virtualHost.Upstreams, msgs)
+		msgs = validateUpstreamPaths(urls, path+".upstreams: ", msgs)
+	}
+
+	pool := upstreams.Pool
+	switch pool.Strategy {
+	case upstream.RoundRobin, upstream.LeastConnections, upstream.ConsistentHash:
+	default:
+		msgs = append(msgs, fmt.Sprintf("upstreams.pool.strategy: %q must be one of ['round-robin', 'least-connections', 'consistent-hash']", pool.Strategy))
+	}
+	if pool.MaxFails < 0 {
+		msgs = append(msgs, "upstreams.pool.maxFails: must not be negative")
+	}
+	if pool.MaxFails > 0 && pool.FailTimeout <= 0 {
+		msgs = append(msgs, "upstreams.pool.failTimeout: must be positive when maxFails is set")
+	}
+	if pool.HealthCheckPath != "" {
+		if pool.HealthCheckInterval <= 0 {
+			msgs = append(msgs, "upstreams.pool.healthCheckInterval: must be positive")
+		}
+		if pool.HealthCheckTimeout <= 0 {
+			msgs = append(msgs, "upstreams.pool.healthCheckTimeout: must be positive")
+		}
+	}
+	return msgs
+}
+
+func parseStructuredUpstreams(path string, upstreams []string, msgs []string) ([]*url.URL, []string) {
+	urls := make([]*url.URL, 0, len(upstreams))
+	for i, raw := range upstreams {
+		u, err := upstream.ParseURL(raw)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s[%d]: %v", path, i, err))
+			continue
+		}
+		urls = append(urls, u)
+	}
+	return urls, msgs
+}
+
+func validateStructuredHeaders(path string, headers []options.Header, msgs []string) []string {
+	for i, h := range headers {
+		headerPath := fmt.Sprintf("%s[%d]", path, i)
+		if h.Name == "" {
+			msgs = append(msgs, headerPath+".name: missing setting")
+		}
+		for j, v := range h.Values {
+			valuePath := fmt.Sprintf("%s.values[%d]", headerPath, j)
+			if (v.Value == "") == (v.Claim == "") {
+				msgs = append(msgs, valuePath+": must set exactly one of value or claim")
+			}
+			if v.FallbackClaim != "" && v.Claim == "" {
+				msgs = append(msgs, valuePath+".fallbackClaim: requires claim")
+			}
+			switch v.Encoding {
+			case "", options.Base64HeaderEncoding, options.BasicAuthHeaderEncoding:
+			default:
+				msgs = append(msgs, fmt.Sprintf("%s.encoding: %q must be one of ['', 'base64', 'basic_auth']", valuePath, v.Encoding))
+			}
+		}
+	}
+	return msgs
+}
+
+func validateStructuredSession(session options.StructuredSession, msgs []string) []string {
+	switch session.Type {
+	case options.CookieSessionStoreType:
+	case options.RedisSessionStoreType:
+		redis := session.Redis
+		switch {
+		case redis.UseSentinel && redis.UseCluster:
+			msgs = append(msgs, "session.redis: useSentinel and useCluster are mutually exclusive")
+		case redis.UseSentinel && redis.SentinelMasterName == "":
+			msgs = append(msgs, "session.redis.sentinelMasterName: missing setting")
+		case redis.UseSentinel && len(redis.SentinelConnectionURLs) == 0:
+			msgs = append(msgs, "session.redis.sentinelConnectionURLs: missing setting")
+		case redis.UseCluster && len(redis.ClusterConnectionURLs) == 0:
+			msgs = append(msgs, "session.redis.clusterConnectionURLs: missing setting")
+		case !redis.UseSentinel && !redis.UseCluster && redis.ConnectionURL == "":
+			msgs = append(msgs, "session.redis.connectionURL: missing setting")
+		}
+	case options.SQLSessionStoreType:
+		switch session.SQL.Driver {
+		case "postgres", "sqlite3":
+		default:
+			msgs = append(msgs, fmt.Sprintf("session.sql.driver: %q must be one of ['postgres', 'sqlite3']", session.SQL.Driver))
+		}
+		if session.SQL.DSN == "" {
+			msgs = append(msgs, "session.sql.dsn: missing setting")
+		}
+		if session.SQL.ReapInterval < 0 {
+			msgs = append(msgs, "session.sql.reapInterval: must not be negative")
+		}
+	case options.MemorySessionStoreType:
+		if session.Memory.MaxSessions <= 0 {
+			msgs = append(msgs, "session.memory.maxSessions: must be positive")
+		}
+	default:
+		if !isSessionStoreType(session.Type) {
+			msgs = append(msgs, fmt.Sprintf("session.type: %q must be one of ['%s']",
+				session.Type, strings.Join(sessions.Types(), "', '")))
+		}
+	}
+
+	if session.AdminToken != "" && session.Type != options.RedisSessionStoreType {
+		msgs = append(msgs, "session.adminToken: requires the redis session store")
+	}
+	if session.IdleTimeout < 0 {
+		msgs = append(msgs, "session.idleTimeout: must not be negative")
+	} else if session.IdleTimeout != 0 && session.Type != options.RedisSessionStoreType {
+		msgs = append(msgs, "session.idleTimeout: requires the redis session store")
+	}
+	if session.MaxLifetime < 0 {
+		msgs = append(msgs, "session.maxLifetime: must not be negative")
+	} else if session.MaxLifetime != 0 && session.Type != options.RedisSessionStoreType {
+		msgs = append(msgs, "session.maxLifetime: requires the redis session store")
+	}
+	return msgs
+}
+
+// isSessionStoreType returns whether the session store type is registered
+func isSessionStoreType(storeType string) bool {
+	for _, t := range sessions.Types() {
+		if t == storeType {
+			return true
+		}
+	}
+	return false
+}
+
+func validateStructuredCookie(cookie options.StructuredCookie, msgs []string) []string {
+	if cookie.Name == "" {
+		msgs = append(msgs, "cookie.name: missing setting")
+	}
+	if cookie.Refresh >= cookie.Expire {
+		msgs = append(msgs, fmt.Sprintf("cookie.refresh: %s must be less than cookie.expire (%s)",
+			time.Duration(cookie.Refresh), time.Duration(cookie.Expire)))
+	}
+	switch cookie.SameSite {
+	case "", "none", "lax", "strict":
+	default:
+		msgs = append(msgs, fmt.Sprintf("cookie.sameSite: %q must be one of ['', 'lax', 'strict', 'none']", cookie.SameSite))
+	}
+	return msgs
+}
@@ -0,0 +1,148 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStructured(t *testing.T) {
+	testCases := []struct {
+		name         string
+		modify       func(s *options.StructuredOptions)
+		expectedMsgs []string
+	}{
+		{
+			name:   "with the converted test options",
+			modify: func(*options.StructuredOptions) {},
+		},
+		{
+			name: "with invalid providers",
+			modify: func(s *options.StructuredOptions) {
+				s.Providers[0].ID = "default"
+				s.Providers = append(s.Providers,
+					options.Provider{ClientID: clientID},
+					options.Provider{ID: "corp", Type: "login.gov", ClientID: clientID},
+					options.Provider{ID: "corp"},
+				)
+			},
+			expectedMsgs: []string{
+				"providers[0].id: must not be set for the default provider",
+				"providers[1].id: missing setting",
+				"providers[2].type: login.gov can only be the default provider",
+				"providers[3].id: \"corp\" is not unique",
+				"providers[3].clientID: missing setting",
+			},
+		},
+		{
+			name: "with invalid upstreams",
+			modify: func(s *options.StructuredOptions) {
+				s.Upstreams.URLs = []string{"http://127.0.0.1:8080/", "http://[::1", "file:///var/www/#/"}
+				s.Upstreams.VirtualHosts = []options.VirtualHost{
+					{Hosts: []string{"app.example.com", "app.example.com:443"}, Upstreams: []string{"http://127.0.0.1:9090/"}},
+					{Hosts: []string{"APP.example.com"}},
+				}
+				s.Upstreams.Pool.Strategy = "random"
+				s.Upstreams.Pool.FailTimeout = 0
+			},
+			expectedMsgs: []string{
+				"upstreams.urls[1]: parse \"http://[::1\": missing ']' in host",
+				"upstreams.urls: upstream path \"/\" is used by more than one upstream; only http(s) upstreams can share a path",
+				"upstreams.virtualHosts[0].hosts[1]: invalid host \"app.example.com:443\": must be a host name without a port, optionally starting with \"*.\"",
+				"upstreams.virtualHosts[1].hosts[0]: host \"APP.example.com\" is configured more than once",
+				"upstreams.virtualHosts[1].upstreams: missing setting",
+				"upstreams.pool.strategy: \"random\" must be one of ['round-robin', 'least-connections', 'consistent-hash']",
+				"upstreams.pool.failTimeout: must be positive when maxFails is set",
+			},
+		},
+		{
+			name: "with invalid headers",
+			modify: func(s *options.StructuredOptions) {
+				s.InjectRequestHeaders = []options.Header{
+					{Name: "X-Forwarded-User", Values: []options.HeaderValue{{Claim: "user"}}},
+					{Values: []options.HeaderValue{{Value: "a", Claim: "user"}}},
+				}
+				s.InjectResponseHeaders = []options.Header{
+					{Name: "X-Auth-Request-Email", Values: []options.HeaderValue{{Value: "a", FallbackClaim: "email", Encoding: "hex"}}},
+				}
+			},
+			expectedMsgs: []string{
+				"injectRequestHeaders[1].name: missing setting",
+				"injectRequestHeaders[1].values[0]: must set exactly one of value or claim",
+				"injectResponseHeaders[0].values[0].fallbackClaim: requires claim",
+				"injectResponseHeaders[0].values[0].encoding: \"hex\" must be one of ['', 'base64', 'basic_auth']",
+			},
+		},
+		{
+			name: "with an invalid session and cookie",
+			modify: func(s *options.StructuredOptions) {
+				s.Session.Type = options.RedisSessionStoreType
+				s.Session.AdminToken = "token"
+				s.Cookie.Name = ""
+				s.Cookie.Refresh = options.Duration(s.Cookie.Expire)
+				s.Cookie.SameSite = "always"
+			},
+			expectedMsgs: []string{
+				"session.redis.connectionURL: missing setting",
+				"cookie.name: missing setting",
+				"cookie.refresh: 168h0m0s must be less than cookie.expire (168h0m0s)",
+				"cookie.sameSite: \"always\" must be one of ['', 'lax', 'strict', 'none']",
+			},
+		},
+		{
+			name: "with an invalid sql session store",
+			modify: func(s *options.StructuredOptions) {
+				s.Session.Type = options.SQLSessionStoreType
+				s.Session.SQL.Driver = "mysql"
+				s.Session.SQL.ReapInterval = options.Duration(-time.Minute)
+			},
+			expectedMsgs: []string{
+				"session.sql.driver: \"mysql\" must be one of ['postgres', 'sqlite3']",
+				"session.sql.dsn: missing setting",
+				"session.sql.reapInterval: must not be negative",
+			},
+		},
+		{
+			name: "with an invalid memory session store",
+			modify: func(s *options.StructuredOptions) {
+				s.Session.Type = options.MemorySessionStoreType
+				s.Session.Memory.MaxSessions = 0
+			},
+			expectedMsgs: []string{
+				"session.memory.maxSessions: must be positive",
+			},
+		},
+		{
+			name: "with an unknown session store type",
+			modify: func(s *options.StructuredOptions) {
+				s.Session.Type = "memcached"
+				s.Session.AdminToken = "token"
+				s.Session.IdleTimeout = options.Duration(time.Hour)
+				s.Session.MaxLifetime = options.Duration(-time.Hour)
+				s.Cookie.Refresh = options.Duration(time.Hour)
+			},
+			expectedMsgs: []string{
+				"session.type: \"memcached\" must be one of ['cookie', 'memory', 'redis', 'sql']",
+				"session.adminToken: requires the redis session store",
+				"session.idleTimeout: requires the redis session store",
+				"session.maxLifetime: must not be negative",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := options.NewStructuredOptions(testOptions())
+			tc.modify(s)
+
+			err := ValidateStructured(s)
+			if len(tc.expectedMsgs) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, errorMsg(tc.expectedMsgs))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/upstream"
+)
+
+// validateUpstreams validates the upstream paths, the virtual hosts and the
+// pool options
+func validateUpstreams(o *options.Options, msgs []string) []string {
+	msgs = validateUpstreamPaths(o.GetProxyURLs(), "", msgs)
+	msgs = validateVirtualHosts(o.VirtualHosts, msgs)
+
+	pool := o.UpstreamPool
+	switch pool.Strategy {
+	case upstream.RoundRobin, upstream.LeastConnections, upstream.ConsistentHash:
+	default:
+		msgs = append(msgs, fmt.Sprintf("upstream-balancing (%s) must be one of ['round-robin', 'least-connections', 'consistent-hash']", pool.Strategy))
+	}
+	if pool.MaxFails < 0 {
+		msgs = append(msgs, "upstream-max-fails must not be negative")
+	}
+	if pool.MaxFails > 0 && pool.FailTimeout <= 0 {
+		msgs = append(msgs, "upstream-fail-timeout must be positive when upstream-max-fails is set")
+	}
+	if pool.HealthCheckPath != "" {
+		if pool.HealthCheckInterval <= 0 {
+			msgs = append(msgs, "upstream-health-check-interval must be positive")
+		}
+		if pool.HealthCheckTimeout <= 0 {
+			msgs = append(msgs, "upstream-health-check-timeout must be positive")
+		}
+	}
+	return msgs
+}
+
+// validateUpstreamPaths checks that only HTTP(S) upstreams share a path, as
+// those are balanced as a pool
+func validateUpstreamPaths(urls []*url.URL, prefix string, msgs []string) []string {
+	schemes := make(map[string]string)
+	for _, u := range urls {
+		path := u.Path
+		if u.Scheme == "file" && u.Fragment != "" {
+			path = u.Fragment
+		}
+		scheme, ok := schemes[path]
+		switch {
+		case !ok:
+			schemes[path] = u.Scheme
+		case !isHTTPScheme(scheme) || !isHTTPScheme(u.Scheme):
+			msgs = append(msgs, fmt.Sprintf("%supstream path %q is used by more than one upstream; only http(s) upstreams can share a path", prefix, path))
+		}
+	}
+	return msgs
+}
+
+func validateVirtualHosts(virtualHosts []options.VirtualHost, msgs []string) []string {
+	hosts := make(map[string]struct{})
+	for i, virtualHost := range virtualHosts {
+		prefix := fmt.Sprintf("virtual_hosts[%d]: ", i)
+		if len(virtualHost.Hosts) == 0 {
+			msgs = append(msgs, prefix+"missing setting: hosts")
+		}
+		for _, host := range virtualHost.Hosts {
+			if err := upstream.ValidateHost(host); err != nil {
+				msgs = append(msgs, prefix+err.Error())
+				continue
+			}
+			key := strings.ToLower(host)
+			if _, ok := hosts[key]; ok {
+				msgs = append(msgs, fmt.Sprintf("%shost %q is configured more than once", prefix, host))
+			}
+			hosts[key] = struct{}{}
+		}
+
+		if len(virtualHost.Upstreams) == 0 {
+			msgs = append(msgs, prefix+"missing setting: upstreams")
+		}
+		urls := make([]*url.URL, 0, len(virtualHost.Upstreams))
+		for _, raw := range virtualHost.Upstreams {
+			u, err := upstream.ParseURL(raw)
+			if err != nil {
+				msgs = append(msgs, fmt.Sprintf("%serror parsing upstream: %s", prefix, err))
+				continue
+			}
+			urls = append(urls, u)
+		}
+		msgs = validateUpstreamPaths(urls, prefix, msgs)
+	}
+	return msgs
+}
+
+func isHTTPScheme(scheme string) bool {
+	return scheme == "http" || scheme == "https"
+}
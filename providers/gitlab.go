@@ -39,7 +39,7 @@ func NewGitLabProvider(p *ProviderData) *GitLabProvider {
 }
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
-func (p *GitLabProvider) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *GitLabProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier string) (s *sessions.SessionState, err error) {
 	clientSecret, err := p.GetClientSecret()
 	if err != nil {
 		return
@@ -53,7 +53,11 @@ func (p *GitLabProvider) Redeem(ctx context.Context, redirectURL, code string) (
 		},
 		RedirectURL: redirectURL,
 	}
-	token, err := c.Exchange(ctx, code)
+	var opts []oauth2.AuthCodeOption
+	if codeVerifier != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	}
+	token, err := c.Exchange(ctx, code, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("token exchange: %v", err)
 	}
@@ -248,6 +252,7 @@ func (p *GitLabProvider) GetEmailAddress(ctx context.Context, s *sessions.Sessio
 	if err != nil {
 		return "", fmt.Errorf("group membership check failed: %v", err)
 	}
+	s.Groups = userInfo.Groups
 
 	return userInfo.Email, nil
 }
@@ -26,9 +26,12 @@ import (
 type GoogleProvider struct {
 	*ProviderData
 	RedeemRefreshURL *url.URL
-	// GroupValidator is a function that determines if the passed email is in
-	// the configured Google group.
-	GroupValidator func(string) bool
+	// GroupValidator is a function that determines if the user of the passed
+	// session is in the configured Google group.
+	GroupValidator func(*sessions.SessionState) bool
+	// GroupMembership is a function that returns the configured Google groups
+	// the passed email is a member of.
+	GroupMembership func(string) []string
 }
 
 var _ Provider = (*GoogleProvider)(nil)
@@ -60,6 +63,11 @@ func NewGoogleProvider(p *ProviderData) *GoogleProvider {
 			Host: "www.googleapis.com",
 			Path: "/oauth2/v1/tokeninfo"}
 	}
+	if p.RevokeURL == nil || p.RevokeURL.String() == "" {
+		p.RevokeURL = &url.URL{Scheme: "https",
+			Host: "oauth2.googleapis.com",
+			Path: "/revoke"}
+	}
 	if p.Scope == "" {
 		p.Scope = "profile email"
 	}
@@ -68,9 +76,12 @@ func NewGoogleProvider(p *ProviderData) *GoogleProvider {
 		ProviderData: p,
 		// Set a default GroupValidator to just always return valid (true), it will
 		// be overwritten if we configured a Google group restriction.
-		GroupValidator: func(email string) bool {
+		GroupValidator: func(s *sessions.SessionState) bool {
 			return true
 		},
+		GroupMembership: func(email string) []string {
+			return nil
+		},
 	}
 }
 
@@ -100,7 +111,7 @@ func claimsFromIDToken(idToken string) (*claims, error) {
 }
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
-func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier string) (s *sessions.SessionState, err error) {
 	if code == "" {
 		err = errors.New("missing code")
 		return
@@ -113,9 +124,14 @@ func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (
 	params := url.Values{}
 	params.Add("redirect_uri", redirectURL)
 	params.Add("client_id", p.ClientID)
-	params.Add("client_secret", clientSecret)
+	if clientSecret != "" {
+		params.Add("client_secret", clientSecret)
+	}
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	if codeVerifier != "" {
+		params.Add("code_verifier", codeVerifier)
+	}
 	var req *http.Request
 	req, err = http.NewRequestWithContext(ctx, "POST", p.RedeemURL.String(), bytes.NewBufferString(params.Encode()))
 	if err != nil {
@@ -164,6 +180,7 @@ func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (
 		RefreshToken: jsonResponse.RefreshToken,
 		Email:        c.Email,
 		User:         c.Subject,
+		Groups:       p.GroupMembership(c.Email),
 	}
 	return
 }
@@ -174,8 +191,9 @@ func (p *GoogleProvider) Redeem(ctx context.Context, redirectURL, code string) (
 // account credentials.
 func (p *GoogleProvider) SetGroupRestriction(groups []string, adminEmail string, credentialsReader io.Reader) {
 	adminService := getAdminService(adminEmail, credentialsReader)
-	p.GroupValidator = func(email string) bool {
-		return userInGroup(adminService, groups, email)
+	p.GroupValidator = hasGroupMembership
+	p.GroupMembership = func(email string) []string {
+		return userGroups(adminService, groups, email)
 	}
 }
 
@@ -199,50 +217,64 @@ func getAdminService(adminEmail string, credentialsReader io.Reader) *admin.Serv
 	return adminService
 }
 
-func userInGroup(service *admin.Service, groups []string, email string) bool {
+// hasGroupMembership reports whether the user of the session is a member of
+// one of the configured groups. The groups of the session are those
+// GroupMembership returned when it was redeemed or refreshed, so that the
+// Admin Directory API isn't called again.
+func hasGroupMembership(s *sessions.SessionState) bool {
+	return len(s.Groups) > 0
+}
+
+// userGroups returns each of the groups that the user is a member of
+func userGroups(service *admin.Service, groups []string, email string) []string {
+	var memberOf []string
 	for _, group := range groups {
-		// Use the HasMember API to checking for the user's presence in each group or nested subgroups
-		req := service.Members.HasMember(group, email)
-		r, err := req.Do()
-		if err != nil {
-			gerr, ok := err.(*googleapi.Error)
-			switch {
-			case ok && gerr.Code == 404:
-				logger.Printf("error checking membership in group %s: group does not exist", group)
-			case ok && gerr.Code == 400:
-				// It is possible for Members.HasMember to return false even if the email is a group member.
-				// One case that can cause this is if the user email is from a different domain than the group,
-				// e.g. "member@otherdomain.com" in the group "group@mydomain.com" will result in a 400 error
-				// from the HasMember API. In that case, attempt to query the member object directly from the group.
-				req := service.Members.Get(group, email)
-				r, err := req.Do()
-
-				if err != nil {
-					logger.Printf("error using get API to check member %s of google group %s: user not in the group", email, group)
-					continue
-				}
-
-				// If the non-domain user is found within the group, still verify that they are "ACTIVE".
-				// Do not count the user as belonging to a group if they have another status ("ARCHIVED", "SUSPENDED", or "UNKNOWN").
-				if r.Status == "ACTIVE" {
-					return true
-				}
-			default:
-				logger.Printf("error checking group membership: %v", err)
-			}
-			continue
+		if memberOfGroup(service, group, email) {
+			memberOf = append(memberOf, group)
 		}
-		if r.IsMember {
-			return true
+	}
+	return memberOf
+}
+
+func memberOfGroup(service *admin.Service, group string, email string) bool {
+	// Use the HasMember API to checking for the user's presence in each group or nested subgroups
+	req := service.Members.HasMember(group, email)
+	r, err := req.Do()
+	if err != nil {
+		gerr, ok := err.(*googleapi.Error)
+		switch {
+		case ok && gerr.Code == 404:
+			logger.Printf("error checking membership in group %s: group does not exist", group)
+		case ok && gerr.Code == 400:
+			// It is possible for Members.HasMember to return false even if the email is a group member.
+			// One case that can cause this is if the user email is from a different domain than the group,
+			// e.g. "member@otherdomain.com" in the group "group@mydomain.com" will result in a 400 error
+			// from the HasMember API. In that case, attempt to query the member object directly from the group.
+			req := service.Members.Get(group, email)
+			r, err := req.Do()
+
+			if err != nil {
+				logger.Printf("error using get API to check member %s of google group %s: user not in the group", email, group)
+				return false
+			}
+
+			// If the non-domain user is found within the group, still verify that they are "ACTIVE".
+			// Do not count the user as belonging to a group if they have another status ("ARCHIVED", "SUSPENDED", or "UNKNOWN").
+			if r.Status == "ACTIVE" {
+				return true
+			}
+		default:
+			logger.Printf("error checking group membership: %v", err)
 		}
+		return false
 	}
-	return false
+	return r.IsMember
 }
 
-// ValidateGroup validates that the provided email exists in the configured Google
-// group(s).
-func (p *GoogleProvider) ValidateGroup(email string) bool {
-	return p.GroupValidator(email)
+// ValidateGroup validates that the user of the session is in the configured
+// Google group(s).
+func (p *GoogleProvider) ValidateGroup(s *sessions.SessionState) bool {
+	return p.GroupValidator(s)
 }
 
 // RefreshSessionIfNeeded checks if the session has expired and uses the
@@ -258,7 +290,8 @@ func (p *GoogleProvider) RefreshSessionIfNeeded(ctx context.Context, s *sessions
 	}
 
 	// re-check that the user is in the proper google group(s)
-	if !p.ValidateGroup(s.Email) {
+	s.Groups = p.GroupMembership(s.Email)
+	if !p.ValidateGroup(s) {
 		return false, fmt.Errorf("%s is no longer in the group(s)", s.Email)
 	}
 
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"testing"
 
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 	"github.com/stretchr/testify/assert"
 
 	admin "google.golang.org/api/admin/directory/v1"
@@ -45,6 +46,8 @@ func TestGoogleProviderDefaults(t *testing.T) {
 		p.Data().RedeemURL.String())
 	assert.Equal(t, "https://www.googleapis.com/oauth2/v1/tokeninfo",
 		p.Data().ValidateURL.String())
+	assert.Equal(t, "https://oauth2.googleapis.com/revoke",
+		p.Data().RevokeURL.String())
 	assert.Equal(t, "", p.Data().ProfileURL.String())
 	assert.Equal(t, "profile email", p.Data().Scope)
 }
@@ -102,7 +105,7 @@ func TestGoogleProviderGetEmailAddress(t *testing.T) {
 	p.RedeemURL, server = newRedeemServer(body)
 	defer server.Close()
 
-	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234")
+	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234", "")
 	assert.Equal(t, nil, err)
 	assert.NotEqual(t, session, nil)
 	assert.Equal(t, "michael.bland@gsa.gov", session.Email)
@@ -112,19 +115,38 @@ func TestGoogleProviderGetEmailAddress(t *testing.T) {
 
 func TestGoogleProviderValidateGroup(t *testing.T) {
 	p := newGoogleProvider()
-	p.GroupValidator = func(email string) bool {
-		return email == "michael.bland@gsa.gov"
+	p.GroupValidator = func(s *sessions.SessionState) bool {
+		return s.Email == "michael.bland@gsa.gov"
 	}
-	assert.Equal(t, true, p.ValidateGroup("michael.bland@gsa.gov"))
-	p.GroupValidator = func(email string) bool {
-		return email != "michael.bland@gsa.gov"
+	assert.Equal(t, true, p.ValidateGroup(&sessions.SessionState{Email: "michael.bland@gsa.gov"}))
+	p.GroupValidator = func(s *sessions.SessionState) bool {
+		return s.Email != "michael.bland@gsa.gov"
 	}
-	assert.Equal(t, false, p.ValidateGroup("michael.bland@gsa.gov"))
+	assert.Equal(t, false, p.ValidateGroup(&sessions.SessionState{Email: "michael.bland@gsa.gov"}))
 }
 
 func TestGoogleProviderWithoutValidateGroup(t *testing.T) {
 	p := newGoogleProvider()
-	assert.Equal(t, true, p.ValidateGroup("michael.bland@gsa.gov"))
+	assert.Equal(t, true, p.ValidateGroup(&sessions.SessionState{Email: "michael.bland@gsa.gov"}))
+}
+
+func TestGoogleProviderGroupRestrictionChecksSessionGroups(t *testing.T) {
+	p := newGoogleProvider()
+	calls := 0
+	p.GroupMembership = func(email string) []string {
+		calls++
+		if email == "michael.bland@gsa.gov" {
+			return []string{"group@example.com"}
+		}
+		return nil
+	}
+	p.GroupValidator = hasGroupMembership
+
+	s := &sessions.SessionState{Email: "michael.bland@gsa.gov"}
+	s.Groups = p.GroupMembership(s.Email)
+	assert.Equal(t, true, p.ValidateGroup(s))
+	assert.Equal(t, false, p.ValidateGroup(&sessions.SessionState{Email: "john.doe@example.com"}))
+	assert.Equal(t, 1, calls)
 }
 
 //
@@ -139,7 +161,7 @@ func TestGoogleProviderGetEmailAddressInvalidEncoding(t *testing.T) {
 	p.RedeemURL, server = newRedeemServer(body)
 	defer server.Close()
 
-	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234")
+	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234", "")
 	assert.NotEqual(t, nil, err)
 	if session != nil {
 		t.Errorf("expect nill session %#v", session)
@@ -150,7 +172,7 @@ func TestGoogleProviderRedeemFailsNoCLientSecret(t *testing.T) {
 	p := newGoogleProvider()
 	p.ProviderData.ClientSecretFile = "srvnoerre"
 
-	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234")
+	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234", "")
 	assert.NotEqual(t, nil, err)
 	if session != nil {
 		t.Errorf("expect nill session %#v", session)
@@ -170,7 +192,7 @@ func TestGoogleProviderGetEmailAddressInvalidJson(t *testing.T) {
 	p.RedeemURL, server = newRedeemServer(body)
 	defer server.Close()
 
-	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234")
+	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234", "")
 	assert.NotEqual(t, nil, err)
 	if session != nil {
 		t.Errorf("expect nill session %#v", session)
@@ -189,7 +211,7 @@ func TestGoogleProviderGetEmailAddressEmailMissing(t *testing.T) {
 	p.RedeemURL, server = newRedeemServer(body)
 	defer server.Close()
 
-	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234")
+	session, err := p.Redeem(context.Background(), "http://redirect/", "code1234", "")
 	assert.NotEqual(t, nil, err)
 	if session != nil {
 		t.Errorf("expect nill session %#v", session)
@@ -237,15 +259,15 @@ func TestGoogleProviderUserInGroup(t *testing.T) {
 	service.BasePath = ts.URL
 	assert.Equal(t, nil, err)
 
-	result := userInGroup(service, []string{"group@example.com"}, "member-in-domain@example.com")
-	assert.True(t, result)
+	groups := userGroups(service, []string{"group@example.com"}, "member-in-domain@example.com")
+	assert.Equal(t, []string{"group@example.com"}, groups)
 
-	result = userInGroup(service, []string{"group@example.com"}, "member-out-of-domain@otherexample.com")
-	assert.True(t, result)
+	groups = userGroups(service, []string{"group@example.com"}, "member-out-of-domain@otherexample.com")
+	assert.Equal(t, []string{"group@example.com"}, groups)
 
-	result = userInGroup(service, []string{"group@example.com"}, "non-member-in-domain@example.com")
-	assert.False(t, result)
+	groups = userGroups(service, []string{"group@example.com"}, "non-member-in-domain@example.com")
+	assert.Empty(t, groups)
 
-	result = userInGroup(service, []string{"group@example.com"}, "non-member-out-of-domain@otherexample.com")
-	assert.False(t, result)
+	groups = userGroups(service, []string{"group@example.com"}, "non-member-out-of-domain@otherexample.com")
+	assert.Empty(t, groups)
 }
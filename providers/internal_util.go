@@ -8,8 +8,20 @@ import (
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/requests"
+	"golang.org/x/oauth2"
 )
 
+// httpClient returns the client for requests to the provider: the client set
+// on the context for the oauth2 token exchanges, if any, as for the redeem
+// and refresh requests of the OIDC providers, or http.DefaultClient, which is
+// configured with the provider CA files
+func httpClient(ctx context.Context) *http.Client {
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && client != nil {
+		return client
+	}
+	return http.DefaultClient
+}
+
 // stripToken is a helper function to obfuscate "access_token"
 // query parameters
 func stripToken(endpoint string) string {
@@ -85,5 +85,9 @@ func (p *KeycloakProvider) GetEmailAddress(ctx context.Context, s *sessions.Sess
 		}
 	}
 
+	if groups, err := json.Get("groups").StringArray(); err == nil {
+		s.Groups = groups
+	}
+
 	return json.Get("email").String()
 }
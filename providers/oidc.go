@@ -34,7 +34,7 @@ func NewOIDCProvider(p *ProviderData) *OIDCProvider {
 var _ Provider = (*OIDCProvider)(nil)
 
 // Redeem exchanges the OAuth2 authentication token for an ID token
-func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code, codeVerifier string) (s *sessions.SessionState, err error) {
 	clientSecret, err := p.GetClientSecret()
 	if err != nil {
 		return
@@ -48,7 +48,11 @@ func (p *OIDCProvider) Redeem(ctx context.Context, redirectURL, code string) (s
 		},
 		RedirectURL: redirectURL,
 	}
-	token, err := c.Exchange(ctx, code)
+	var opts []oauth2.AuthCodeOption
+	if codeVerifier != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
+	}
+	token, err := c.Exchange(ctx, code, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("token exchange: %v", err)
 	}
@@ -125,6 +129,8 @@ func (p *OIDCProvider) redeemRefreshToken(ctx context.Context, s *sessions.Sessi
 		s.Email = newSession.Email
 		s.User = newSession.User
 		s.PreferredUsername = newSession.PreferredUsername
+		s.Groups = newSession.Groups
+		s.Claims = newSession.Claims
 	}
 
 	s.AccessToken = newSession.AccessToken
@@ -208,6 +214,8 @@ func (p *OIDCProvider) createSessionStateInternal(ctx context.Context, rawIDToke
 
 	newSession.User = claims.Subject
 	newSession.PreferredUsername = claims.PreferredUsername
+	newSession.Groups = sessions.ClaimValues(claims.rawClaims["groups"])
+	newSession.SetClaims(claims.rawClaims)
 
 	verifyEmail := (p.UserIDClaim == emailClaim) && !p.AllowUnverifiedEmail
 	if verifyEmail && claims.Verified != nil && !*claims.Verified {
@@ -158,7 +158,7 @@ func TestOIDCProviderRedeem(t *testing.T) {
 	server, provider := newTestSetup(body)
 	defer server.Close()
 
-	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "")
 	assert.Equal(t, nil, err)
 	assert.Equal(t, defaultIDToken.Email, session.Email)
 	assert.Equal(t, accessToken, session.AccessToken)
@@ -182,11 +182,40 @@ func TestOIDCProviderRedeem_custom_userid(t *testing.T) {
 	provider.UserIDClaim = "phone_number"
 	defer server.Close()
 
-	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234")
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "")
 	assert.Equal(t, nil, err)
 	assert.Equal(t, defaultIDToken.Phone, session.Email)
 }
 
+func TestOIDCProviderRedeemWithGroupsAndClaims(t *testing.T) {
+
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	idToken, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud":    clientID,
+		"exp":    time.Now().Add(time.Duration(5) * time.Minute).Unix(),
+		"iss":    "https://issuer.example.com",
+		"sub":    "123456789",
+		"email":  "janed@me.com",
+		"groups": []string{"admins", "users"},
+		"role":   "editor",
+	}).SignedString(key)
+	body, _ := json.Marshal(redeemTokenResponse{
+		AccessToken:  accessToken,
+		ExpiresIn:    10,
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		IDToken:      idToken,
+	})
+
+	server, provider := newTestSetup(body)
+	defer server.Close()
+
+	session, err := provider.Redeem(context.Background(), provider.RedeemURL.String(), "code1234", "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"admins", "users"}, session.Groups)
+	assert.Equal(t, map[string][]string{"role": {"editor"}}, session.Claims)
+}
+
 func TestOIDCProviderRefreshSessionIfNeededWithoutIdToken(t *testing.T) {
 
 	idToken, _ := newSignedTestIDToken(defaultIDToken)
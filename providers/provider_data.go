@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io/ioutil"
 	"net/url"
+	"strings"
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
 )
@@ -11,12 +12,19 @@ import (
 // ProviderData contains information required to configure all implementations
 // of OAuth2 providers
 type ProviderData struct {
+	// ID identifies the provider when multiple providers are configured.
+	// It is empty for the provider configured by the top level options.
+	ID                string
 	ProviderName      string
 	LoginURL          *url.URL
 	RedeemURL         *url.URL
 	ProfileURL        *url.URL
 	ProtectedResource *url.URL
 	ValidateURL       *url.URL
+	// LogoutURL is the OIDC end_session_endpoint used for RP-initiated logout
+	LogoutURL *url.URL
+	// RevokeURL is the RFC 7009 token revocation endpoint used on sign out
+	RevokeURL *url.URL
 	// Auth request params & related, see
 	//https://openid.net/specs/openid-connect-basic-1_0.html#rfc.section.2.1.1.1
 	AcrValues        string
@@ -26,11 +34,33 @@ type ProviderData struct {
 	ClientSecretFile string
 	Scope            string
 	Prompt           string
+	// CodeChallengeMethod is the PKCE (RFC 7636) method used to derive the
+	// code challenge; PKCE is disabled when empty
+	CodeChallengeMethod string
+	// EmailDomains restricts the users of the provider to these email
+	// domains, in addition to the proxy's email restrictions. Users of the
+	// provider aren't restricted when it is empty.
+	EmailDomains []string
 }
 
 // Data returns the ProviderData
 func (p *ProviderData) Data() *ProviderData { return p }
 
+// ValidateEmailDomain validates that the email is in one of the email domains
+// of the provider
+func (p *ProviderData) ValidateEmailDomain(email string) bool {
+	if len(p.EmailDomains) == 0 {
+		return true
+	}
+	email = strings.ToLower(email)
+	for _, domain := range p.EmailDomains {
+		if domain == "*" || (email != "" && strings.HasSuffix(email, "@"+strings.ToLower(domain))) {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *ProviderData) GetClientSecret() (clientSecret string, err error) {
 	if p.ClientSecret != "" || p.ClientSecretFile == "" {
 		return p.ClientSecret, nil
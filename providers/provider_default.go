@@ -19,7 +19,7 @@ import (
 var _ Provider = (*ProviderData)(nil)
 
 // Redeem provides a default implementation of the OAuth2 token redemption process
-func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (s *sessions.SessionState, err error) {
+func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code, codeVerifier string) (s *sessions.SessionState, err error) {
 	if code == "" {
 		err = errors.New("missing code")
 		return
@@ -32,9 +32,14 @@ func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (s
 	params := url.Values{}
 	params.Add("redirect_uri", redirectURL)
 	params.Add("client_id", p.ClientID)
-	params.Add("client_secret", clientSecret)
+	if clientSecret != "" {
+		params.Add("client_secret", clientSecret)
+	}
 	params.Add("code", code)
 	params.Add("grant_type", "authorization_code")
+	if codeVerifier != "" {
+		params.Add("code_verifier", codeVerifier)
+	}
 	if p.ProtectedResource != nil && p.ProtectedResource.String() != "" {
 		params.Add("resource", p.ProtectedResource.String())
 	}
@@ -90,7 +95,7 @@ func (p *ProviderData) Redeem(ctx context.Context, redirectURL, code string) (s
 }
 
 // GetLoginURL with typical oauth parameters
-func (p *ProviderData) GetLoginURL(redirectURI, state string) string {
+func (p *ProviderData) GetLoginURL(redirectURI, state, codeChallenge string) string {
 	a := *p.LoginURL
 	params, _ := url.ParseQuery(a.RawQuery)
 	params.Set("redirect_uri", redirectURI)
@@ -106,6 +111,10 @@ func (p *ProviderData) GetLoginURL(redirectURI, state string) string {
 	params.Set("client_id", p.ClientID)
 	params.Set("response_type", "code")
 	params.Add("state", state)
+	if codeChallenge != "" {
+		params.Set("code_challenge", codeChallenge)
+		params.Set("code_challenge_method", p.CodeChallengeMethod)
+	}
 	a.RawQuery = params.Encode()
 	return a.String()
 }
@@ -125,9 +134,9 @@ func (p *ProviderData) GetPreferredUsername(ctx context.Context, s *sessions.Ses
 	return "", errors.New("not implemented")
 }
 
-// ValidateGroup validates that the provided email exists in the configured provider
-// email group(s).
-func (p *ProviderData) ValidateGroup(email string) bool {
+// ValidateGroup validates that the user of the session is in the configured
+// provider group(s).
+func (p *ProviderData) ValidateGroup(s *sessions.SessionState) bool {
 	return true
 }
 
@@ -142,6 +151,58 @@ func (p *ProviderData) RefreshSessionIfNeeded(ctx context.Context, s *sessions.S
 	return false, nil
 }
 
+// RevokeToken revokes the tokens of the session at the RFC 7009 token
+// revocation endpoint, if the provider has one. Revoking the refresh token
+// also revokes the access tokens issued with it, so the access token is only
+// revoked when there is no refresh token.
+func (p *ProviderData) RevokeToken(ctx context.Context, s *sessions.SessionState) error {
+	if p.RevokeURL == nil || p.RevokeURL.String() == "" {
+		return nil
+	}
+
+	params := url.Values{}
+	switch {
+	case s.RefreshToken != "":
+		params.Add("token", s.RefreshToken)
+		params.Add("token_type_hint", "refresh_token")
+	case s.AccessToken != "":
+		params.Add("token", s.AccessToken)
+		params.Add("token_type_hint", "access_token")
+	default:
+		return nil
+	}
+
+	clientSecret, err := p.GetClientSecret()
+	if err != nil {
+		return err
+	}
+	params.Add("client_id", p.ClientID)
+	if clientSecret != "" {
+		params.Add("client_secret", clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.RevokeURL.String(), bytes.NewBufferString(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient(ctx).Do(req)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got %d from %q %s", resp.StatusCode, p.RevokeURL.String(), body)
+	}
+	return nil
+}
+
 func (p *ProviderData) CreateSessionStateFromBearerToken(ctx context.Context, rawIDToken string, idToken *oidc.IDToken) (*sessions.SessionState, error) {
 	var claims struct {
 		Subject           string `json:"sub"`
@@ -154,6 +215,11 @@ func (p *ProviderData) CreateSessionStateFromBearerToken(ctx context.Context, ra
 		return nil, fmt.Errorf("failed to parse bearer token claims: %v", err)
 	}
 
+	var rawClaims map[string]interface{}
+	if err := idToken.Claims(&rawClaims); err != nil {
+		return nil, fmt.Errorf("failed to parse bearer token claims: %v", err)
+	}
+
 	if claims.Email == "" {
 		claims.Email = claims.Subject
 	}
@@ -170,7 +236,9 @@ func (p *ProviderData) CreateSessionStateFromBearerToken(ctx context.Context, ra
 		IDToken:           rawIDToken,
 		RefreshToken:      "",
 		ExpiresOn:         &idToken.Expiry,
+		Groups:            sessions.ClaimValues(rawClaims["groups"]),
 	}
+	newSession.SetClaims(rawClaims)
 
 	return newSession, nil
 }
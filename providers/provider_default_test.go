@@ -4,6 +4,9 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
 )
 
 func TestRefresh(t *testing.T) {
@@ -26,6 +30,111 @@ func TestRefresh(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestRevokeToken(t *testing.T) {
+	testCases := []struct {
+		name           string
+		session        *sessions.SessionState
+		status         int
+		expectedParams url.Values
+		expectedError  bool
+	}{
+		{
+			name:    "revokes the refresh token",
+			session: &sessions.SessionState{AccessToken: "access", RefreshToken: "refresh"},
+			status:  http.StatusOK,
+			expectedParams: url.Values{
+				"token":           {"refresh"},
+				"token_type_hint": {"refresh_token"},
+				"client_id":       {"client"},
+				"client_secret":   {"secret"},
+			},
+		},
+		{
+			name:    "revokes the access token without a refresh token",
+			session: &sessions.SessionState{AccessToken: "access"},
+			status:  http.StatusOK,
+			expectedParams: url.Values{
+				"token":           {"access"},
+				"token_type_hint": {"access_token"},
+				"client_id":       {"client"},
+				"client_secret":   {"secret"},
+			},
+		},
+		{
+			name:    "no tokens",
+			session: &sessions.SessionState{},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "revocation error",
+			session: &sessions.SessionState{AccessToken: "access"},
+			status:  http.StatusServiceUnavailable,
+			expectedParams: url.Values{
+				"token":           {"access"},
+				"token_type_hint": {"access_token"},
+				"client_id":       {"client"},
+				"client_secret":   {"secret"},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var params url.Values
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				body, err := ioutil.ReadAll(req.Body)
+				assert.NoError(t, err)
+				params, err = url.ParseQuery(string(body))
+				assert.NoError(t, err)
+				assert.Equal(t, "POST", req.Method)
+				assert.Equal(t, "/revoke", req.URL.Path)
+				rw.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			revokeURL, err := url.Parse(server.URL + "/revoke")
+			assert.NoError(t, err)
+			p := &ProviderData{
+				ClientID:     "client",
+				ClientSecret: "secret",
+				RevokeURL:    revokeURL,
+			}
+
+			err = p.RevokeToken(context.Background(), tc.session)
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedParams, params)
+		})
+	}
+}
+
+func TestRevokeTokenWithoutRevokeURL(t *testing.T) {
+	p := &ProviderData{}
+	err := p.RevokeToken(context.Background(), &sessions.SessionState{AccessToken: "access"})
+	assert.NoError(t, err)
+}
+
+func TestRevokeTokenWithContextClient(t *testing.T) {
+	// The client of the context trusts the certificate of the TLS server,
+	// unlike http.DefaultClient
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	revokeURL, err := url.Parse(server.URL + "/revoke")
+	assert.NoError(t, err)
+	p := &ProviderData{ClientID: "client", RevokeURL: revokeURL}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, server.Client())
+	err = p.RevokeToken(ctx, &sessions.SessionState{AccessToken: "access"})
+	assert.NoError(t, err)
+}
+
 func TestAcrValuesNotConfigured(t *testing.T) {
 	p := &ProviderData{
 		LoginURL: &url.URL{
@@ -35,7 +144,7 @@ func TestAcrValuesNotConfigured(t *testing.T) {
 		},
 	}
 
-	result := p.GetLoginURL("https://my.test.app/oauth", "")
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "")
 	assert.NotContains(t, result, "acr_values")
 }
 
@@ -49,10 +158,62 @@ func TestAcrValuesConfigured(t *testing.T) {
 		AcrValues: "testValue",
 	}
 
-	result := p.GetLoginURL("https://my.test.app/oauth", "")
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "")
 	assert.Contains(t, result, "acr_values=testValue")
 }
 
+func TestCodeChallengeNotConfigured(t *testing.T) {
+	p := &ProviderData{
+		LoginURL: &url.URL{
+			Scheme: "http",
+			Host:   "my.test.idp",
+			Path:   "/oauth/authorize",
+		},
+	}
+
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "")
+	assert.NotContains(t, result, "code_challenge")
+}
+
+func TestCodeChallengeConfigured(t *testing.T) {
+	p := &ProviderData{
+		LoginURL: &url.URL{
+			Scheme: "http",
+			Host:   "my.test.idp",
+			Path:   "/oauth/authorize",
+		},
+		CodeChallengeMethod: "S256",
+	}
+
+	result := p.GetLoginURL("https://my.test.app/oauth", "", "challenge1234")
+	assert.Contains(t, result, "code_challenge=challenge1234")
+	assert.Contains(t, result, "code_challenge_method=S256")
+}
+
+func TestRedeemSendsCodeVerifier(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		rw.Write([]byte(`{"access_token": "my_access_token"}`))
+	}))
+	defer server.Close()
+
+	redeemURL, _ := url.Parse(server.URL)
+	p := &ProviderData{
+		ClientID:  "client",
+		RedeemURL: redeemURL,
+	}
+
+	session, err := p.Redeem(context.Background(), "https://my.test.app/oauth", "code1234", "verifier1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "my_access_token", session.AccessToken)
+	assert.Equal(t, "verifier1234", form.Get("code_verifier"))
+	// Public clients have no secret and should not send an empty one
+	_, ok := form["client_secret"]
+	assert.False(t, ok)
+}
+
 func TestCreateSessionStateFromBearerToken(t *testing.T) {
 	minimalIDToken := jwt.StandardClaims{
 		Audience:  "asdf1234",
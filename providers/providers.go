@@ -13,11 +13,12 @@ type Provider interface {
 	GetEmailAddress(ctx context.Context, s *sessions.SessionState) (string, error)
 	GetUserName(ctx context.Context, s *sessions.SessionState) (string, error)
 	GetPreferredUsername(ctx context.Context, s *sessions.SessionState) (string, error)
-	Redeem(ctx context.Context, redirectURI, code string) (*sessions.SessionState, error)
-	ValidateGroup(string) bool
+	Redeem(ctx context.Context, redirectURI, code, codeVerifier string) (*sessions.SessionState, error)
+	ValidateGroup(*sessions.SessionState) bool
 	ValidateSessionState(ctx context.Context, s *sessions.SessionState) bool
-	GetLoginURL(redirectURI, finalRedirect string) string
+	GetLoginURL(redirectURI, finalRedirect, codeChallenge string) string
 	RefreshSessionIfNeeded(ctx context.Context, s *sessions.SessionState) (bool, error)
+	RevokeToken(ctx context.Context, s *sessions.SessionState) error
 	CreateSessionStateFromBearerToken(ctx context.Context, rawIDToken string, idToken *oidc.IDToken) (*sessions.SessionState, error)
 }
 
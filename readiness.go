@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/middleware"
+)
+
+// providerCheckCacheTTL is how long the result of a provider check is reused,
+// so that readiness probes don't send a request to the provider each time
+const providerCheckCacheTTL = 10 * time.Second
+
+// readinessChecks returns the checks run by the readiness endpoint: that the
+// server is not shutting down and that the session store can be reached. With
+// ReadyCheckDependencies, it also checks that the OIDC discovery documents or
+// keys of the providers can be reached and that the upstreams, balanced in
+// pools or not, are available.
+func readinessChecks(opts *options.Options, proxy *OAuthProxy, ready func() bool) []middleware.ReadinessCheck {
+	checks := []middleware.ReadinessCheck{{
+		Name: "shutdown",
+		Check: func(context.Context) error {
+			if !ready() {
+				return errors.New("shutting down")
+			}
+			return nil
+		},
+	}}
+
+	if store, ok := proxy.sessionStore.(sessionsapi.HealthChecker); ok {
+		checks = append(checks, middleware.ReadinessCheck{
+			Name:  "session_store",
+			Check: store.CheckHealth,
+		})
+	}
+
+	if !opts.ReadyCheckDependencies {
+		return checks
+	}
+
+	if u := providerCheckURL(opts.OIDCIssuerURL, opts.OIDCJwksURL); u != "" {
+		checks = append(checks, providerReadinessCheck("provider", u))
+	}
+	for _, p := range opts.Providers {
+		if u := providerCheckURL(p.OIDCIssuerURL, p.OIDCJwksURL); u != "" {
+			checks = append(checks, providerReadinessCheck("provider:"+p.ID, u))
+		}
+	}
+
+	if len(proxy.upstreamPools) > 0 || len(proxy.upstreamURLs) > 0 {
+		client := &http.Client{
+			Transport: upstreamTransport(opts),
+			// A redirect, eg to a login page, shows the upstream is available
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		checks = append(checks, middleware.ReadinessCheck{
+			Name: "upstreams",
+			Check: func(ctx context.Context) error {
+				var errs []string
+				for _, pool := range proxy.upstreamPools {
+					if err := pool.CheckHealth(ctx); err != nil {
+						errs = append(errs, err.Error())
+					}
+				}
+				for _, u := range proxy.upstreamURLs {
+					if err := checkUpstream(ctx, client, u); err != nil {
+						errs = append(errs, err.Error())
+					}
+				}
+				if len(errs) > 0 {
+					return errors.New(strings.Join(errs, "; "))
+				}
+				return nil
+			},
+		})
+	}
+	return checks
+}
+
+// checkUpstream sends a HEAD request to an upstream that isn't balanced in a
+// pool. Any response but a 5xx shows that it is available, as the upstream
+// may well answer 404 or 401 on its root.
+func checkUpstream(ctx context.Context, client *http.Client, u *url.URL) error {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("HEAD %s: unexpected status %d", u, resp.StatusCode)
+	}
+	return nil
+}
+
+// providerCheckURL returns the URL of the keys of an OIDC provider, or of its
+// discovery document if they are discovered, or "" if it isn't an OIDC
+// provider
+func providerCheckURL(issuerURL, jwksURL string) string {
+	switch {
+	case jwksURL != "":
+		return jwksURL
+	case issuerURL != "":
+		return strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+	default:
+		return ""
+	}
+}
+
+// providerReadinessCheck checks that the URL responds with a 2xx status. The
+// result is cached for providerCheckCacheTTL.
+func providerReadinessCheck(name, u string) middleware.ReadinessCheck {
+	check := &cachedCheck{
+		ttl: providerCheckCacheTTL,
+		now: time.Now,
+		check: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return fmt.Errorf("GET %s: unexpected status %d", u, resp.StatusCode)
+			}
+			return nil
+		},
+	}
+	return middleware.ReadinessCheck{
+		Name:  name,
+		Check: check.Check,
+	}
+}
+
+// cachedCheck reuses the result of a check for ttl. Concurrent readiness
+// requests wait for the check in progress rather than running it again.
+type cachedCheck struct {
+	ttl   time.Duration
+	now   func() time.Time
+	check func(context.Context) error
+
+	mutex     sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+func (c *cachedCheck) Check(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	if !c.checkedAt.IsZero() && now.Sub(c.checkedAt) < c.ttl {
+		return c.err
+	}
+	err := c.check(ctx)
+	// Don't cache the result of a check cut short by its request
+	if ctx.Err() == nil {
+		c.checkedAt, c.err = now, err
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	sessionsapi "github.com/oauth2-proxy/oauth2-proxy/pkg/apis/sessions"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/upstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHealthCheckingSessionStore struct {
+	sessionsapi.SessionStore
+	err error
+}
+
+func (f *fakeHealthCheckingSessionStore) CheckHealth(context.Context) error {
+	return f.err
+}
+
+func TestReadinessChecks(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration", "/keys":
+			rw.Write([]byte("{}"))
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer provider.Close()
+
+	pool, err := upstream.NewPool([]*url.URL{{Scheme: "http", Host: "a"}}, options.UpstreamPool{Strategy: upstream.RoundRobin}, nil, func(*url.URL) http.Handler {
+		return http.NotFoundHandler()
+	})
+	require.NoError(t, err)
+
+	upstreamStatus := http.StatusNotFound
+	upstreamServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "HEAD", req.Method)
+		rw.WriteHeader(upstreamStatus)
+	}))
+	defer upstreamServer.Close()
+	upstreamURL, err := url.Parse(upstreamServer.URL + "/app/")
+	require.NoError(t, err)
+
+	opts := options.NewOptions()
+	opts.OIDCIssuerURL = provider.URL + "/"
+	opts.Providers = []options.Provider{
+		{ID: "jwks", OIDCJwksURL: provider.URL + "/keys"},
+		{ID: "missing", OIDCIssuerURL: provider.URL + "/missing"},
+		{ID: "github"},
+	}
+	proxy := &OAuthProxy{
+		sessionStore:  &fakeHealthCheckingSessionStore{err: errors.New("connection refused")},
+		upstreamPools: []*upstream.Pool{pool},
+		upstreamURLs:  []*url.URL{upstreamURL},
+	}
+	ready := true
+	runChecks := func() map[string]string {
+		results := make(map[string]string)
+		for _, check := range readinessChecks(opts, proxy, func() bool { return ready }) {
+			results[check.Name] = ""
+			if err := check.Check(context.Background()); err != nil {
+				results[check.Name] = err.Error()
+			}
+		}
+		return results
+	}
+
+	assert.Equal(t, map[string]string{
+		"shutdown":      "",
+		"session_store": "connection refused",
+	}, runChecks())
+
+	opts.ReadyCheckDependencies = true
+	assert.Equal(t, map[string]string{
+		"shutdown":         "",
+		"session_store":    "connection refused",
+		"provider":         "",
+		"provider:jwks":    "",
+		"provider:missing": "GET " + provider.URL + "/missing/.well-known/openid-configuration: unexpected status 404",
+		"upstreams":        "",
+	}, runChecks())
+
+	upstreamStatus = http.StatusServiceUnavailable
+	assert.Equal(t, "HEAD "+upstreamURL.String()+": unexpected status 503", runChecks()["upstreams"])
+
+	ready = false
+	checks := readinessChecks(opts, &OAuthProxy{}, func() bool { return ready })
+	require.Len(t, checks, 4)
+	assert.EqualError(t, checks[0].Check(context.Background()), "shutting down")
+}
+
+func TestCachedCheck(t *testing.T) {
+	now := time.Unix(0, 0)
+	calls := 0
+	err := errors.New("unavailable")
+	check := &cachedCheck{
+		ttl: 10 * time.Second,
+		now: func() time.Time { return now },
+		check: func(context.Context) error {
+			calls++
+			return err
+		},
+	}
+
+	assert.EqualError(t, check.Check(context.Background()), "unavailable")
+	err = nil
+	now = now.Add(9 * time.Second)
+	assert.EqualError(t, check.Check(context.Background()), "unavailable")
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(time.Second)
+	assert.NoError(t, check.Check(context.Background()))
+	assert.Equal(t, 2, calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	now = now.Add(10 * time.Second)
+	err = ctx.Err()
+	assert.Equal(t, context.Canceled, check.Check(ctx))
+	err = nil
+	assert.NoError(t, check.Check(context.Background()))
+	assert.Equal(t, 4, calls)
+}
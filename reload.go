@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/logger"
+)
+
+// restartOptions only take effect when oauth2-proxy is restarted, as they
+// configure the listeners rather than the handler
+var restartOptions = map[string]struct{}{
+	"http_address":          {},
+	"https_address":         {},
+	"serve_http_with_https": {},
+	"tls_cert_file":         {},
+	"tls_key_file":          {},
+	"tls_client_ca_file":    {},
+	"tls_client_auth":       {},
+	"tls_sni_certs":         {},
+	"tls_min_version":       {},
+	"tls_max_version":       {},
+	"tls_cipher_suites":     {},
+	"metrics_address":       {},
+	"shutdown_drain_period": {},
+	"shutdown_timeout":      {},
+}
+
+// configReloader reloads the options and replaces the handler of the server
+// with one built from them. The current handler is kept if the options are
+// invalid or the handler cannot be built.
+type configReloader struct {
+	mu      sync.Mutex
+	load    func() (*options.Options, error)
+	build   func(*options.Options) (http.Handler, func(), error)
+	server  *Server
+	opts    *options.Options
+	stopOld func()
+	stopped bool
+
+	// replaced counts the handlers that have been replaced but not yet
+	// stopped, as their requests in flight have not completed
+	replaced sync.WaitGroup
+}
+
+// Reload reloads the options and swaps the handler of the server
+func (r *configReloader) Reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+
+	opts, err := r.load()
+	if err != nil {
+		logger.Printf("ERROR: Failed to reload config, keeping the current config: %v", err)
+		return
+	}
+	handler, stop, err := r.build(opts)
+	if err != nil {
+		logger.Printf("ERROR: Failed to reload config, keeping the current config: %v", err)
+		return
+	}
+
+	// The previous handler is stopped once its requests in flight have
+	// completed, as they may still use its session store
+	idle := r.server.SetHandler(handler)
+	stopOld := r.stopOld
+	r.replaced.Add(1)
+	go func() {
+		defer r.replaced.Done()
+		<-idle
+		stopOld()
+	}()
+
+	changed := options.ChangedOptions(r.opts, opts)
+	r.opts, r.stopOld = opts, stop
+	if len(changed) == 0 {
+		logger.Printf("reloaded config: no options changed")
+		return
+	}
+	logger.Printf("reloaded config: changed %s", strings.Join(changed, ", "))
+
+	var needRestart []string
+	for _, name := range changed {
+		if _, ok := restartOptions[name]; ok {
+			needRestart = append(needRestart, name)
+		}
+	}
+	if len(needRestart) > 0 {
+		logger.Printf("WARNING: %s only take effect after a restart", strings.Join(needRestart, ", "))
+	}
+}
+
+// Stop stops the current handler once the server has shut down, eg so that
+// the memory session store writes its snapshot, and waits for the handlers
+// replaced before to be stopped. The configuration is no longer reloaded.
+func (r *configReloader) Stop() {
+	r.mu.Lock()
+	if !r.stopped {
+		r.stopped = true
+		r.stopOld()
+	}
+	r.mu.Unlock()
+	r.replaced.Wait()
+}
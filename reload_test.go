@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/oauth2-proxy/oauth2-proxy/pkg/apis/options"
+	"github.com/stretchr/testify/assert"
+)
+
+func textHandler(text string) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(text))
+	})
+}
+
+func serveText(s *Server) string {
+	rw := httptest.NewRecorder()
+	s.serveRequest(rw, httptest.NewRequest("GET", "/", nil))
+	return rw.Body.String()
+}
+
+func TestConfigReloader(t *testing.T) {
+	initial := options.NewOptions()
+	reloaded := options.NewOptions()
+	reloaded.Upstreams = []string{"http://127.0.0.1:8081/"}
+
+	testCases := []struct {
+		name            string
+		loadErr         error
+		buildErr        error
+		expectedText    string
+		expectedOpts    *options.Options
+		expectedStopped bool
+	}{
+		{
+			name:            "with valid options",
+			expectedText:    "reloaded",
+			expectedOpts:    reloaded,
+			expectedStopped: true,
+		},
+		{
+			name:         "with invalid options",
+			loadErr:      errors.New("invalid configuration"),
+			expectedText: "initial",
+			expectedOpts: initial,
+		},
+		{
+			name:         "when the handler cannot be built",
+			buildErr:     errors.New("error initialising session store"),
+			expectedText: "initial",
+			expectedOpts: initial,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Server{Handler: textHandler("initial"), Opts: initial}
+			stopped := false
+			r := &configReloader{
+				load: func() (*options.Options, error) {
+					if tc.loadErr != nil {
+						return nil, tc.loadErr
+					}
+					return reloaded, nil
+				},
+				build: func(opts *options.Options) (http.Handler, func(), error) {
+					if tc.buildErr != nil {
+						return nil, nil, tc.buildErr
+					}
+					return textHandler("reloaded"), func() {}, nil
+				},
+				server:  s,
+				opts:    initial,
+				stopOld: func() { stopped = true },
+			}
+
+			r.Reload()
+			// Wait for the previous handler to be stopped
+			r.replaced.Wait()
+			assert.Equal(t, tc.expectedText, serveText(s))
+			assert.Equal(t, tc.expectedOpts, r.opts)
+			assert.Equal(t, tc.expectedStopped, stopped)
+			// The listeners are not reconfigured
+			assert.Equal(t, initial, s.Opts)
+		})
+	}
+}
+
+func TestConfigReloaderStop(t *testing.T) {
+	s := &Server{Handler: textHandler("initial"), Opts: options.NewOptions()}
+	stops := 0
+	built := false
+	r := &configReloader{
+		load: func() (*options.Options, error) {
+			return options.NewOptions(), nil
+		},
+		build: func(opts *options.Options) (http.Handler, func(), error) {
+			built = true
+			return textHandler("reloaded"), func() {}, nil
+		},
+		server:  s,
+		opts:    s.Opts,
+		stopOld: func() { stops++ },
+	}
+
+	r.Stop()
+	r.Stop()
+	assert.Equal(t, 1, stops)
+
+	// The configuration is no longer reloaded once stopped
+	r.Reload()
+	assert.False(t, built)
+	assert.Equal(t, "initial", serveText(s))
+}
+
+func TestServerSetHandler(t *testing.T) {
+	s := &Server{Handler: textHandler("initial")}
+	assert.Equal(t, "initial", serveText(s))
+
+	s.SetHandler(textHandler("first"))
+	assert.Equal(t, "first", serveText(s))
+
+	s.SetHandler(textHandler("second"))
+	assert.Equal(t, "second", serveText(s))
+}
+
+func TestServerSetHandlerInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		rw.Write([]byte("initial"))
+	})}
+
+	done := make(chan string)
+	go func() {
+		done <- serveText(s)
+	}()
+	<-started
+
+	idle := s.SetHandler(textHandler("reloaded"))
+	assert.Equal(t, "reloaded", serveText(s))
+	select {
+	case <-idle:
+		t.Fatal("the previous handler is idle while a request is in flight")
+	default:
+	}
+
+	// The request in flight completes with the previous handler
+	close(release)
+	assert.Equal(t, "initial", <-done)
+	<-idle
+}
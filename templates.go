@@ -9,19 +9,25 @@ import (
 )
 
 func loadTemplates(dir string) *template.Template {
+	t, err := parseTemplates(dir)
+	if err != nil {
+		logger.Fatalf("failed parsing template %s", err)
+	}
+	return t
+}
+
+// parseTemplates parses the templates in dir, or returns the default
+// templates if dir is empty
+func parseTemplates(dir string) (*template.Template, error) {
 	if dir == "" {
-		return getTemplates()
+		return getTemplates(), nil
 	}
 	logger.Printf("using custom template directory %q", dir)
 	funcMap := template.FuncMap{
 		"ToUpper": strings.ToUpper,
 		"ToLower": strings.ToLower,
 	}
-	t, err := template.New("").Funcs(funcMap).ParseFiles(path.Join(dir, "sign_in.html"), path.Join(dir, "error.html"))
-	if err != nil {
-		logger.Fatalf("failed parsing template %s", err)
-	}
-	return t
+	return template.New("").Funcs(funcMap).ParseFiles(path.Join(dir, "sign_in.html"), path.Join(dir, "error.html"))
 }
 
 func getTemplates() *template.Template {
@@ -116,13 +122,18 @@ func getTemplates() *template.Template {
 </head>
 <body>
 	<div class="signin center">
-	<form method="GET" action="{{.ProxyPrefix}}/start">
-	<input type="hidden" name="rd" value="{{.Redirect}}">
 	{{ if .SignInMessage }}
 	<p>{{.SignInMessage}}</p>
 	{{ end}}
-	<button type="submit" class="btn">Sign in with {{.ProviderName}}</button><br/>
+	{{ range .Providers }}
+	<form method="GET" action="{{$.ProxyPrefix}}/start">
+	<input type="hidden" name="rd" value="{{$.Redirect}}">
+	{{ if .ID }}
+	<input type="hidden" name="provider" value="{{.ID}}">
+	{{ end }}
+	<button type="submit" class="btn">Sign in with {{.Name}}</button><br/>
 	</form>
+	{{ end }}
 	</div>
 
 	{{ if .CustomLogin }}